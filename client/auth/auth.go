@@ -0,0 +1,206 @@
+// Package auth authenticates the frames an agency sends when the connection
+// is not secured with TLS. Every frame carries a trailer with a sequence
+// number, a timestamp and an HMAC-SHA256 computed with the secret of the
+// agency over the agency, the sequence number, the timestamp, the message
+// type and the payload. Receivers reject frames with a bad MAC, a timestamp
+// too far from their clock or a sequence number they already saw.
+//
+// The trailer is appended to the payload of the frame before it is split in
+// continuation frames:
+//
+//	sequence (8 bytes) | timestamp (8 bytes, Unix milliseconds) | mac (32 bytes)
+//
+// Integers are big-endian.
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+)
+
+// TrailerSize Bytes the trailer adds to the payload of every frame
+const TrailerSize = 8 + 8 + sha256.Size
+
+// DefaultMaxSkew Maximum difference between the timestamp of a frame and the
+// clock of the receiver, unless configured
+const DefaultMaxSkew = 30 * time.Second
+
+// ErrTruncated The frame is too short to carry a trailer
+var ErrTruncated = errors.New("auth: frame without trailer")
+
+// ErrUnknownAgency The receiver has no secret for the agency
+var ErrUnknownAgency = errors.New("auth: unknown agency")
+
+// ErrBadMAC The MAC does not match the frame, which was altered or signed
+// with another secret
+var ErrBadMAC = errors.New("auth: bad mac")
+
+// ErrStale The timestamp of the frame is too far from the clock of the
+// receiver
+var ErrStale = errors.New("auth: stale timestamp")
+
+// ErrReplayed The sequence number was already accepted from the agency
+var ErrReplayed = errors.New("auth: replayed sequence number")
+
+// Secrets Secret of every agency, by agency
+type Secrets map[string][]byte
+
+// LoadSecret Reads a secret from a file, ignoring the trailing newline
+func LoadSecret(path string) ([]byte, error) {
+	secret, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	secret = bytes.TrimRight(secret, "\r\n")
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("auth: empty secret in %v", path)
+	}
+	return secret, nil
+}
+
+// Signer Seals the frames sent by an agency. Sequence numbers are the time
+// of sealing in microseconds, or the previous number plus one if the clock
+// did not advance, so an agency that restarts, or another process of the
+// same agency like its healthcheck, does not reuse the numbers of the frames
+// sent before
+type Signer struct {
+	agency   string
+	secret   []byte
+	now      func() time.Time
+	sequence uint64
+}
+
+// NewSigner Initializes the signer of an agency reading the time from now
+func NewSigner(agency string, secret []byte, now func() time.Time) *Signer {
+	return &Signer{agency: agency, secret: secret, now: now}
+}
+
+// Seal Returns the frame with the trailer appended to its payload
+func (s *Signer) Seal(frame protocol.Frame) protocol.Frame {
+	now := s.now()
+	s.sequence++
+	if micros := uint64(now.UnixNano() / int64(time.Microsecond)); micros > s.sequence {
+		s.sequence = micros
+	}
+	timestamp := uint64(now.UnixNano() / int64(time.Millisecond))
+
+	payload := make([]byte, len(frame.Payload), len(frame.Payload)+TrailerSize)
+	copy(payload, frame.Payload)
+	var header [16]byte
+	binary.BigEndian.PutUint64(header[:8], s.sequence)
+	binary.BigEndian.PutUint64(header[8:], timestamp)
+	payload = append(payload, header[:]...)
+	payload = append(payload, sum(s.secret, s.agency, frame.Type, frame.Payload, header[:])...)
+	return protocol.Frame{Type: frame.Type, Payload: payload}
+}
+
+// Trailer Fields of the trailer of a frame
+type Trailer struct {
+	Sequence  uint64
+	Timestamp time.Time
+	MAC       []byte
+}
+
+// Split Separates a sealed frame from its trailer without verifying it. It
+// lets receivers read the agency a hello claims before opening it
+func Split(frame protocol.Frame) (protocol.Frame, Trailer, error) {
+	if len(frame.Payload) < TrailerSize {
+		return protocol.Frame{}, Trailer{}, ErrTruncated
+	}
+	end := len(frame.Payload) - TrailerSize
+	header := frame.Payload[end : end+16]
+	milliseconds := int64(binary.BigEndian.Uint64(header[8:]))
+	trailer := Trailer{
+		Sequence:  binary.BigEndian.Uint64(header[:8]),
+		Timestamp: time.Unix(0, milliseconds*int64(time.Millisecond)),
+		MAC:       frame.Payload[end+16:],
+	}
+	return protocol.Frame{Type: frame.Type, Payload: frame.Payload[:end]}, trailer, nil
+}
+
+// Verifier Opens the frames of every agency it has a secret for. It is safe
+// for concurrent use by the connections of a server
+type Verifier struct {
+	secrets Secrets
+	maxSkew time.Duration
+	now     func() time.Time
+
+	mu sync.Mutex
+	// seen Timestamp of every sequence number accepted from every agency,
+	// while it is within the window
+	seen map[string]map[uint64]time.Time
+}
+
+// NewVerifier Initializes a verifier that accepts timestamps at most maxSkew
+// away from now. A maxSkew of zero means DefaultMaxSkew
+func NewVerifier(secrets Secrets, maxSkew time.Duration, now func() time.Time) *Verifier {
+	if maxSkew <= 0 {
+		maxSkew = DefaultMaxSkew
+	}
+	return &Verifier{secrets: secrets, maxSkew: maxSkew, now: now, seen: make(map[string]map[uint64]time.Time)}
+}
+
+// Open Verifies a frame sent by the agency and returns it without its
+// trailer. Every sequence number is accepted once, in any order: the
+// processes of an agency, like the client and its healthcheck, seal frames
+// that may arrive on their connections after frames they sealed later. A
+// sequence number is remembered until its timestamp leaves the window, from
+// then on a replay of its frame is rejected as stale
+func (v *Verifier) Open(agency string, frame protocol.Frame) (protocol.Frame, error) {
+	opened, trailer, err := Split(frame)
+	if err != nil {
+		return protocol.Frame{}, err
+	}
+	secret, ok := v.secrets[agency]
+	if !ok {
+		return protocol.Frame{}, fmt.Errorf("%w %q", ErrUnknownAgency, agency)
+	}
+	header := frame.Payload[len(opened.Payload) : len(opened.Payload)+16]
+	if !hmac.Equal(trailer.MAC, sum(secret, agency, frame.Type, opened.Payload, header)) {
+		return protocol.Frame{}, ErrBadMAC
+	}
+	if skew := v.now().Sub(trailer.Timestamp); skew > v.maxSkew || skew < -v.maxSkew {
+		return protocol.Frame{}, fmt.Errorf("%w: %v away from the clock of the receiver", ErrStale, skew)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	seen, ok := v.seen[agency]
+	if !ok {
+		seen = make(map[uint64]time.Time)
+		v.seen[agency] = seen
+	}
+	for sequence, timestamp := range seen {
+		if v.now().Sub(timestamp) > v.maxSkew {
+			delete(seen, sequence)
+		}
+	}
+	if _, ok := seen[trailer.Sequence]; ok {
+		return protocol.Frame{}, fmt.Errorf("%w: %v", ErrReplayed, trailer.Sequence)
+	}
+	seen[trailer.Sequence] = trailer.Timestamp
+	return opened, nil
+}
+
+// sum Computes the MAC of a frame. The agency is length prefixed so no other
+// agency and payload can produce the same input
+func sum(secret []byte, agency string, msgType protocol.MessageType, payload []byte, header []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	var length [2]byte
+	binary.BigEndian.PutUint16(length[:], uint16(len(agency)))
+	mac.Write(length[:])
+	mac.Write([]byte(agency))
+	mac.Write(header)
+	mac.Write([]byte{byte(msgType)})
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
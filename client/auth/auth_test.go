@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+)
+
+var epoch = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// fixedClock Clock that only moves when told to
+type fixedClock struct {
+	now time.Time
+}
+
+func (c *fixedClock) Now() time.Time {
+	return c.now
+}
+
+func bets() protocol.Frame {
+	return protocol.Frame{Type: protocol.TypeBets, Payload: []byte("1\n1|Santiago|Lorca|30904465|1999-03-17|7574")}
+}
+
+func TestSealedFramesOpenToTheOriginal(t *testing.T) {
+	clock := &fixedClock{now: epoch}
+	signer := NewSigner("1", []byte("secret-1"), clock.Now)
+	verifier := NewVerifier(Secrets{"1": []byte("secret-1")}, 0, clock.Now)
+
+	for _, frame := range []protocol.Frame{bets(), {Type: protocol.TypeDeliveryEnded, Payload: []byte("1")}, {Type: protocol.TypeAck}} {
+		sealed := signer.Seal(frame)
+		if len(sealed.Payload) != len(frame.Payload)+TrailerSize {
+			t.Fatalf("expected a %v bytes trailer, got %v bytes", TrailerSize, len(sealed.Payload)-len(frame.Payload))
+		}
+		opened, err := verifier.Open("1", sealed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opened.Type != frame.Type || !bytes.Equal(opened.Payload, frame.Payload) {
+			t.Fatalf("expected %+v, got %+v", frame, opened)
+		}
+	}
+
+	// Sealing does not alter the frame it receives
+	frame := bets()
+	payload := append([]byte(nil), frame.Payload...)
+	signer.Seal(frame)
+	if !bytes.Equal(frame.Payload, payload) {
+		t.Fatal("the original frame was modified")
+	}
+}
+
+func TestTamperedFramesAreRejected(t *testing.T) {
+	clock := &fixedClock{now: epoch}
+	secrets := Secrets{"1": []byte("secret-1"), "2": []byte("secret-2")}
+	sealed := NewSigner("1", secrets["1"], clock.Now).Seal(bets())
+	end := len(sealed.Payload) - TrailerSize
+
+	tamper := func(change func(frame *protocol.Frame)) protocol.Frame {
+		frame := protocol.Frame{Type: sealed.Type, Payload: append([]byte(nil), sealed.Payload...)}
+		change(&frame)
+		return frame
+	}
+	tests := []struct {
+		name     string
+		agency   string
+		frame    protocol.Frame
+		expected error
+	}{
+		{name: "payload", agency: "1", frame: tamper(func(f *protocol.Frame) { f.Payload[3] ^= 1 }), expected: ErrBadMAC},
+		{name: "type", agency: "1", frame: tamper(func(f *protocol.Frame) { f.Type = protocol.TypeDeliveryEnded }), expected: ErrBadMAC},
+		{name: "sequence", agency: "1", frame: tamper(func(f *protocol.Frame) { f.Payload[end+7]++ }), expected: ErrBadMAC},
+		{name: "timestamp", agency: "1", frame: tamper(func(f *protocol.Frame) { f.Payload[end+15]++ }), expected: ErrBadMAC},
+		{name: "mac", agency: "1", frame: tamper(func(f *protocol.Frame) { f.Payload[len(f.Payload)-1] ^= 1 }), expected: ErrBadMAC},
+		{name: "claimed by another agency", agency: "2", frame: sealed, expected: ErrBadMAC},
+		{name: "unknown agency", agency: "3", frame: sealed, expected: ErrUnknownAgency},
+		{name: "truncated", agency: "1", frame: tamper(func(f *protocol.Frame) { f.Payload = f.Payload[:TrailerSize-1] }), expected: ErrTruncated},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			verifier := NewVerifier(secrets, 0, clock.Now)
+			if _, err := verifier.Open(test.agency, test.frame); !errors.Is(err, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, err)
+			}
+		})
+	}
+
+	// A rejected frame does not consume its sequence number
+	verifier := NewVerifier(secrets, 0, clock.Now)
+	verifier.Open("1", tests[0].frame)
+	if _, err := verifier.Open("1", sealed); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStaleTimestampsAreRejected(t *testing.T) {
+	signerClock := &fixedClock{now: epoch}
+	signer := NewSigner("1", []byte("secret-1"), signerClock.Now)
+
+	tests := []struct {
+		name     string
+		skew     time.Duration
+		expected error
+	}{
+		{name: "on time", skew: 0},
+		{name: "late within the skew", skew: 10 * time.Second},
+		{name: "early within the skew", skew: -10 * time.Second},
+		{name: "too late", skew: 11 * time.Second, expected: ErrStale},
+		{name: "too early", skew: -11 * time.Second, expected: ErrStale},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			receiverClock := &fixedClock{now: epoch.Add(test.skew)}
+			verifier := NewVerifier(Secrets{"1": []byte("secret-1")}, 10*time.Second, receiverClock.Now)
+			if _, err := verifier.Open("1", signer.Seal(bets())); !errors.Is(err, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, err)
+			}
+		})
+	}
+}
+
+func TestReplayedSequenceNumbersAreRejected(t *testing.T) {
+	clock := &fixedClock{now: epoch}
+	secrets := Secrets{"1": []byte("secret-1"), "2": []byte("secret-2")}
+	verifier := NewVerifier(secrets, 0, clock.Now)
+	signer := NewSigner("1", secrets["1"], clock.Now)
+
+	first, second := signer.Seal(bets()), signer.Seal(bets())
+	if _, err := verifier.Open("1", second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifier.Open("1", second); !errors.Is(err, ErrReplayed) {
+		t.Fatalf("expected the same frame to be rejected twice, got %v", err)
+	}
+	// A frame sealed before is still accepted once
+	if _, err := verifier.Open("1", first); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifier.Open("1", first); !errors.Is(err, ErrReplayed) {
+		t.Fatalf("expected the older frame to be rejected twice, got %v", err)
+	}
+
+	// Every agency has its own sequence
+	if _, err := verifier.Open("2", NewSigner("2", secrets["2"], clock.Now).Seal(bets())); err != nil {
+		t.Fatal(err)
+	}
+
+	// An agency that restarts, or another process of the agency, keeps
+	// numbering above the frames sent before
+	clock.now = clock.now.Add(time.Millisecond)
+	if _, err := verifier.Open("1", NewSigner("1", secrets["1"], clock.Now).Seal(bets())); err != nil {
+		t.Fatal(err)
+	}
+	clock.now = clock.now.Add(time.Millisecond)
+	if _, err := verifier.Open("1", signer.Seal(bets())); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestFramesOfTwoProcessesArriveInterleaved Opens the frames of the client
+// and the healthcheck of an agency in another order than they were sealed,
+// as their connections deliver them
+func TestFramesOfTwoProcessesArriveInterleaved(t *testing.T) {
+	clock := &fixedClock{now: epoch}
+	secrets := Secrets{"1": []byte("secret-1")}
+	verifier := NewVerifier(secrets, 10*time.Second, clock.Now)
+	client := NewSigner("1", secrets["1"], clock.Now)
+	healthcheck := NewSigner("1", secrets["1"], clock.Now)
+
+	var sealed []protocol.Frame
+	for i := 0; i < 3; i++ {
+		sealed = append(sealed, client.Seal(bets()))
+		clock.now = clock.now.Add(time.Millisecond)
+		sealed = append(sealed, healthcheck.Seal(bets()))
+		clock.now = clock.now.Add(time.Millisecond)
+	}
+	for _, i := range []int{1, 3, 0, 5, 2, 4} {
+		if _, err := verifier.Open("1", sealed[i]); err != nil {
+			t.Fatalf("frame %v: %v", i, err)
+		}
+	}
+	for i, frame := range sealed {
+		if _, err := verifier.Open("1", frame); !errors.Is(err, ErrReplayed) {
+			t.Fatalf("frame %v: expected a replay to be rejected, got %v", i, err)
+		}
+	}
+
+	// Once out of the window the frames are forgotten, and stale
+	clock.now = clock.now.Add(11 * time.Second)
+	if _, err := verifier.Open("1", sealed[0]); !errors.Is(err, ErrStale) {
+		t.Fatalf("expected the frame to be stale, got %v", err)
+	}
+	if _, err := verifier.Open("1", client.Seal(bets())); err != nil {
+		t.Fatal(err)
+	}
+	if seen := len(verifier.seen["1"]); seen != 1 {
+		t.Fatalf("expected only the last frame to be remembered, got %v", seen)
+	}
+}
+
+func TestLoadSecret(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "secret")
+	if err := os.WriteFile(path, []byte("s3cr3t\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := LoadSecret(path)
+	if err != nil || string(secret) != "s3cr3t" {
+		t.Fatalf("expected the secret without the newline, got %q %v", secret, err)
+	}
+
+	empty := filepath.Join(dir, "empty")
+	if err := os.WriteFile(empty, []byte("\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSecret(empty); err == nil {
+		t.Fatal("expected an empty secret to be rejected")
+	}
+	if _, err := LoadSecret(filepath.Join(dir, "missing")); err == nil {
+		t.Fatal("expected a missing file to fail")
+	}
+}
@@ -0,0 +1,129 @@
+// Package capture reads and writes capture files: the frames exchanged
+// between a client and the server, in the order they were sent or received.
+//
+// A capture file holds one JSON record per line with the time the frame went
+// through the socket, the number of the client connection it belonged to,
+// its direction and its bytes on the wire, header included but without the
+// checksum and authentication trailers:
+//
+//	{"time":"2024-08-21T22:11:15.123Z","conn":1,"direction":"sent","frame":"AAkBMQox..."}
+package capture
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+)
+
+// Direction Whether a frame was sent or received by the client
+type Direction string
+
+const (
+	// Sent A frame written by the client
+	Sent Direction = "sent"
+	// Received A frame read by the client
+	Received Direction = "received"
+)
+
+// Record A single frame of a capture
+type Record struct {
+	Time time.Time `json:"time"`
+	// Conn Number of the client connection, starting at 1
+	Conn      int       `json:"conn"`
+	Direction Direction `json:"direction"`
+	// Frame Bytes of the frame on the wire, header included
+	Frame []byte `json:"frame"`
+}
+
+// framing Captures hold frames of any size, split in continuation frames
+// as they were on the wire
+var framing = protocol.Framing{MaxSize: math.MaxInt32, Continuation: true}
+
+// NewRecord Builds the record of a frame
+func NewRecord(t time.Time, conn int, direction Direction, frame protocol.Frame) (Record, error) {
+	var buf bytes.Buffer
+	if err := framing.WriteFrame(&buf, frame); err != nil {
+		return Record{}, err
+	}
+	return Record{Time: t, Conn: conn, Direction: direction, Frame: buf.Bytes()}, nil
+}
+
+// Decode Parses the bytes of the record back into a frame
+func (r Record) Decode() (protocol.Frame, error) {
+	reader := bytes.NewReader(r.Frame)
+	frame, err := framing.ReadFrame(reader)
+	if err != nil {
+		return frame, err
+	}
+	if reader.Len() > 0 {
+		return frame, fmt.Errorf("%v bytes after the end of the frame", reader.Len())
+	}
+	return frame, nil
+}
+
+// Writer Appends records to a capture file. It is safe for concurrent use
+type Writer struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewWriter Writes the capture to w
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{enc: json.NewEncoder(w)}
+}
+
+// Write Appends a record to the capture
+func (w *Writer) Write(r Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.enc.Encode(r)
+}
+
+// Reader Reads the records of a capture file in order
+type Reader struct {
+	dec  *json.Decoder
+	line int
+}
+
+// NewReader Reads the capture from r
+func NewReader(r io.Reader) *Reader {
+	return &Reader{dec: json.NewDecoder(r)}
+}
+
+// Read Returns the next record, or io.EOF once the capture is over
+func (r *Reader) Read() (Record, error) {
+	var record Record
+	if !r.dec.More() {
+		return record, io.EOF
+	}
+	r.line++
+	if err := r.dec.Decode(&record); err != nil {
+		return record, fmt.Errorf("record %v: %w", r.line, err)
+	}
+	if record.Direction != Sent && record.Direction != Received {
+		return record, fmt.Errorf("record %v: invalid direction %q", r.line, record.Direction)
+	}
+	return record, nil
+}
+
+// ReadAll Reads every record of a capture
+func ReadAll(r io.Reader) ([]Record, error) {
+	reader := NewReader(r)
+	var records []Record
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, record)
+	}
+}
@@ -0,0 +1,76 @@
+package capture
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+)
+
+func TestCaptureRoundTrip(t *testing.T) {
+	at := time.Date(2024, 8, 21, 22, 11, 15, 123000000, time.UTC)
+	frames := []protocol.Frame{
+		{Type: protocol.TypeBets, Payload: []byte("1\n1|Santiago Lionel|Lorca|30904465|1999-03-17|7574")},
+		{Type: protocol.TypeAck, Payload: []byte("1|1")},
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	var written []Record
+	for i, frame := range frames {
+		direction := Sent
+		if i%2 == 1 {
+			direction = Received
+		}
+		record, err := NewRecord(at.Add(time.Duration(i)*time.Millisecond), 1, direction, frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Write(record); err != nil {
+			t.Fatal(err)
+		}
+		written = append(written, record)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 2 {
+		t.Fatalf("expected a record per line, got:\n%s", buf.String())
+	}
+
+	records, err := ReadAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(records, written) {
+		t.Fatalf("expected %+v, got %+v", written, records)
+	}
+	for i, record := range records {
+		frame, err := record.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(frame, frames[i]) {
+			t.Fatalf("expected %+v, got %+v", frames[i], frame)
+		}
+	}
+}
+
+func TestReadRejectsInvalidRecords(t *testing.T) {
+	for _, capture := range []string{
+		`{"time":"2024-08-21T22:11:15Z","conn":1,"direction":"sideways","frame":"AAIC"}`,
+		`{"time":"2024-08-21T22:11:15Z","conn":1,`,
+		`not json`,
+	} {
+		if _, err := ReadAll(strings.NewReader(capture)); err == nil {
+			t.Fatalf("expected %q to be invalid", capture)
+		}
+	}
+}
+
+func TestDecodeRejectsTrailingBytes(t *testing.T) {
+	record := Record{Direction: Sent, Frame: []byte{0, 1, byte(protocol.TypeWinnersPending), 0xff}}
+	if _, err := record.Decode(); err == nil {
+		t.Fatal("expected trailing bytes to be rejected")
+	}
+}
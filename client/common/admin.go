@@ -0,0 +1,65 @@
+package common
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/metrics"
+)
+
+// AdminServer Optional HTTP listener that exposes the client internals.
+// It is meant for operators and scrapers, never for the agency traffic
+type AdminServer struct {
+	address  string
+	mux      *http.ServeMux
+	server   *http.Server
+	listener net.Listener
+}
+
+// NewAdminServer Initializes the admin server. Nothing is listening until
+// Start is called
+func NewAdminServer(address string, registry *metrics.Registry, health *Health) *AdminServer {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry)
+	mux.HandleFunc("/healthz", health.ServeLiveness)
+	mux.HandleFunc("/readyz", health.ServeReadiness)
+
+	return &AdminServer{
+		address: address,
+		mux:     mux,
+		server: &http.Server{
+			Handler:           mux,
+			ReadHeaderTimeout: 5 * time.Second,
+		},
+	}
+}
+
+// Start Binds the admin address and serves requests in background
+func (a *AdminServer) Start() error {
+	listener, err := net.Listen("tcp", a.address)
+	if err != nil {
+		return errors.Wrapf(err, "Could not listen on admin address %v", a.address)
+	}
+	a.listener = listener
+
+	go func() {
+		if err := a.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Errorf("action: admin_serve | result: fail | error: %v", err)
+		}
+	}()
+	log.Infof("action: admin_serve | result: in_progress | address: %v", listener.Addr())
+	return nil
+}
+
+// Addr Returns the address the server is bound to. Only valid after Start
+func (a *AdminServer) Addr() string {
+	return a.listener.Addr().String()
+}
+
+// Close Stops the listener and every in-flight request
+func (a *AdminServer) Close() error {
+	return a.server.Close()
+}
@@ -0,0 +1,93 @@
+package common
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/metrics"
+)
+
+func scrape(t *testing.T, address string) string {
+	t.Helper()
+	resp, err := http.Get("http://" + address + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestAdminServerExposesEveryClientMetric(t *testing.T) {
+	registry := metrics.NewRegistry()
+	NewClientMetrics(registry)
+
+	admin := NewAdminServer("127.0.0.1:0", registry, NewHealth())
+	if err := admin.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+
+	body := scrape(t, admin.Addr())
+	for _, name := range []string{
+		MetricBetsRead,
+		MetricBetsSent,
+		MetricBetsRejected,
+		MetricBatchesAcked,
+		MetricReconnects,
+		MetricBytesSent,
+		MetricBytesReceived,
+	} {
+		if !strings.Contains(body, "# TYPE "+name+" counter\n") {
+			t.Errorf("counter %s missing from scrape", name)
+		}
+	}
+	if !strings.Contains(body, "# TYPE "+MetricRTT+" histogram\n") {
+		t.Errorf("histogram %s missing from scrape", MetricRTT)
+	}
+}
+
+func TestClientLoopUpdatesScrapedMetrics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	clientMetrics := NewClientMetrics(registry)
+
+	admin := NewAdminServer("127.0.0.1:0", registry, NewHealth())
+	if err := admin.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+
+	client := NewClient(ClientConfig{
+		ID:            "1",
+		ServerAddress: startEchoServer(t),
+		LoopAmount:    3,
+		LoopPeriod:    time.Millisecond,
+	}, clientMetrics)
+	client.StartClientLoop()
+
+	// Every message is echoed back, so both directions carry the same bytes
+	message := "[CLIENT 1] Message N°1\n"
+	bytesPerLoop := len(message)
+
+	body := scrape(t, admin.Addr())
+	for _, line := range []string{
+		MetricReconnects + " 2\n",
+		MetricRTT + "_count 3\n",
+		MetricBytesSent + " " + strconv.Itoa(3*bytesPerLoop) + "\n",
+		MetricBytesReceived + " " + strconv.Itoa(3*bytesPerLoop) + "\n",
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("expected %q in scrape:\n%s", line, body)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package common
+
+import (
+	"errors"
+	"io"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/lottery"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+)
+
+// ErrWinnersPending The winners were asked for before the lottery took place
+var ErrWinnersPending = errors.New("the lottery has not taken place yet")
+
+// SendBets Sends the bets of the agency in as many batches as needed and
+// returns the documents of the bets the server rejected. Bets that do not
+// validate are skipped, like the invalid rows of a dataset. The connection
+// stays open until Close
+func (c *Client) SendBets(bets []lottery.Bet) ([]string, error) {
+	return c.sendBatches(&sliceReader{bets: bets})
+}
+
+// Winners Asks once for the winners of the agency. Returns ErrWinnersPending
+// if the lottery has not taken place yet
+func (c *Client) Winners() ([]string, error) {
+	c.mu.Lock()
+	response, err := c.request(protocol.WinnersQuery{Agency: c.config.ID})
+	c.closeConnection()
+	c.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	switch r := response.(type) {
+	case protocol.Winners:
+		return r.Documents, nil
+	case protocol.WinnersPending:
+		return nil, ErrWinnersPending
+	}
+	return nil, unexpectedResponse(response)
+}
+
+// SubmitBet Sends a single bet in a batch of its own, in between the batches
+// of the dataset. Returns the id of the batch and whether the server
+// rejected the bet
+func (c *Client) SubmitBet(bet lottery.Bet) (uint32, bool, error) {
+	if err := bet.Validate(); err != nil {
+		return 0, false, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.batchID++
+	rejected, err := c.sendBatch(protocol.Bets{BatchID: c.batchID, Bets: []lottery.Bet{bet}})
+	return c.batchID, len(rejected) > 0, err
+}
+
+// Close Closes the connection to the server, if any
+func (c *Client) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closeConnection()
+}
+
+// sliceReader lottery.Reader over bets already in memory
+type sliceReader struct {
+	bets []lottery.Bet
+	next int
+}
+
+func (r *sliceReader) Read() (lottery.Bet, error) {
+	if r.next >= len(r.bets) {
+		return lottery.Bet{}, io.EOF
+	}
+	bet := r.bets[r.next]
+	r.next++
+	if err := bet.Validate(); err != nil {
+		return lottery.Bet{}, &lottery.RowError{Line: r.next, Err: err}
+	}
+	return bet, nil
+}
@@ -0,0 +1,111 @@
+package common
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/auth"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/metrics"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+)
+
+var agencySecrets = auth.Secrets{"1": []byte("secret-1"), "2": []byte("secret-2")}
+
+func TestFramesAreAuthenticatedWithTheAgencySecret(t *testing.T) {
+	server := startFakeServer(t)
+	server.RequireAuth(auth.NewVerifier(agencySecrets, 0, time.Now))
+
+	config := betsConfig(server.Addr(), writeDataset(t, testDataset))
+	config.AuthSecret = agencySecrets["1"]
+	if err := NewClient(config, NewClientMetrics(metrics.NewRegistry())).StartClientLoop(); err != nil {
+		t.Fatal(err)
+	}
+	if errs := server.AuthErrors(); len(errs) != 0 {
+		t.Fatalf("expected every frame to be accepted, got %v", errs)
+	}
+	if frames := framesOfType(server, protocol.TypeBets); frames == 0 {
+		t.Fatal("expected the batches to reach the server")
+	}
+
+	if err := ProbeLotteryServer(server.Addr(), nil, agencySecrets["1"], "1", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := ProbeLotteryServer(server.Addr(), nil, nil, "1", time.Second); err == nil {
+		t.Fatal("expected a probe without the secret to fail")
+	}
+}
+
+func TestFramesWithTheWrongSecretAreRejected(t *testing.T) {
+	server := startFakeServer(t)
+	server.RequireAuth(auth.NewVerifier(agencySecrets, 0, time.Now))
+	quietLogs(t)
+
+	// Agency 2 cannot send as agency 1 with its own secret
+	config := betsConfig(server.Addr(), writeDataset(t, testDataset))
+	config.AuthSecret = agencySecrets["2"]
+	if err := NewClient(config, NewClientMetrics(metrics.NewRegistry())).StartClientLoop(); err == nil {
+		t.Fatal("expected the client to fail")
+	}
+	errs := server.AuthErrors()
+	if len(errs) != config.RetryAttempts {
+		t.Fatalf("expected a rejected hello per attempt, got %v", errs)
+	}
+	for _, err := range errs {
+		if !errors.Is(err, auth.ErrBadMAC) {
+			t.Fatalf("expected a bad mac, got %v", err)
+		}
+	}
+	if received := server.Received(); len(received) != 0 {
+		t.Fatalf("expected no frames to be accepted, got %v", len(received))
+	}
+}
+
+func TestReplayedFramesAreRejected(t *testing.T) {
+	server := startFakeServer(t)
+	server.RequireAuth(auth.NewVerifier(agencySecrets, 0, time.Now))
+	signer := auth.NewSigner("1", agencySecrets["1"], time.Now)
+	hello, err := protocol.Encode(protocol.Hello{Version: protocol.Version, Agency: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ended, err := protocol.Encode(protocol.DeliveryEnded{Agency: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sealed protocol.Frame
+
+	// The captured frame is accepted the first time only, even on another
+	// connection of the agency
+	for attempt := 1; attempt <= 2; attempt++ {
+		conn, err := net.Dial("tcp", server.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(time.Second))
+		if err := protocol.WriteFrame(conn, signer.Seal(hello)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := protocol.ReadMessage(conn); err != nil {
+			t.Fatal(err)
+		}
+		if attempt == 1 {
+			sealed = signer.Seal(ended)
+		}
+		if err := protocol.WriteFrame(conn, sealed); err != nil {
+			t.Fatal(err)
+		}
+		_, err = protocol.ReadMessage(conn)
+		conn.Close()
+		if attempt == 1 && err != nil {
+			t.Fatal(err)
+		}
+		if attempt == 2 && err == nil {
+			t.Fatal("expected the server to close the connection on the replayed frame")
+		}
+	}
+	if errs := server.AuthErrors(); len(errs) != 1 || !errors.Is(errs[0], auth.ErrReplayed) {
+		t.Fatalf("expected a single replay to be rejected, got %v", errs)
+	}
+}
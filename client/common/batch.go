@@ -0,0 +1,165 @@
+package common
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/lottery"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+)
+
+// MaxBatchSize Largest frame, in bytes, a batch of bets can take on the wire,
+// measured after compression when it is enabled
+const MaxBatchSize = 8 * 1024
+
+// batcher Groups the bets of a reader in batches that respect both the
+// configured amount of bets and the size budget of a frame. When frames are
+// compressed the budget applies to the compressed size, so more bets fit in
+// every batch
+type batcher struct {
+	reader    lottery.Reader
+	maxAmount int
+	maxSize   int
+	metrics   *ClientMetrics
+	id        string
+	// overhead Bytes a batch takes on the wire besides its frame, like the
+	// trailer of authenticated frames
+	overhead int
+	// quarantine Keeps the skipped rows, if set
+	quarantine *Quarantine
+	// compressedSize Size of a batch on the wire once compressed, overhead
+	// included. Nil when batches are sent uncompressed
+	compressedSize func(protocol.Bets) int
+
+	// Bets read but left out of the previous batch because they did not fit
+	pending []pendingBet
+}
+
+// pendingBet A bet along with its encoded line
+type pendingBet struct {
+	bet  lottery.Bet
+	line string
+}
+
+func newBatcher(reader lottery.Reader, maxAmount int, maxSize int, metrics *ClientMetrics, id string) *batcher {
+	if maxAmount <= 0 {
+		maxAmount = 1
+	}
+	return &batcher{
+		reader:    reader,
+		maxAmount: maxAmount,
+		maxSize:   maxSize,
+		metrics:   metrics,
+		id:        id,
+	}
+}
+
+// next Builds the batch with the given id. Returns io.EOF when the reader
+// has no more bets
+func (b *batcher) next(batchID uint32) (protocol.Bets, error) {
+	batch := protocol.Bets{BatchID: batchID}
+	var lines []string
+	// Header, message type and the batch id line
+	size := protocol.HeaderSize + 1 + len(strconv.FormatUint(uint64(batchID), 10))
+	// Compressed size of the batch when it was last measured and its
+	// uncompressed size at that moment
+	measured, measuredAt := 0, 0
+	// verified Amount of bets of the batch known to fit in the budget
+	verified := 0
+
+	for len(batch.Bets) < b.maxAmount {
+		bet, line, err := b.read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return protocol.Bets{}, err
+		}
+
+		// Every bet takes its line plus the line separator
+		size += 1 + len(line)
+		batch.Bets = append(batch.Bets, bet)
+		lines = append(lines, line)
+		if size+b.overhead <= b.maxSize {
+			verified = len(batch.Bets)
+			continue
+		}
+		if b.compressedSize != nil {
+			// Measuring the compressed size of every candidate is quadratic.
+			// Appending a line grows the compressed size by less than the line
+			// in practice, so the measure is only repeated once that estimate
+			// goes over the budget, and checked again before returning
+			if measured > 0 && measured+size-measuredAt <= b.maxSize {
+				continue
+			}
+			measured, measuredAt = b.compressedSize(batch), size
+			if measured <= b.maxSize {
+				verified = len(batch.Bets)
+				continue
+			}
+		}
+		if len(batch.Bets) == 1 {
+			return protocol.Bets{}, fmt.Errorf("bet of document %v does not fit in a %v bytes batch", bet.Document, b.maxSize)
+		}
+		batch.Bets, lines = b.unread(batch.Bets, lines)
+		break
+	}
+	for b.compressedSize != nil && len(batch.Bets) > verified && b.compressedSize(batch) > b.maxSize {
+		batch.Bets, lines = b.unread(batch.Bets, lines)
+	}
+
+	if len(batch.Bets) == 0 {
+		return protocol.Bets{}, io.EOF
+	}
+	return batch, nil
+}
+
+// unread Removes the last bet of a batch and leaves it for the next one
+func (b *batcher) unread(bets []lottery.Bet, lines []string) ([]lottery.Bet, []string) {
+	last := len(bets) - 1
+	b.pending = append([]pendingBet{{bet: bets[last], line: lines[last]}}, b.pending...)
+	return bets[:last], lines[:last]
+}
+
+// read Returns the next valid bet along with its encoded line. Invalid rows
+// are logged, put in the quarantine and skipped
+func (b *batcher) read() (lottery.Bet, string, error) {
+	if len(b.pending) > 0 {
+		next := b.pending[0]
+		b.pending = b.pending[1:]
+		return next.bet, next.line, nil
+	}
+
+	for {
+		bet, err := b.reader.Read()
+		if err == io.EOF {
+			return lottery.Bet{}, "", io.EOF
+		}
+		if rowErr, ok := err.(*lottery.RowError); ok {
+			log.Warningf("action: leer_apuesta | result: fail | client_id: %v | line: %v | error: %v",
+				b.id,
+				rowErr.Line,
+				rowErr.Err,
+			)
+			b.quarantineRow(rowErr)
+			continue
+		}
+		if err != nil {
+			return lottery.Bet{}, "", err
+		}
+
+		line, err := protocol.EncodeBet(bet)
+		if err != nil {
+			log.Warningf("action: leer_apuesta | result: fail | client_id: %v | document: %v | error: %v",
+				b.id,
+				bet.Document,
+				err,
+			)
+			b.quarantineBet(bet, err)
+			continue
+		}
+		b.metrics.BetsRead.Inc()
+		return bet, line, nil
+	}
+}
@@ -0,0 +1,432 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/auth"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/capture"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/lottery"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+)
+
+// BetsConfig Configuration of the bets mode
+type BetsConfig struct {
+	// DatasetPath File with the bets of the agency, or StdinDataset to
+	// stream them from the standard input
+	DatasetPath string
+	// DatasetFormat One of lottery.Formats. Empty means lottery.FormatAuto
+	DatasetFormat string
+	// Single Bet sent instead of the dataset, when set
+	Single *lottery.Bet
+	// BatchMaxAmount Maximum amount of bets per batch. Batches are also
+	// limited to MaxBatchSize bytes
+	BatchMaxAmount int
+	// WinnersPeriod Time to wait before asking again for the winners when
+	// the lottery has not taken place yet
+	WinnersPeriod time.Duration
+}
+
+// StdinDataset Dataset path that reads the bets from the standard input
+const StdinDataset = "-"
+
+// runBets Sends every bet of the agency dataset in batches, or its single
+// bet, notifies the server that the delivery ended and waits for the winners
+// of the agency
+func (c *Client) runBets() error {
+	defer c.Close()
+
+	if err := c.sendBets(); err != nil {
+		return err
+	}
+	// A paused client does not end its delivery either
+	c.pipeline.wait()
+	if err := c.EndDelivery(); err != nil {
+		return err
+	}
+	return c.queryWinners()
+}
+
+// sendBets Sends the single bet of the agency, if configured, or else every
+// bet of its dataset
+func (c *Client) sendBets() error {
+	if c.config.Bets.Single != nil {
+		return c.sendSingleBet(*c.config.Bets.Single)
+	}
+	reader, closer, err := openDataset(c.config.Bets.DatasetPath, c.config.Bets.DatasetFormat, c.config.ID)
+	if err != nil {
+		log.Criticalf("action: open_dataset | result: fail | client_id: %v | error: %v",
+			c.config.ID,
+			err,
+		)
+		return err
+	}
+	defer closer.Close()
+
+	_, err = c.sendBatches(reader)
+	return err
+}
+
+// openDataset Opens the dataset of the agency, or the standard input, and
+// creates the reader of its format. Unless given, the format of a file is
+// detected from its extension and the one of the standard input from its
+// first character. The standard input is not closed with the dataset, as it
+// belongs to the process
+func openDataset(path string, format string, agency string) (lottery.Reader, io.Closer, error) {
+	if format == "" {
+		format = lottery.FormatAuto
+	}
+	input := io.NopCloser(os.Stdin)
+	if path != StdinDataset {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		input = file
+		if format == lottery.FormatAuto {
+			format = lottery.DetectFormat(path)
+		}
+	}
+	reader, err := lottery.NewReader(input, format, agency)
+	if err != nil {
+		input.Close()
+		return nil, nil, err
+	}
+	return reader, input, nil
+}
+
+// sendBatches Sends every bet of the reader, one batch at a time, over the
+// same connection. Returns the documents of the bets the server rejected.
+// Batches wait while the pipeline is paused
+func (c *Client) sendBatches(reader lottery.Reader) ([]string, error) {
+	batcher := newBatcher(reader, c.config.Bets.BatchMaxAmount, MaxBatchSize, c.metrics, c.config.ID)
+	batcher.overhead = c.overhead()
+	batcher.quarantine = c.quarantine
+	var rejected []string
+	for {
+		c.pipeline.wait()
+		documents, done, err := c.sendNextBatch(batcher)
+		rejected = append(rejected, documents...)
+		if done || err != nil {
+			return rejected, err
+		}
+	}
+}
+
+// sendNextBatch Builds the next batch of the batcher and sends it. Returns
+// done once there are no more bets
+func (c *Client) sendNextBatch(batcher *batcher) ([]string, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Batches are sized for the session of the open connection. Before
+	// the first handshake, or after losing the connection, the size of a
+	// batch is measured uncompressed, which fits in any session
+	batcher.compressedSize = nil
+	if c.enabled(protocol.CapabilityCompression) {
+		batcher.compressedSize = c.compressedSize
+	}
+	batch, err := batcher.next(c.batchID + 1)
+	if err == io.EOF {
+		return nil, true, nil
+	}
+	if err != nil {
+		log.Criticalf("action: leer_apuestas | result: fail | client_id: %v | error: %v",
+			c.config.ID,
+			err,
+		)
+		return nil, false, err
+	}
+	c.batchID = batch.BatchID
+	documents, err := c.sendBatch(batch)
+	return documents, false, err
+}
+
+// sendBatch Sends a batch and waits for the server to acknowledge or reject
+// it. A rejected batch is logged and its rejected documents returned, but it
+// does not stop the delivery
+func (c *Client) sendBatch(batch protocol.Bets) ([]string, error) {
+	response, err := c.request(batch)
+	if err == nil {
+		switch r := response.(type) {
+		case protocol.Ack:
+			if r.BatchID == batch.BatchID {
+				c.metrics.BetsSent.Add(uint64(len(batch.Bets)))
+				c.metrics.BatchesAcked.Inc()
+				log.Infof("action: apuestas_enviadas | result: success | client_id: %v | batch_id: %v | cantidad: %v",
+					c.config.ID,
+					batch.BatchID,
+					len(batch.Bets),
+				)
+				return nil, nil
+			}
+		case protocol.Reject:
+			if r.BatchID == batch.BatchID {
+				c.metrics.BetsSent.Add(uint64(len(batch.Bets)))
+				c.metrics.BetsRejected.Add(uint64(len(r.Indexes)))
+				documents := rejectedDocuments(batch, r.Indexes)
+				log.Errorf("action: apuestas_enviadas | result: fail | client_id: %v | batch_id: %v | cantidad: %v | rechazadas: %v",
+					c.config.ID,
+					batch.BatchID,
+					len(batch.Bets),
+					strings.Join(documents, ","),
+				)
+				return documents, nil
+			}
+		}
+		err = unexpectedResponse(response)
+	}
+
+	log.Errorf("action: apuestas_enviadas | result: fail | client_id: %v | batch_id: %v | cantidad: %v | error: %v",
+		c.config.ID,
+		batch.BatchID,
+		len(batch.Bets),
+		err,
+	)
+	return nil, err
+}
+
+// EndDelivery Notifies the server that the agency has no more bets
+func (c *Client) EndDelivery() error {
+	c.mu.Lock()
+	response, err := c.request(protocol.DeliveryEnded{Agency: c.config.ID})
+	c.closeConnection()
+	c.mu.Unlock()
+	if err == nil {
+		if _, ok := response.(protocol.Ack); !ok {
+			err = unexpectedResponse(response)
+		}
+	}
+	if err != nil {
+		log.Errorf("action: delivery_ended | result: fail | client_id: %v | error: %v", c.config.ID, err)
+		return err
+	}
+	log.Infof("action: delivery_ended | result: success | client_id: %v", c.config.ID)
+	return nil
+}
+
+// queryWinners Asks for the winners of the agency until the lottery takes
+// place. The connection is closed between queries, unless the server pushes
+// the winners once the lottery takes place
+func (c *Client) queryWinners() error {
+	for {
+		response, err := c.queryWinnersOnce()
+		if err == nil {
+			switch r := response.(type) {
+			case protocol.Winners:
+				log.Infof("action: consulta_ganadores | result: success | cant_ganadores: %v", len(r.Documents))
+				return nil
+			case protocol.WinnersPending:
+				log.Debugf("action: consulta_ganadores | result: in_progress | client_id: %v", c.config.ID)
+				c.clock.Sleep(c.config.Bets.WinnersPeriod)
+				continue
+			}
+			err = unexpectedResponse(response)
+		}
+		log.Errorf("action: consulta_ganadores | result: fail | client_id: %v | error: %v", c.config.ID, err)
+		return err
+	}
+}
+
+// queryWinnersOnce Asks for the winners of the agency and, if the server
+// pushes them, waits for them on the same connection
+func (c *Client) queryWinnersOnce() (protocol.Message, error) {
+	c.mu.Lock()
+	response, err := c.request(protocol.WinnersQuery{Agency: c.config.ID})
+	_, pending := response.(protocol.WinnersPending)
+	if !pending || !c.enabled(protocol.CapabilityPush) {
+		c.closeConnection()
+		c.mu.Unlock()
+		return response, err
+	}
+
+	// The connection is handed over to the wait, so other requests, like
+	// the ones of the control service, do not wait for the lottery too. Its
+	// session goes with it, the next request negotiates its own
+	conn, framing, number := c.conn, c.framing, c.connCount
+	c.conn = nil
+	c.session = nil
+	c.closeConnection()
+	c.mu.Unlock()
+	defer conn.Close()
+	if pushed, pushErr := c.awaitPush(conn, framing, number); pushErr == nil {
+		response = pushed
+	} else {
+		log.Warningf("action: consulta_ganadores | result: fail | client_id: %v | error: push not received: %v", c.config.ID, pushErr)
+	}
+	return response, err
+}
+
+// awaitPush Waits on the connection for the winners the server pushes once
+// the lottery takes place. There is no deadline, as the lottery waits for
+// every agency to finish its delivery
+func (c *Client) awaitPush(conn net.Conn, framing protocol.Framing, number int) (protocol.Message, error) {
+	conn.SetDeadline(time.Time{})
+	return c.receiveFrom(conn, framing, number)
+}
+
+// request Sends a message and waits for its response. On failures the
+// connection is reopened and the message sent again, up to RetryAttempts times.
+// Batches keep their id between attempts, so the server can discard duplicates.
+// A corrupt response is one of those failures: the connection cannot be
+// trusted anymore, and the batch is sent again since it was never acknowledged
+func (c *Client) request(msg protocol.Message) (protocol.Message, error) {
+	attempts := c.config.RetryAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			c.clock.Sleep(c.config.RetryBackoff)
+		}
+		var response protocol.Message
+		if response, err = c.roundTrip(msg); err == nil {
+			return response, nil
+		}
+		// Retrying cannot fix a server that speaks another protocol version
+		// nor a certificate that is not trusted
+		var versionErr *protocol.VersionError
+		var tlsErr *TLSError
+		if errors.As(err, &versionErr) || (errors.As(err, &tlsErr) && tlsErr.Permanent()) {
+			return nil, err
+		}
+		log.Warningf("action: send_message | result: fail | client_id: %v | type: %v | attempt: %v | error: %v",
+			c.config.ID,
+			msg.Type(),
+			attempt,
+			err,
+		)
+		c.closeConnection()
+	}
+	return nil, err
+}
+
+// roundTrip Writes a message on the current connection, opening one if
+// needed, and reads the response
+func (c *Client) roundTrip(msg protocol.Message) (protocol.Message, error) {
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return nil, err
+		}
+	}
+	// Deadlines are enforced by the connection against the wall clock, not
+	// against the client clock
+	if c.config.Timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.config.Timeout))
+	}
+	return c.exchange(msg)
+}
+
+// exchange Writes a message on the current connection and reads the
+// response
+func (c *Client) exchange(msg protocol.Message) (protocol.Message, error) {
+	frame, err := protocol.Encode(msg)
+	if err != nil {
+		return nil, err
+	}
+	if c.enabled(protocol.CapabilityCompression) {
+		frame = protocol.Compress(frame, c.config.CompressionThreshold)
+	}
+	start := c.clock.Now()
+	// The capture keeps the frame without its trailer, so it can still be
+	// decoded
+	sent := frame
+	if c.signer != nil {
+		sent = c.signer.Seal(frame)
+	}
+	if err := c.framing.WriteFrame(c.conn, sent); err != nil {
+		return nil, err
+	}
+	c.record(capture.Sent, c.connCount, frame)
+
+	response, err := c.receive()
+	if err != nil {
+		return nil, err
+	}
+	c.metrics.RTT.Observe(c.clock.Now().Sub(start).Seconds())
+	return response, nil
+}
+
+// receive Reads and decodes a message from the current connection
+func (c *Client) receive() (protocol.Message, error) {
+	return c.receiveFrom(c.conn, c.framing, c.connCount)
+}
+
+// receiveFrom Reads and decodes a message from the given connection, the
+// number-th one opened by the client
+func (c *Client) receiveFrom(conn net.Conn, framing protocol.Framing, number int) (protocol.Message, error) {
+	frame, err := framing.ReadFrame(conn)
+	if err != nil {
+		if errors.Is(err, protocol.ErrCorruptFrame) {
+			c.metrics.CorruptFrames.Inc()
+		}
+		return nil, err
+	}
+	c.record(capture.Received, number, frame)
+	if frame, err = protocol.Decompress(frame, c.config.MaxFrameSize); err != nil {
+		return nil, err
+	}
+	return protocol.Decode(frame)
+}
+
+// compressedSize Size of a batch on the wire once compressed, overhead
+// included
+func (c *Client) compressedSize(batch protocol.Bets) int {
+	frame, err := protocol.Encode(batch)
+	if err != nil {
+		return math.MaxInt32
+	}
+	return protocol.WireSize(len(protocol.Compress(frame, c.config.CompressionThreshold).Payload)) + c.overhead()
+}
+
+// overhead Bytes a batch takes on the wire besides its frame: the trailer of
+// authenticated frames and the checksum, if it can be negotiated. The
+// checksum is accounted for before the handshake, so the batch fits either way
+func (c *Client) overhead() int {
+	overhead := 0
+	if c.signer != nil {
+		overhead += auth.TrailerSize
+	}
+	if protocol.Has(protocol.Negotiate(c.config.Capabilities, SupportedCapabilities), protocol.CapabilityChecksums) {
+		overhead += protocol.ChecksumSize
+	}
+	return overhead
+}
+
+// record Writes the frame of the conn-th connection to the capture, if
+// recording is enabled. A capture that cannot be written does not stop the
+// client
+func (c *Client) record(direction capture.Direction, conn int, frame protocol.Frame) {
+	if c.recorder == nil {
+		return
+	}
+	record, err := capture.NewRecord(c.clock.Now(), conn, direction, frame)
+	if err == nil {
+		err = c.recorder.Write(record)
+	}
+	if err != nil {
+		log.Warningf("action: record_frame | result: fail | client_id: %v | error: %v", c.config.ID, err)
+	}
+}
+
+func unexpectedResponse(response protocol.Message) error {
+	return fmt.Errorf("unexpected %v response %+v", response.Type(), response)
+}
+
+func rejectedDocuments(batch protocol.Bets, indexes []int) []string {
+	documents := make([]string, 0, len(indexes))
+	for _, index := range indexes {
+		if index < len(batch.Bets) {
+			documents = append(documents, batch.Bets[index].Document)
+		}
+	}
+	return documents
+}
@@ -0,0 +1,325 @@
+package common
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/fakeserver"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/metrics"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+)
+
+const testDataset = `Valentina,Vera,30170921,1982-05-22,6053
+Santiago,Álvarez,33936970,1986-04-25,7068
+Martina,Borges,21073376,1994-09-01,6293
+Invalid,Row,not-a-document,1994-09-01,1
+Lionel,Lorca,30904465,1999-03-17,7574
+Camila,Pineda,29665629,2000-01-06,9999
+`
+
+// testDocuments Documents of the valid bets of testDataset, in order
+const testDocuments = "30170921,33936970,21073376,30904465,29665629"
+
+func writeDataset(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "agency-1.csv")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func betsConfig(address string, dataset string) ClientConfig {
+	return ClientConfig{
+		ID:            "1",
+		ServerAddress: address,
+		Mode:          ModeBets,
+		Bets: BetsConfig{
+			DatasetPath:    dataset,
+			BatchMaxAmount: 2,
+			WinnersPeriod:  time.Millisecond,
+		},
+		Timeout:       time.Second,
+		RetryAttempts: 3,
+		RetryBackoff:  time.Millisecond,
+	}
+}
+
+// framesOfType Amount of frames of the given type received by the server
+func framesOfType(server *fakeserver.Server, t protocol.MessageType) int {
+	count := 0
+	for _, received := range server.Received() {
+		if received.Frame.Type == t {
+			count++
+		}
+	}
+	return count
+}
+
+func TestStartClientLoopInBetsMode(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(server *fakeserver.Server, config *ClientConfig)
+		// Expected outcome
+		fails        bool
+		stored       int
+		batchFrames  int
+		batchesAcked uint64
+		betsRejected uint64
+		connections  int
+	}{
+		{
+			name:         "every batch is acknowledged",
+			setup:        func(*fakeserver.Server, *ClientConfig) {},
+			stored:       5,
+			batchFrames:  3,
+			batchesAcked: 3,
+			connections:  2,
+		},
+		{
+			name: "rejected bets do not stop the delivery",
+			setup: func(server *fakeserver.Server, _ *ClientConfig) {
+				server.RejectDocuments("21073376")
+			},
+			stored:       3,
+			batchFrames:  3,
+			batchesAcked: 2,
+			betsRejected: 1,
+			connections:  2,
+		},
+		{
+			name: "scripted reject of a whole batch",
+			setup: func(server *fakeserver.Server, _ *ClientConfig) {
+				server.Script(fakeserver.Action{Kind: fakeserver.RejectBets, Indexes: []int{0, 1}})
+			},
+			stored:       3,
+			batchFrames:  3,
+			batchesAcked: 2,
+			betsRejected: 2,
+			connections:  2,
+		},
+		{
+			name: "batch is sent again after the server hangs up",
+			setup: func(server *fakeserver.Server, _ *ClientConfig) {
+				server.Script(fakeserver.Action{Kind: fakeserver.Hangup})
+			},
+			stored:       5,
+			batchFrames:  4,
+			batchesAcked: 3,
+			connections:  3,
+		},
+		{
+			name: "response cut in the middle is retried and deduplicated",
+			setup: func(server *fakeserver.Server, _ *ClientConfig) {
+				server.Script(fakeserver.Action{}, fakeserver.Action{Kind: fakeserver.CloseMidFrame})
+			},
+			stored:       5,
+			batchFrames:  4,
+			batchesAcked: 3,
+			connections:  3,
+		},
+		{
+			name: "garbage response is retried",
+			setup: func(server *fakeserver.Server, _ *ClientConfig) {
+				server.Script(fakeserver.Action{Kind: fakeserver.Garbage, Bytes: []byte{0, 3, 0xff, 'x', 'y'}})
+			},
+			stored:       5,
+			batchFrames:  4,
+			batchesAcked: 3,
+			connections:  3,
+		},
+		{
+			name: "slow response times out and is retried",
+			setup: func(server *fakeserver.Server, config *ClientConfig) {
+				config.Timeout = 50 * time.Millisecond
+				server.Script(fakeserver.Action{}.After(200 * time.Millisecond))
+			},
+			stored:       5,
+			batchFrames:  4,
+			batchesAcked: 3,
+			connections:  3,
+		},
+		{
+			name: "winners are queried until the lottery takes place",
+			setup: func(server *fakeserver.Server, _ *ClientConfig) {
+				server.SetPendingQueries(3)
+				server.SetWinners("1", "30904465")
+			},
+			stored:       5,
+			batchFrames:  3,
+			batchesAcked: 3,
+			connections:  5,
+		},
+		{
+			name: "client gives up after every attempt fails",
+			setup: func(server *fakeserver.Server, _ *ClientConfig) {
+				hangup := fakeserver.Action{Kind: fakeserver.Hangup}
+				server.Script(hangup, hangup, hangup)
+			},
+			fails:       true,
+			batchFrames: 3,
+			connections: 3,
+		},
+		{
+			name: "missing dataset",
+			setup: func(_ *fakeserver.Server, config *ClientConfig) {
+				config.Bets.DatasetPath = filepath.Join(t.TempDir(), "missing.csv")
+			},
+			fails:       true,
+			connections: 0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, err := fakeserver.Start()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer server.Close()
+
+			config := betsConfig(server.Addr(), writeDataset(t, testDataset))
+			test.setup(server, &config)
+			clientMetrics := NewClientMetrics(metrics.NewRegistry())
+
+			err = NewClient(config, clientMetrics).StartClientLoop()
+			if test.fails != (err != nil) {
+				t.Fatalf("expected failure: %v, got error: %v", test.fails, err)
+			}
+			if stored := len(server.Bets("1")); stored != test.stored {
+				t.Errorf("expected %v bets stored, got %v", test.stored, stored)
+			}
+			if frames := framesOfType(server, protocol.TypeBets); frames != test.batchFrames {
+				t.Errorf("expected %v batch frames, got %v", test.batchFrames, frames)
+			}
+			if acked := clientMetrics.BatchesAcked.Value(); acked != test.batchesAcked {
+				t.Errorf("expected %v batches acked, got %v", test.batchesAcked, acked)
+			}
+			if rejected := clientMetrics.BetsRejected.Value(); rejected != test.betsRejected {
+				t.Errorf("expected %v bets rejected, got %v", test.betsRejected, rejected)
+			}
+			if connections := server.Connections(); connections != test.connections {
+				t.Errorf("expected %v connections, got %v", test.connections, connections)
+			}
+			if test.connections > 0 && clientMetrics.Reconnects.Value() != uint64(test.connections-1) {
+				t.Errorf("expected %v reconnects, got %v", test.connections-1, clientMetrics.Reconnects.Value())
+			}
+			if !test.fails && !server.Finished("1") {
+				t.Error("expected the delivery to be finished")
+			}
+		})
+	}
+}
+
+func TestBatchesAreSentInDatasetOrder(t *testing.T) {
+	server, err := fakeserver.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	config := betsConfig(server.Addr(), writeDataset(t, testDataset))
+	if err := NewClient(config, NewClientMetrics(metrics.NewRegistry())).StartClientLoop(); err != nil {
+		t.Fatal(err)
+	}
+
+	var documents []string
+	for _, bet := range server.Bets("1") {
+		documents = append(documents, bet.Document)
+	}
+	if strings.Join(documents, ",") != testDocuments {
+		t.Fatalf("expected %v, got %v", testDocuments, documents)
+	}
+
+	// Batches share one connection, every winners query opens its own
+	received := server.Received()
+	last := received[len(received)-1]
+	if last.Frame.Type != protocol.TypeWinnersQuery || last.Conn != 2 {
+		t.Fatalf("unexpected last frame %+v", last)
+	}
+	for _, r := range received[:4] {
+		if r.Conn != 1 {
+			t.Fatalf("expected batches and end of delivery on the first connection, got %+v", r)
+		}
+	}
+}
+
+// testJSONLines testDataset as JSON lines, with the same invalid entry
+const testJSONLines = `{"type": "bet", "data": {"first_name": "Valentina", "last_name": "Vera", "document_number": "30170921", "birth_date": "1982-05-22", "number": "6053"}}
+{"type": "bet", "data": {"first_name": "Santiago", "last_name": "Álvarez", "document_number": "33936970", "birth_date": "1986-04-25", "number": "7068"}}
+{"type": "bet", "data": {"client_id": "1", "first_name": "Martina", "last_name": "Borges", "document_number": "21073376", "birth_date": "1994-09-01", "number": "6293"}}
+{"type": "bet", "data": {"first_name": "Invalid", "last_name": "Row", "document_number": "not-a-document", "birth_date": "1994-09-01", "number": "1"}}
+{"type": "bet", "data": {"first_name": "Lionel", "last_name": "Lorca", "document_number": "30904465", "birth_date": "1999-03-17", "number": "7574"}}
+{"type": "bet", "data": {"first_name": "Camila", "last_name": "Pineda", "document_number": "29665629", "birth_date": "2000-01-06", "number": "9999"}}
+`
+
+func TestBetsModeReadsEveryDatasetFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		format  string
+		stdin   bool
+	}{
+		{name: "csv", file: "agency-1.csv", content: testDataset},
+		{name: "json lines", file: "agency-1.jsonl", content: testJSONLines},
+		{name: "configured format", file: "agency-1.txt", content: testJSONLines, format: "jsonl"},
+		{name: "csv from stdin", content: testDataset, stdin: true},
+		{name: "json lines from stdin", content: testJSONLines, stdin: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			quietLogs(t)
+			server := startFakeServer(t)
+
+			path := StdinDataset
+			if test.stdin {
+				stdin, writer, err := os.Pipe()
+				if err != nil {
+					t.Fatal(err)
+				}
+				previous := os.Stdin
+				os.Stdin = stdin
+				t.Cleanup(func() { os.Stdin = previous })
+				go func() {
+					writer.Write([]byte(test.content))
+					writer.Close()
+				}()
+			} else {
+				path = filepath.Join(t.TempDir(), test.file)
+				if err := os.WriteFile(path, []byte(test.content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			config := betsConfig(server.Addr(), path)
+			config.Bets.DatasetFormat = test.format
+			clientMetrics := NewClientMetrics(metrics.NewRegistry())
+			var quarantined bytes.Buffer
+			if err := NewClient(config, clientMetrics, WithQuarantine(NewQuarantine(&quarantined))).StartClientLoop(); err != nil {
+				t.Fatal(err)
+			}
+			// The invalid entry is skipped the same way in every format
+			if documents := storedDocuments(server); documents != testDocuments {
+				t.Fatalf("expected every valid bet stored in order, got %v", documents)
+			}
+			if read := clientMetrics.BetsRead.Value(); read != 5 {
+				t.Fatalf("expected 5 bets read, got %v", read)
+			}
+			entries, err := ReadQuarantine(&quarantined)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 || entries[0].Agency != "1" || entries[0].Line != 4 || !strings.Contains(entries[0].Row, "not-a-document") || entries[0].Reason == "" {
+				t.Fatalf("expected the invalid entry in the quarantine, got %+v", entries)
+			}
+			if test.stdin && os.Stdin.Close() != nil {
+				t.Fatal("expected the standard input to be left open")
+			}
+		})
+	}
+}
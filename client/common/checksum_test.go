@@ -0,0 +1,76 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/faultproxy"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/metrics"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+)
+
+// TestCorruptFramesAreSentAgain Flips a bit of the first batch or of its ack
+// on the way. With checksums enabled the corruption is detected, and the
+// client sends the unacknowledged batch again on a new connection
+func TestCorruptFramesAreSentAgain(t *testing.T) {
+	// Frames of the handshake with only checksums enabled, which the
+	// corruption skips to hit the first batch and its ack
+	const helloSize, helloReplySize = 16, 14
+	tests := []struct {
+		name  string
+		fault faultproxy.Fault
+		// Corrupt frames detected by the server and by the client
+		serverCorrupt uint64
+		clientCorrupt uint64
+		batchFrames   int
+	}{
+		{
+			name:          "batch",
+			fault:         faultproxy.Fault{Kind: faultproxy.Corrupt, AfterBytes: helloSize + 5, Direction: faultproxy.Upstream},
+			serverCorrupt: 1,
+			batchFrames:   3,
+		},
+		{
+			name:          "ack",
+			fault:         faultproxy.Fault{Kind: faultproxy.Corrupt, AfterBytes: helloReplySize + 3, Direction: faultproxy.Downstream},
+			clientCorrupt: 1,
+			batchFrames:   4,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := startFakeServer(t)
+			proxy, err := faultproxy.Start(faultproxy.Config{
+				Target:   server.Addr(),
+				Schedule: faultproxy.Plan{{test.fault}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer proxy.Close()
+			quietLogs(t)
+
+			config := betsConfig(proxy.Addr(), writeDataset(t, testDataset))
+			config.Capabilities = []string{protocol.CapabilityChecksums}
+			clientMetrics := NewClientMetrics(metrics.NewRegistry())
+			if err := NewClient(config, clientMetrics).StartClientLoop(); err != nil {
+				t.Fatal(err)
+			}
+
+			if documents := storedDocuments(server); documents != testDocuments {
+				t.Errorf("expected every bet stored once and in order, got %v", documents)
+			}
+			if corrupt := uint64(server.CorruptFrames()); corrupt != test.serverCorrupt {
+				t.Errorf("expected %v corrupt frames detected by the server, got %v", test.serverCorrupt, corrupt)
+			}
+			if corrupt := clientMetrics.CorruptFrames.Value(); corrupt != test.clientCorrupt {
+				t.Errorf("expected %v corrupt frames detected by the client, got %v", test.clientCorrupt, corrupt)
+			}
+			if frames := framesOfType(server, protocol.TypeBets); frames != test.batchFrames {
+				t.Errorf("expected %v batch frames, got %v", test.batchFrames, frames)
+			}
+			if reconnects := clientMetrics.Reconnects.Value(); reconnects < 1 {
+				t.Error("expected the client to reconnect after the corruption")
+			}
+		})
+	}
+}
@@ -1,35 +1,160 @@
 package common
 
 import (
-	"bufio"
-	"fmt"
+	"crypto/tls"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/op/go-logging"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/auth"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/capture"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/transport"
 )
 
 var log = logging.MustGetLogger("log")
 
+// Modes the client can run in
+const (
+	// ModeEcho Sends LoopAmount messages to an echo server
+	ModeEcho = "echo"
+	// ModeBets Sends the bets of the agency dataset to the lottery server
+	ModeBets = "bets"
+)
+
 // ClientConfig Configuration used by the client
 type ClientConfig struct {
-	ID            string
+	ID string
+	// ServerAddress Address of the server. Its scheme selects the transport:
+	// tcp:// (the default when there is none), unix:// or mem://
 	ServerAddress string
+	Mode          string
 	LoopAmount    int
 	LoopPeriod    time.Duration
+	Echo          EchoConfig
+	Bets          BetsConfig
+	// Timeout Maximum time to wait for the server on every request. Zero
+	// waits forever
+	Timeout time.Duration
+	// RetryAttempts Times a request is sent, reconnecting in between, before
+	// giving up
+	RetryAttempts int
+	RetryBackoff  time.Duration
+	// Capabilities Optional protocol features the agency offers in the
+	// handshake. Only the ones in SupportedCapabilities are offered
+	Capabilities []string
+	// MaxFrameSize Hard maximum of a payload read or written, joined from
+	// continuation frames. Zero means protocol.DefaultMaxSize
+	MaxFrameSize int
+	// CompressionThreshold Payloads smaller than this amount of bytes are
+	// sent uncompressed when compression is enabled. Zero means
+	// protocol.DefaultCompressionThreshold
+	CompressionThreshold int
+	// AuthSecret Secret of the agency the frames it sends are authenticated
+	// with. Empty sends them without authentication
+	AuthSecret []byte
 }
 
 // Client Entity that encapsulates how
 type Client struct {
 	config ClientConfig
-	conn   net.Conn
+	// mu Serializes the requests of the bets mode, which can also arrive
+	// from the control service while the client loop runs
+	mu      sync.Mutex
+	conn    net.Conn
+	metrics *ClientMetrics
+	// connCount Connections opened so far, which numbers the current one
+	connCount int
+	clock     Clock
+	dialer    Dialer
+	recorder  *capture.Writer
+	// session Capabilities enabled by the handshake of the current
+	// connection
+	session []string
+	// framing Framing of the current connection, which allows continuation
+	// frames once negotiated
+	framing protocol.Framing
+	// tls Configuration used to secure every connection, if set
+	tls *tls.Config
+	// signer Seals the frames sent when authentication is enabled
+	signer *auth.Signer
+	// batchID Id of the last batch sent. Ids keep growing across deliveries
+	// of the same client, so the server never discards a new batch as a
+	// duplicate
+	batchID uint32
+	// pipeline Holds the batches of the dataset while paused
+	pipeline *pipeline
+	// quarantine Keeps the rows of the dataset that are skipped, if set
+	quarantine *Quarantine
+}
+
+// Option Customizes a client created by NewClient
+type Option func(*Client)
+
+// WithClock Makes the client wait and measure time with the given clock
+// instead of the system one
+func WithClock(clock Clock) Option {
+	return func(c *Client) {
+		c.clock = clock
+	}
+}
+
+// WithDialer Makes the client open its connections with the given dialer
+// instead of the transport of the server address
+func WithDialer(dialer Dialer) Option {
+	return func(c *Client) {
+		c.dialer = dialer
+	}
+}
+
+// WithRecorder Makes the client write every frame it sends or receives to
+// the capture
+func WithRecorder(recorder *capture.Writer) Option {
+	return func(c *Client) {
+		c.recorder = recorder
+	}
+}
+
+// WithQuarantine Makes the client put the rows of the dataset it skips in the
+// quarantine
+func WithQuarantine(quarantine *Quarantine) Option {
+	return func(c *Client) {
+		c.quarantine = quarantine
+	}
+}
+
+// WithTLS Makes the client secure every connection with TLS
+func WithTLS(config *tls.Config) Option {
+	return func(c *Client) {
+		c.tls = config
+	}
+}
+
+// WithFirstBatchID Makes the client number its batches starting at the
+// given id instead of 1
+func WithFirstBatchID(id uint32) Option {
+	return func(c *Client) {
+		c.batchID = id - 1
+	}
 }
 
 // NewClient Initializes a new client receiving the configuration
-// as a parameter
-func NewClient(config ClientConfig) *Client {
+// and the instruments it has to update as parameters
+func NewClient(config ClientConfig, metrics *ClientMetrics, options ...Option) *Client {
 	client := &Client{
-		config: config,
+		config:   config,
+		metrics:  metrics,
+		clock:    systemClock{},
+		framing:  protocol.Framing{MaxSize: config.MaxFrameSize},
+		pipeline: newPipeline(),
+	}
+	for _, option := range options {
+		option(client)
+	}
+	if len(config.AuthSecret) > 0 {
+		client.signer = auth.NewSigner(config.ID, config.AuthSecret, client.clock.Now)
 	}
 	return client
 }
@@ -38,52 +163,56 @@ func NewClient(config ClientConfig) *Client {
 // failure, error is printed in stdout/stderr and exit 1
 // is returned
 func (c *Client) createClientSocket() error {
-	conn, err := net.Dial("tcp", c.config.ServerAddress)
+	conn, err := c.dial()
+	if err == nil && c.tls != nil {
+		conn, err = secure(conn, c.tls, c.config.Timeout)
+	}
 	if err != nil {
 		log.Criticalf(
 			"action: connect | result: fail | client_id: %v | error: %v",
 			c.config.ID,
 			err,
 		)
+		return err
+	}
+	if c.connCount > 0 {
+		c.metrics.Reconnects.Inc()
 	}
-	c.conn = conn
+	c.connCount++
+	c.conn = &meteredConn{Conn: conn, metrics: c.metrics}
 	return nil
 }
 
-// StartClientLoop Send messages to the client until some time threshold is met
-func (c *Client) StartClientLoop() {
-	// There is an autoincremental msgID to identify every message sent
-	// Messages if the message amount threshold has not been surpassed
-	for msgID := 1; msgID <= c.config.LoopAmount; msgID++ {
-		// Create the connection the server in every loop iteration. Send an
-		c.createClientSocket()
-
-		// TODO: Modify the send to avoid short-write
-		fmt.Fprintf(
-			c.conn,
-			"[CLIENT %v] Message N°%v\n",
-			c.config.ID,
-			msgID,
-		)
-		msg, err := bufio.NewReader(c.conn).ReadString('\n')
-		c.conn.Close()
-
+// dial Opens a connection to the server with the dialer given to WithDialer,
+// on the network of the scheme of the server address, or, by default, with
+// its transport
+func (c *Client) dial() (net.Conn, error) {
+	if c.dialer != nil {
+		network, address, err := transport.Network(c.config.ServerAddress)
 		if err != nil {
-			log.Errorf("action: receive_message | result: fail | client_id: %v | error: %v",
-				c.config.ID,
-				err,
-			)
-			return
+			return nil, err
 		}
+		return c.dialer.Dial(network, address)
+	}
+	return transport.Dial(c.config.ServerAddress, c.config.Timeout)
+}
 
-		log.Infof("action: receive_message | result: success | client_id: %v | msg: %v",
-			c.config.ID,
-			msg,
-		)
-
-		// Wait a time between sending one message and the next one
-		time.Sleep(c.config.LoopPeriod)
+// StartClientLoop Runs the client in the configured mode until it finishes.
+// An error is returned if the client could not complete its work
+func (c *Client) StartClientLoop() error {
+	if c.config.Mode == ModeBets {
+		return c.runBets()
+	}
+	return c.runEchoLoop()
+}
 
+// closeConnection Closes the current connection, if any, so the next request
+// opens a new one
+func (c *Client) closeConnection() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+		c.session = nil
 	}
-	log.Infof("action: loop_finished | result: success | client_id: %v", c.config.ID)
+	c.framing = protocol.Framing{MaxSize: c.config.MaxFrameSize}
 }
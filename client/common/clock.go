@@ -0,0 +1,31 @@
+package common
+
+import (
+	"net"
+	"time"
+)
+
+// Clock Source of time used by the client to wait between messages and to
+// measure round trips. Tests replace it to run the loops in virtual time
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+// Dialer Opens the connections to the server. Tests replace it to hand the
+// client in-memory connections. *net.Dialer implements it. It receives the
+// network of the scheme of the server address and the address without it
+type Dialer interface {
+	Dial(network, address string) (net.Conn, error)
+}
+
+// systemClock Clock backed by the time package
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
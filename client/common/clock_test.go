@@ -0,0 +1,190 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/fakeserver"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/metrics"
+	"github.com/op/go-logging"
+)
+
+// fakeClock Virtual clock: sleeping advances the time instantly
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	sleeps int
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 8, 21, 22, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.Advance(d)
+	c.mu.Lock()
+	c.sleeps++
+	c.mu.Unlock()
+}
+
+// Advance Moves the virtual time forward
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// pipeDialer Hands the client one end of a net.Pipe per dial and the other
+// end to serve
+type pipeDialer struct {
+	serve func(conn net.Conn)
+	dials int
+}
+
+func (d *pipeDialer) Dial(network, address string) (net.Conn, error) {
+	d.dials++
+	client, server := net.Pipe()
+	go d.serve(server)
+	return client, nil
+}
+
+// echoDialer Hands the client a connection to an in-memory echo server per
+// dial. Unlike pipeDialer no goroutine serves it, so thousands of dials
+// take milliseconds
+type echoDialer struct {
+	dials int
+}
+
+func (d *echoDialer) Dial(network, address string) (net.Conn, error) {
+	d.dials++
+	return &echoConn{}, nil
+}
+
+// echoConn Connection whose reads return every byte written to it, like an
+// echo server would
+type echoConn struct {
+	buf bytes.Buffer
+}
+
+func (c *echoConn) Read(b []byte) (int, error)         { return c.buf.Read(b) }
+func (c *echoConn) Write(b []byte) (int, error)        { return c.buf.Write(b) }
+func (c *echoConn) Close() error                       { return nil }
+func (c *echoConn) LocalAddr() net.Addr                { return &net.TCPAddr{} }
+func (c *echoConn) RemoteAddr() net.Addr               { return &net.TCPAddr{} }
+func (c *echoConn) SetDeadline(t time.Time) error      { return nil }
+func (c *echoConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *echoConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// quietLogs Only lets errors through during the test
+func quietLogs(t *testing.T) {
+	level := logging.GetLevel("log")
+	logging.SetLevel(logging.ERROR, "log")
+	t.Cleanup(func() { logging.SetLevel(level, "log") })
+}
+
+func TestEchoLoopSimulationRunsInVirtualTime(t *testing.T) {
+	quietLogs(t)
+	clock := newFakeClock()
+	dialer := &echoDialer{}
+	config := ClientConfig{
+		ID:            "1",
+		ServerAddress: "server:12345",
+		Mode:          ModeEcho,
+		LoopAmount:    10000,
+		LoopPeriod:    5 * time.Second,
+	}
+	start := clock.Now()
+
+	began := time.Now()
+	err := NewClient(config, NewClientMetrics(metrics.NewRegistry()), WithClock(clock), WithDialer(dialer)).StartClientLoop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(began)
+
+	if dialer.dials != 10000 {
+		t.Fatalf("expected a connection per message, got %v", dialer.dials)
+	}
+	if clock.sleeps != 10000 {
+		t.Fatalf("expected a sleep per message, got %v", clock.sleeps)
+	}
+	virtual := clock.Now().Sub(start)
+	if virtual != 10000*5*time.Second {
+		t.Fatalf("expected %v of virtual time, got %v", 10000*5*time.Second, virtual)
+	}
+	if elapsed > 80*time.Millisecond {
+		t.Fatalf("%v of virtual time took %v of real time", virtual, elapsed)
+	}
+}
+
+func TestEchoLoopMeasuresRTTWithTheClock(t *testing.T) {
+	quietLogs(t)
+	clock := newFakeClock()
+	// The server takes 3 virtual seconds to answer
+	dialer := &pipeDialer{serve: func(conn net.Conn) {
+		defer conn.Close()
+		line, err := bufio.NewReader(conn).ReadBytes('\n')
+		if err != nil {
+			return
+		}
+		clock.Advance(3 * time.Second)
+		conn.Write(line)
+	}}
+	config := ClientConfig{ID: "1", ServerAddress: "server:12345", Mode: ModeEcho, LoopAmount: 2}
+	registry := metrics.NewRegistry()
+
+	if err := NewClient(config, NewClientMetrics(registry), WithClock(clock), WithDialer(dialer)).StartClientLoop(); err != nil {
+		t.Fatal(err)
+	}
+	var body strings.Builder
+	registry.WriteTo(&body)
+	for _, line := range []string{
+		MetricRTT + "_bucket{le=\"2.5\"} 0\n",
+		MetricRTT + "_bucket{le=\"5\"} 2\n",
+	} {
+		if !strings.Contains(body.String(), line) {
+			t.Errorf("expected %q in scrape:\n%s", line, body.String())
+		}
+	}
+}
+
+func TestBetsModeWaitsInVirtualTime(t *testing.T) {
+	quietLogs(t)
+	server, err := fakeserver.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	server.SetPendingQueries(100)
+	server.Script(fakeserver.Action{Kind: fakeserver.Hangup}, fakeserver.Action{Kind: fakeserver.Hangup})
+
+	clock := newFakeClock()
+	dialer := &pipeDialer{serve: server.ServeConn}
+	config := betsConfig("server:12345", writeDataset(t, testDataset))
+	config.RetryBackoff = time.Minute
+	config.Bets.WinnersPeriod = time.Hour
+	start := clock.Now()
+
+	if err := NewClient(config, NewClientMetrics(metrics.NewRegistry()), WithClock(clock), WithDialer(dialer)).StartClientLoop(); err != nil {
+		t.Fatal(err)
+	}
+	if stored := len(server.Bets("1")); stored != 5 {
+		t.Fatalf("expected 5 bets stored, got %v", stored)
+	}
+	// Two retries of the first batch and 100 pending winners queries
+	expected := 2*time.Minute + 100*time.Hour
+	if virtual := clock.Now().Sub(start); virtual != expected {
+		t.Fatalf("expected %v of virtual time, got %v", expected, virtual)
+	}
+}
@@ -0,0 +1,100 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/auth"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/metrics"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+)
+
+// largeDataset Rows of similar bets, which compress well
+func largeDataset(rows int) string {
+	var dataset strings.Builder
+	for i := 0; i < rows; i++ {
+		fmt.Fprintf(&dataset, "Valentina,Vera,%v,1982-05-22,%v\n", 30000000+i, 1000+i%9000)
+	}
+	return dataset.String()
+}
+
+func TestCompressedBatchesFitMoreBets(t *testing.T) {
+	const rows = 2000
+	dataset := writeDataset(t, largeDataset(rows))
+
+	tests := []struct {
+		name         string
+		capabilities []string
+		threshold    int
+		secret       []byte
+		compressed   bool
+	}{
+		{name: "uncompressed"},
+		{name: "compressed", capabilities: []string{protocol.CapabilityCompression}, compressed: true},
+		{name: "compressed and authenticated", capabilities: []string{protocol.CapabilityCompression}, secret: agencySecrets["1"], compressed: true},
+		{name: "below the threshold", capabilities: []string{protocol.CapabilityCompression}, threshold: MaxBatchSize + 1},
+	}
+	batches := make(map[string]int)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := startFakeServer(t)
+			if test.secret != nil {
+				server.RequireAuth(auth.NewVerifier(agencySecrets, 0, time.Now))
+			}
+			config := betsConfig(server.Addr(), dataset)
+			config.Bets.BatchMaxAmount = rows
+			config.Capabilities = test.capabilities
+			config.CompressionThreshold = test.threshold
+			config.AuthSecret = test.secret
+			if err := NewClient(config, NewClientMetrics(metrics.NewRegistry())).StartClientLoop(); err != nil {
+				t.Fatal(err)
+			}
+
+			if stored := len(server.Bets("1")); stored != rows {
+				t.Fatalf("expected %v bets stored, got %v", rows, stored)
+			}
+			for i, received := range server.Received() {
+				if received.Frame.Type != protocol.TypeBets {
+					continue
+				}
+				if received.Size > MaxBatchSize {
+					t.Fatalf("batch of %v bytes on the wire exceeds the %v bytes budget", received.Size, MaxBatchSize)
+				}
+				// The first batch is built before the handshake
+				if first := i == 1; !first && received.Compressed != test.compressed {
+					t.Fatalf("expected compressed batches to be %v, got %v", test.compressed, received.Compressed)
+				}
+			}
+			batches[test.name] = framesOfType(server, protocol.TypeBets)
+		})
+	}
+	if batches["compressed"]*3 > batches["uncompressed"] {
+		t.Fatalf("expected compression to fit at least 3 times more bets per batch, got %v batches instead of %v", batches["compressed"], batches["uncompressed"])
+	}
+}
+
+func TestCompressedWinnersAreDecompressed(t *testing.T) {
+	server := startFakeServer(t)
+	documents := make([]string, 1000)
+	for i := range documents {
+		documents[i] = fmt.Sprint(30000000 + i)
+	}
+	server.SetWinners("1", documents...)
+
+	config := betsConfig(server.Addr(), writeDataset(t, testDataset))
+	config.Capabilities = []string{protocol.CapabilityCompression}
+	client := NewClient(config, NewClientMetrics(metrics.NewRegistry()))
+	if err := client.connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.closeConnection()
+	response, err := client.exchange(protocol.WinnersQuery{Agency: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if winners, ok := response.(protocol.Winners); !ok || len(winners.Documents) != len(documents) {
+		t.Fatalf("unexpected response %v", response)
+	}
+}
@@ -0,0 +1,283 @@
+package common
+
+import (
+	"fmt"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"sync"
+
+	"github.com/op/go-logging"
+	"github.com/pkg/errors"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/lottery"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/transport"
+)
+
+// ControlServiceName Name the control service is registered with. Its
+// methods are called as Agency.Status, Agency.Pause, etc.
+const ControlServiceName = "Agency"
+
+// pipeline Gate the batches of the dataset go through. While paused, the
+// client finishes the batch in flight and waits before the next one
+type pipeline struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	paused bool
+}
+
+func newPipeline() *pipeline {
+	p := &pipeline{}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// wait Blocks while the pipeline is paused
+func (p *pipeline) wait() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.paused {
+		p.cond.Wait()
+	}
+}
+
+func (p *pipeline) pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paused = true
+}
+
+func (p *pipeline) resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paused = false
+	p.cond.Broadcast()
+}
+
+func (p *pipeline) isPaused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused
+}
+
+// SetLogLevel Changes the level of the client logs while it runs. The level
+// is one of the names accepted by go-logging, such as DEBUG or INFO
+func SetLogLevel(level string) error {
+	code, err := logging.LogLevel(level)
+	if err != nil {
+		return err
+	}
+	logging.SetLevel(code, "log")
+	return nil
+}
+
+// Empty Arguments of the control methods that take none
+type Empty struct{}
+
+// Status State of the client as reported by the control service
+type Status struct {
+	ID           string `json:"id"`
+	Mode         string `json:"mode"`
+	Paused       bool   `json:"paused"`
+	BetsRead     uint64 `json:"bets_read"`
+	BetsSent     uint64 `json:"bets_sent"`
+	BetsRejected uint64 `json:"bets_rejected"`
+	BatchesAcked uint64 `json:"batches_acked"`
+	Reconnects   uint64 `json:"reconnects"`
+	LogLevel     string `json:"log_level"`
+}
+
+// SubmitReply Result of a bet submitted through the control service
+type SubmitReply struct {
+	BatchID  uint32 `json:"batch_id"`
+	Rejected bool   `json:"rejected"`
+}
+
+// WinnersReply Result of a winners query triggered through the control
+// service. Pending is set when the lottery has not taken place yet
+type WinnersReply struct {
+	Pending bool     `json:"pending"`
+	Winners []string `json:"winners"`
+}
+
+// LogLevelArgs Arguments of Agency.SetLogLevel
+type LogLevelArgs struct {
+	Level string `json:"level"`
+}
+
+// ControlService Methods of the client exposed through JSON-RPC. Every
+// exported method follows the net/rpc conventions
+type ControlService struct {
+	client *Client
+}
+
+// Status Reports the state of the client
+func (s *ControlService) Status(args Empty, reply *Status) error {
+	c := s.client
+	*reply = Status{
+		ID:           c.config.ID,
+		Mode:         c.config.Mode,
+		Paused:       c.pipeline.isPaused(),
+		BetsRead:     c.metrics.BetsRead.Value(),
+		BetsSent:     c.metrics.BetsSent.Value(),
+		BetsRejected: c.metrics.BetsRejected.Value(),
+		BatchesAcked: c.metrics.BatchesAcked.Value(),
+		Reconnects:   c.metrics.Reconnects.Value(),
+		LogLevel:     logging.GetLevel("log").String(),
+	}
+	return nil
+}
+
+// Pause Stops sending the batches of the dataset after the one in flight
+func (s *ControlService) Pause(args Empty, reply *Status) error {
+	s.client.pipeline.pause()
+	log.Infof("action: control_pause | result: success | client_id: %v", s.client.config.ID)
+	return s.Status(args, reply)
+}
+
+// Resume Sends the batches of the dataset again
+func (s *ControlService) Resume(args Empty, reply *Status) error {
+	s.client.pipeline.resume()
+	log.Infof("action: control_resume | result: success | client_id: %v", s.client.config.ID)
+	return s.Status(args, reply)
+}
+
+// SubmitBet Sends a bet of the agency in a batch of its own. The client id
+// defaults to the agency, and bets of other agencies are refused
+func (s *ControlService) SubmitBet(args lottery.JSONBetData, reply *SubmitReply) error {
+	c := s.client
+	if args.ClientID == "" {
+		args.ClientID = c.config.ID
+	}
+	if args.ClientID != c.config.ID {
+		return fmt.Errorf("client_id %q does not match agency %q", args.ClientID, c.config.ID)
+	}
+	bet, err := lottery.JSONBet{Type: lottery.JSONBetType, Data: args}.Bet()
+	if err != nil {
+		return err
+	}
+	batchID, rejected, err := c.SubmitBet(bet)
+	if err != nil {
+		log.Errorf("action: control_apuesta | result: fail | client_id: %v | dni: %v | error: %v", c.config.ID, bet.Document, err)
+		return err
+	}
+	log.Infof("action: control_apuesta | result: success | client_id: %v | dni: %v | numero: %v | rechazada: %v", c.config.ID, bet.Document, bet.Number, rejected)
+	*reply = SubmitReply{BatchID: batchID, Rejected: rejected}
+	return nil
+}
+
+// QueryWinners Asks the server once for the winners of the agency
+func (s *ControlService) QueryWinners(args Empty, reply *WinnersReply) error {
+	winners, err := s.client.Winners()
+	if errors.Is(err, ErrWinnersPending) {
+		*reply = WinnersReply{Pending: true, Winners: []string{}}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if winners == nil {
+		winners = []string{}
+	}
+	*reply = WinnersReply{Winners: winners}
+	return nil
+}
+
+// SetLogLevel Changes the level of the client logs
+func (s *ControlService) SetLogLevel(args LogLevelArgs, reply *Status) error {
+	if err := SetLogLevel(args.Level); err != nil {
+		return err
+	}
+	log.Infof("action: control_log_level | result: success | client_id: %v | log_level: %v", s.client.config.ID, args.Level)
+	return s.Status(Empty{}, reply)
+}
+
+// ControlServer Optional JSON-RPC listener that lets an operator drive a
+// running client. Like the admin server, it is never meant for the agency
+// traffic
+type ControlServer struct {
+	address  string
+	server   *rpc.Server
+	listener net.Listener
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+// NewControlServer Initializes the control server of the client. The address
+// takes the same schemes as the server address, such as unix:///path.
+// Nothing is listening until Start is called
+func NewControlServer(address string, client *Client) (*ControlServer, error) {
+	server := rpc.NewServer()
+	if err := server.RegisterName(ControlServiceName, &ControlService{client: client}); err != nil {
+		return nil, err
+	}
+	return &ControlServer{
+		address: address,
+		server:  server,
+		conns:   make(map[net.Conn]struct{}),
+	}, nil
+}
+
+// Start Binds the control address and serves requests in background
+func (s *ControlServer) Start() error {
+	listener, err := transport.Listen(s.address)
+	if err != nil {
+		return errors.Wrapf(err, "Could not listen on control address %v", s.address)
+	}
+	s.listener = listener
+	go s.accept()
+	log.Infof("action: control_serve | result: in_progress | address: %v", s.Addr())
+	return nil
+}
+
+// accept Serves every connection with its own JSON-RPC codec until the
+// listener is closed
+func (s *ControlServer) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		if !s.track(conn) {
+			conn.Close()
+			return
+		}
+		go func() {
+			s.server.ServeCodec(jsonrpc.NewServerCodec(conn))
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// track Remembers the connection so Close can end it. Returns false once
+// the server is closed
+func (s *ControlServer) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+// Addr Returns the address the server is bound to, with the scheme of its
+// transport. Only valid after Start
+func (s *ControlServer) Addr() string {
+	return transport.URL(s.listener.Addr())
+}
+
+// Close Stops the listener and every open connection
+func (s *ControlServer) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	return s.listener.Close()
+}
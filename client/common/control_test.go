@@ -0,0 +1,186 @@
+package common
+
+import (
+	"encoding/json"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/fakeserver"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/lottery"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/metrics"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/transport"
+)
+
+// startControl Starts the control service of the client and connects to it
+func startControl(t *testing.T, client *Client) (*ControlServer, *rpc.Client) {
+	t.Helper()
+	control, err := NewControlServer("tcp://127.0.0.1:0", client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := control.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { control.Close() })
+	conn, err := transport.Dial(control.Addr(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rpcClient := jsonrpc.NewClient(conn)
+	t.Cleanup(func() { rpcClient.Close() })
+	return control, rpcClient
+}
+
+func TestControlServiceDrivesTheBetsPipeline(t *testing.T) {
+	quietLogs(t)
+	server := startFakeServer(t)
+	server.SetWinners("1", "30904465")
+	client := NewClient(betsConfig(server.Addr(), writeDataset(t, testDataset)), NewClientMetrics(metrics.NewRegistry()))
+	_, rpcClient := startControl(t, client)
+
+	var status Status
+	if err := rpcClient.Call("Agency.Pause", Empty{}, &status); err != nil || !status.Paused {
+		t.Fatalf("expected the client to be paused, got %+v %v", status, err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- client.StartClientLoop() }()
+
+	bet := lottery.JSONBetData{FirstName: "Ada", LastName: "Lovelace", DocumentNumber: "40123456", BirthDate: "1990-12-10", Number: "1815"}
+	var submitted SubmitReply
+	if err := rpcClient.Call("Agency.SubmitBet", bet, &submitted); err != nil || submitted.Rejected {
+		t.Fatalf("expected the bet to be stored, got %+v %v", submitted, err)
+	}
+	if documents := storedDocuments(server); documents != "40123456" {
+		t.Fatalf("expected only the submitted bet while paused, got %v", documents)
+	}
+	bet.ClientID = "2"
+	if err := rpcClient.Call("Agency.SubmitBet", bet, &submitted); err == nil {
+		t.Fatal("expected a bet of another agency to be refused")
+	}
+
+	var winners WinnersReply
+	if err := rpcClient.Call("Agency.QueryWinners", Empty{}, &winners); err != nil || !reflect.DeepEqual(winners.Winners, []string{"30904465"}) {
+		t.Fatalf("expected the winners of the agency, got %+v %v", winners, err)
+	}
+
+	if err := rpcClient.Call("Agency.SetLogLevel", LogLevelArgs{Level: "WARNING"}, &status); err != nil || status.LogLevel != "WARNING" {
+		t.Fatalf("expected the log level to change, got %+v %v", status, err)
+	}
+	if err := rpcClient.Call("Agency.SetLogLevel", LogLevelArgs{Level: "LOUD"}, &status); err == nil {
+		t.Fatal("expected an unknown log level to fail")
+	}
+
+	if err := rpcClient.Call("Agency.Status", Empty{}, &status); err != nil || !status.Paused || status.BetsSent != 1 || status.Mode != ModeBets {
+		t.Fatalf("unexpected status %+v %v", status, err)
+	}
+	if err := rpcClient.Call("Agency.Resume", Empty{}, &status); err != nil || status.Paused {
+		t.Fatalf("expected the client to be resumed, got %+v %v", status, err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the client loop to finish once resumed")
+	}
+	// The batches of the dataset are numbered after the submitted one, so
+	// none of them is discarded as a duplicate
+	if documents := storedDocuments(server); documents != "40123456,"+testDocuments {
+		t.Fatalf("expected every bet stored once, got %v", documents)
+	}
+}
+
+// TestControlServiceAnswersWhileWaitingForPushedWinners Queries the winners
+// through the control service while the client loop waits for the server to
+// push them
+func TestControlServiceAnswersWhileWaitingForPushedWinners(t *testing.T) {
+	quietLogs(t)
+	server := startFakeServer(t)
+	server.SetPendingQueries(2)
+	server.SetWinners("1", "30904465")
+	config := betsConfig(server.Addr(), writeDataset(t, testDataset))
+	config.Capabilities = SupportedCapabilities
+	client := NewClient(config, NewClientMetrics(metrics.NewRegistry()))
+	_, rpcClient := startControl(t, client)
+
+	done := make(chan error, 1)
+	go func() { done <- client.StartClientLoop() }()
+	for deadline := time.Now().Add(5 * time.Second); !awaitingPush(client, server); {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the client to ask for the winners")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// The session left with the connection handed over to the wait
+	client.mu.Lock()
+	session := client.session
+	client.mu.Unlock()
+	if session != nil {
+		t.Fatalf("expected no session while waiting for the winners, got %v", session)
+	}
+
+	call := rpcClient.Go("Agency.QueryWinners", Empty{}, &WinnersReply{}, nil)
+	select {
+	case <-call.Done:
+		if reply := call.Reply.(*WinnersReply); call.Error != nil || !reply.Pending {
+			t.Fatalf("expected the lottery to be pending, got %+v %v", reply, call.Error)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the query not to wait for the pushed winners")
+	}
+
+	server.Draw()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the client loop to receive the pushed winners")
+	}
+}
+
+// awaitingPush Whether the client asked for the winners and handed the
+// connection over to wait for them
+func awaitingPush(client *Client, server *fakeserver.Server) bool {
+	if framesOfType(server, protocol.TypeWinnersQuery) == 0 {
+		return false
+	}
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.conn == nil
+}
+
+// TestControlServiceAcceptsJSONRPC2Requests Sends a request the way a
+// JSON-RPC 2.0 client would, with its parameters in a one element array
+func TestControlServiceAcceptsJSONRPC2Requests(t *testing.T) {
+	quietLogs(t)
+	client := NewClient(betsConfig("tcp://127.0.0.1:1", ""), NewClientMetrics(metrics.NewRegistry()))
+	control, _ := startControl(t, client)
+
+	conn, err := transport.Dial(control.Addr(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Write([]byte(`{"jsonrpc": "2.0", "method": "Agency.Status", "params": [{}], "id": 7}`)); err != nil {
+		t.Fatal(err)
+	}
+	var response struct {
+		ID     int         `json:"id"`
+		Result Status      `json:"result"`
+		Error  interface{} `json:"error"`
+	}
+	if err := json.NewDecoder(conn).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.ID != 7 || response.Error != nil || response.Result.ID != "1" {
+		t.Fatalf("unexpected response %+v", response)
+	}
+}
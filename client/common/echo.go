@@ -0,0 +1,228 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Payload kinds supported by the echo loop
+const (
+	// PayloadText The classic "[CLIENT id] Message N°n" message
+	PayloadText = "text"
+	// PayloadRandom Random printable ASCII characters
+	PayloadRandom = "random"
+	// PayloadPattern Printable ASCII characters incrementing by one per byte,
+	// starting at an offset that depends on the message number
+	PayloadPattern = "pattern"
+)
+
+// DefaultPayloadSize Size used by the random and pattern payloads when no
+// size is configured
+const DefaultPayloadSize = 64
+
+// The echo protocol is line based and the server decodes messages as UTF-8,
+// so generated payloads are restricted to printable ASCII. Neither newlines
+// nor trailing whitespace (stripped by the server) can show up
+const (
+	firstPrintable = '!'
+	lastPrintable  = '~'
+)
+
+// EchoConfig Payload sent on every iteration of the echo loop
+type EchoConfig struct {
+	Payload string
+	Size    int
+}
+
+// payloadGenerator Builds the payload of every message of the loop
+type payloadGenerator struct {
+	config EchoConfig
+	id     string
+	random *rand.Rand
+}
+
+func newPayloadGenerator(config EchoConfig, id string) (*payloadGenerator, error) {
+	if config.Payload == "" {
+		config.Payload = PayloadText
+	}
+	switch config.Payload {
+	case PayloadText, PayloadRandom, PayloadPattern:
+	default:
+		return nil, fmt.Errorf("unknown echo payload %q", config.Payload)
+	}
+	if config.Size < 0 {
+		return nil, fmt.Errorf("invalid echo payload size %v", config.Size)
+	}
+	if config.Size == 0 {
+		config.Size = DefaultPayloadSize
+	}
+
+	return &payloadGenerator{
+		config: config,
+		id:     id,
+		random: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}, nil
+}
+
+// next Returns the payload of the given message, without the line terminator
+func (g *payloadGenerator) next(msgID int) []byte {
+	switch g.config.Payload {
+	case PayloadRandom:
+		payload := make([]byte, g.config.Size)
+		for i := range payload {
+			payload[i] = byte(firstPrintable + g.random.Intn(lastPrintable-firstPrintable+1))
+		}
+		return payload
+	case PayloadPattern:
+		payload := make([]byte, g.config.Size)
+		for i := range payload {
+			payload[i] = byte(firstPrintable + (msgID+i)%(lastPrintable-firstPrintable+1))
+		}
+		return payload
+	default:
+		return []byte(fmt.Sprintf("[CLIENT %v] Message N°%v", g.id, msgID))
+	}
+}
+
+// echoStats Outcome of every message sent by the echo loop
+type echoStats struct {
+	rtts       []time.Duration
+	mismatches int
+	failures   int
+}
+
+func (s *echoStats) success(rtt time.Duration) {
+	s.rtts = append(s.rtts, rtt)
+}
+
+func (s *echoStats) mismatch(rtt time.Duration) {
+	s.rtts = append(s.rtts, rtt)
+	s.mismatches++
+}
+
+func (s *echoStats) failure() {
+	s.failures++
+}
+
+// ok Returns true if every message came back unaltered
+func (s *echoStats) ok() bool {
+	return s.mismatches == 0 && s.failures == 0
+}
+
+// summary Returns min, average, 95th percentile and max of the round trip
+// times. All of them are zero if no message got an answer
+func (s *echoStats) summary() (min, avg, p95, max time.Duration) {
+	if len(s.rtts) == 0 {
+		return 0, 0, 0, 0
+	}
+	sorted := append([]time.Duration(nil), s.rtts...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var total time.Duration
+	for _, rtt := range sorted {
+		total += rtt
+	}
+	// Nearest rank percentile: the smallest value with at least 95% of the
+	// samples lower or equal to it
+	rank := (95*len(sorted) + 99) / 100
+	return sorted[0], total / time.Duration(len(sorted)), sorted[rank-1], sorted[len(sorted)-1]
+}
+
+// runEchoLoop Send messages to the client until some time threshold is met
+func (c *Client) runEchoLoop() error {
+	payloads, err := newPayloadGenerator(c.config.Echo, c.config.ID)
+	if err != nil {
+		log.Criticalf("action: loop_finished | result: fail | client_id: %v | error: %v",
+			c.config.ID,
+			err,
+		)
+		return err
+	}
+	stats := &echoStats{}
+
+	// There is an autoincremental msgID to identify every message sent
+	// Messages if the message amount threshold has not been surpassed
+	for msgID := 1; msgID <= c.config.LoopAmount; msgID++ {
+		c.echoMessage(payloads.next(msgID), stats)
+
+		// Wait a time between sending one message and the next one
+		c.clock.Sleep(c.config.LoopPeriod)
+	}
+
+	result := "success"
+	if !stats.ok() {
+		result = "fail"
+	}
+	min, avg, p95, max := stats.summary()
+	log.Infof("action: loop_finished | result: %v | client_id: %v | messages: %v | mismatches: %v | failures: %v | rtt_min: %v | rtt_avg: %v | rtt_p95: %v | rtt_max: %v",
+		result,
+		c.config.ID,
+		c.config.LoopAmount,
+		stats.mismatches,
+		stats.failures,
+		min,
+		avg,
+		p95,
+		max,
+	)
+	if !stats.ok() {
+		return fmt.Errorf("%v mismatches and %v failures", stats.mismatches, stats.failures)
+	}
+	return nil
+}
+
+// echoMessage Sends a single message through a new connection and verifies
+// the server answers exactly the same bytes. The outcome is recorded in stats
+func (c *Client) echoMessage(payload []byte, stats *echoStats) {
+	// Create the connection the server in every loop iteration
+	if err := c.createClientSocket(); err != nil {
+		stats.failure()
+		return
+	}
+	defer c.closeConnection()
+
+	// net.Conn writes the whole buffer or fails, so short writes surface as errors
+	start := c.clock.Now()
+	msg := append(payload, '\n')
+	if _, err := c.conn.Write(msg); err != nil {
+		log.Errorf("action: send_message | result: fail | client_id: %v | error: %v",
+			c.config.ID,
+			err,
+		)
+		stats.failure()
+		return
+	}
+
+	reply, err := bufio.NewReader(c.conn).ReadBytes('\n')
+	if err != nil {
+		log.Errorf("action: receive_message | result: fail | client_id: %v | error: %v",
+			c.config.ID,
+			err,
+		)
+		stats.failure()
+		return
+	}
+	rtt := c.clock.Now().Sub(start)
+	c.metrics.RTT.Observe(rtt.Seconds())
+
+	if !bytes.Equal(reply, msg) {
+		log.Errorf("action: receive_message | result: fail | client_id: %v | error: echo mismatch, sent %v bytes and received %v bytes | msg: %s",
+			c.config.ID,
+			len(msg),
+			len(reply),
+			reply,
+		)
+		stats.mismatch(rtt)
+		return
+	}
+	stats.success(rtt)
+
+	log.Infof("action: receive_message | result: success | client_id: %v | msg: %s",
+		c.config.ID,
+		reply,
+	)
+}
@@ -0,0 +1,177 @@
+package common
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/metrics"
+)
+
+// startLineServer Listens on a loopback port and answers the first line of
+// every connection with reply(line), closing the connection afterwards like
+// the python server does
+func startLineServer(t *testing.T, reply func(line string) string) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			if err == nil {
+				io.WriteString(conn, reply(line))
+			}
+			conn.Close()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func startEchoServer(t *testing.T) string {
+	return startLineServer(t, func(line string) string { return line })
+}
+
+func TestPayloadGeneratorKinds(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   EchoConfig
+		expected func(payload []byte) bool
+	}{
+		{
+			name:   "text is the classic message",
+			config: EchoConfig{},
+			expected: func(p []byte) bool {
+				return string(p) == "[CLIENT 7] Message N°3"
+			},
+		},
+		{
+			name:   "random honours the size",
+			config: EchoConfig{Payload: PayloadRandom, Size: 500},
+			expected: func(p []byte) bool {
+				return len(p) == 500 && printable(p)
+			},
+		},
+		{
+			name:   "random defaults the size",
+			config: EchoConfig{Payload: PayloadRandom},
+			expected: func(p []byte) bool {
+				return len(p) == DefaultPayloadSize && printable(p)
+			},
+		},
+		{
+			name:   "pattern increments from the message number",
+			config: EchoConfig{Payload: PayloadPattern, Size: 4},
+			expected: func(p []byte) bool {
+				return string(p) == "$%&'"
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			generator, err := newPayloadGenerator(test.config, "7")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if payload := generator.next(3); !test.expected(payload) {
+				t.Fatalf("unexpected payload %q", payload)
+			}
+		})
+	}
+}
+
+func TestPayloadGeneratorRejectsInvalidConfig(t *testing.T) {
+	if _, err := newPayloadGenerator(EchoConfig{Payload: "zeros"}, "1"); err == nil {
+		t.Fatal("expected unknown payload to fail")
+	}
+	if _, err := newPayloadGenerator(EchoConfig{Payload: PayloadRandom, Size: -1}, "1"); err == nil {
+		t.Fatal("expected negative size to fail")
+	}
+}
+
+func TestEchoStatsSummary(t *testing.T) {
+	stats := &echoStats{}
+	for i := 1; i <= 20; i++ {
+		stats.success(time.Duration(i) * time.Millisecond)
+	}
+	min, avg, p95, max := stats.summary()
+
+	if min != time.Millisecond || max != 20*time.Millisecond {
+		t.Fatalf("unexpected bounds %v %v", min, max)
+	}
+	if avg != 10500*time.Microsecond {
+		t.Fatalf("unexpected average %v", avg)
+	}
+	if p95 != 19*time.Millisecond {
+		t.Fatalf("unexpected p95 %v", p95)
+	}
+}
+
+func TestClientLoopCountsMismatchesAndFailures(t *testing.T) {
+	// Alter the reply of the second message only
+	address := startLineServer(t, func(line string) string {
+		if strings.Contains(line, "N°2") {
+			return "[CLIENT 1] Message N°2 altered\n"
+		}
+		return line
+	})
+
+	registry := metrics.NewRegistry()
+	client := NewClient(ClientConfig{
+		ID:            "1",
+		ServerAddress: address,
+		LoopAmount:    3,
+		LoopPeriod:    time.Millisecond,
+	}, NewClientMetrics(registry))
+
+	payloads, err := newPayloadGenerator(client.config.Echo, client.config.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := &echoStats{}
+	for msgID := 1; msgID <= 3; msgID++ {
+		client.echoMessage(payloads.next(msgID), stats)
+	}
+	if stats.mismatches != 1 || stats.failures != 0 || len(stats.rtts) != 3 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	// Nobody listens on the address anymore
+	listenerless := NewClient(ClientConfig{ID: "1", ServerAddress: closedAddress(t)}, NewClientMetrics(metrics.NewRegistry()))
+	stats = &echoStats{}
+	listenerless.echoMessage(payloads.next(1), stats)
+	if stats.failures != 1 || stats.ok() {
+		t.Fatalf("expected a failure, got %+v", stats)
+	}
+}
+
+func closedAddress(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	return address
+}
+
+func printable(p []byte) bool {
+	for _, b := range p {
+		if b < firstPrintable || b > lastPrintable {
+			return false
+		}
+	}
+	return true
+}
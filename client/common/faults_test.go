@@ -0,0 +1,173 @@
+package common
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/fakeserver"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/faultproxy"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/metrics"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+)
+
+// storedDocuments Documents of the bets stored by the server, in order
+func storedDocuments(server *fakeserver.Server) string {
+	var documents []string
+	for _, bet := range server.Bets("1") {
+		documents = append(documents, bet.Document)
+	}
+	return strings.Join(documents, ",")
+}
+
+// helloReplySize Bytes of the hello reply that opens every connection, which
+// downstream faults skip to hit the ack of the first batch
+const helloReplySize = 5
+
+// TestBetsSurviveNetworkFaults Runs the client through the fault injecting
+// proxy. Every fault is injected on the first connection, which carries all
+// the batches, and the client must still deliver each bet exactly once
+func TestBetsSurviveNetworkFaults(t *testing.T) {
+	tests := []struct {
+		name    string
+		fault   faultproxy.Fault
+		timeout time.Duration
+		// Expected batch frames received by the server and connections
+		// opened by the client
+		batchFrames int
+		connections int
+	}{
+		{
+			name:        "latency",
+			fault:       faultproxy.Fault{Kind: faultproxy.Latency, Delay: 5 * time.Millisecond},
+			batchFrames: 3,
+			connections: 2,
+		},
+		{
+			name:        "bandwidth limit",
+			fault:       faultproxy.Fault{Kind: faultproxy.Bandwidth, BytesPerSecond: 4000},
+			batchFrames: 3,
+			connections: 2,
+		},
+		{
+			name:        "1-byte fragments",
+			fault:       faultproxy.Fault{Kind: faultproxy.Fragment},
+			batchFrames: 3,
+			connections: 2,
+		},
+		{
+			name:        "reset in the middle of a request",
+			fault:       faultproxy.Fault{Kind: faultproxy.Reset, AfterBytes: 10, Direction: faultproxy.Upstream},
+			batchFrames: 3,
+			connections: 3,
+		},
+		{
+			name:        "reset before the ack",
+			fault:       faultproxy.Fault{Kind: faultproxy.Reset, AfterBytes: helloReplySize, Direction: faultproxy.Downstream},
+			batchFrames: 4,
+			connections: 3,
+		},
+		{
+			name:        "half-close towards the server",
+			fault:       faultproxy.Fault{Kind: faultproxy.HalfClose, Direction: faultproxy.Upstream},
+			batchFrames: 3,
+			connections: 3,
+		},
+		{
+			name:        "half-close in the middle of the ack",
+			fault:       faultproxy.Fault{Kind: faultproxy.HalfClose, AfterBytes: helloReplySize + 3, Direction: faultproxy.Downstream},
+			batchFrames: 4,
+			connections: 3,
+		},
+		{
+			name:        "black hole towards the server",
+			fault:       faultproxy.Fault{Kind: faultproxy.BlackHole, Direction: faultproxy.Upstream},
+			timeout:     100 * time.Millisecond,
+			batchFrames: 3,
+			connections: 3,
+		},
+		{
+			name:        "black hole towards the client",
+			fault:       faultproxy.Fault{Kind: faultproxy.BlackHole, AfterBytes: helloReplySize, Direction: faultproxy.Downstream},
+			timeout:     100 * time.Millisecond,
+			batchFrames: 4,
+			connections: 3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, err := fakeserver.Start()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer server.Close()
+			proxy, err := faultproxy.Start(faultproxy.Config{
+				Target:   server.Addr(),
+				Schedule: faultproxy.Plan{{test.fault}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer proxy.Close()
+
+			config := betsConfig(proxy.Addr(), writeDataset(t, testDataset))
+			if test.timeout > 0 {
+				config.Timeout = test.timeout
+			}
+			if err := NewClient(config, NewClientMetrics(metrics.NewRegistry())).StartClientLoop(); err != nil {
+				t.Fatal(err)
+			}
+
+			if documents := storedDocuments(server); documents != testDocuments {
+				t.Errorf("expected every bet stored once and in order, got %v", documents)
+			}
+			if frames := framesOfType(server, protocol.TypeBets); frames != test.batchFrames {
+				t.Errorf("expected %v batch frames, got %v", test.batchFrames, frames)
+			}
+			if connections := proxy.Connections(); connections != test.connections {
+				t.Errorf("expected %v connections, got %v", test.connections, connections)
+			}
+			if !server.Finished("1") {
+				t.Error("expected the delivery to be finished")
+			}
+		})
+	}
+}
+
+func TestBetsSurviveSeededRandomFaults(t *testing.T) {
+	candidates, err := faultproxy.ParseFaults("latency=2ms,bandwidth=8000,fragment,reset@200,halfclose@200,blackhole@200")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for seed := int64(1); seed <= 5; seed++ {
+		server, err := fakeserver.Start()
+		if err != nil {
+			t.Fatal(err)
+		}
+		server.SetPendingQueries(2)
+		proxy, err := faultproxy.Start(faultproxy.Config{
+			Target:   server.Addr(),
+			Schedule: faultproxy.RandomSchedule{Seed: seed, Probability: 0.5, Candidates: candidates},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		config := betsConfig(proxy.Addr(), writeDataset(t, testDataset))
+		config.Timeout = 100 * time.Millisecond
+		config.RetryAttempts = 10
+		err = NewClient(config, NewClientMetrics(metrics.NewRegistry())).StartClientLoop()
+		documents := storedDocuments(server)
+		proxy.Close()
+		server.Close()
+
+		if err != nil {
+			t.Fatalf("seed %v: %v", seed, err)
+		}
+		if documents != testDocuments {
+			t.Fatalf("seed %v: expected every bet stored once and in order, got %v", seed, documents)
+		}
+	}
+}
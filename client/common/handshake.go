@@ -0,0 +1,73 @@
+package common
+
+import (
+	"strings"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+)
+
+// SupportedCapabilities Optional protocol features implemented by the client
+var SupportedCapabilities = []string{protocol.CapabilityPush, protocol.CapabilityLargeFrames, protocol.CapabilityCompression, protocol.CapabilityChecksums}
+
+// connect Opens a connection to the lottery server and performs the protocol
+// handshake on it
+func (c *Client) connect() error {
+	if err := c.createClientSocket(); err != nil {
+		return err
+	}
+	if c.config.Timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.config.Timeout))
+	}
+	if err := c.handshake(); err != nil {
+		c.closeConnection()
+		return err
+	}
+	return nil
+}
+
+// handshake Sends the hello of the agency and enables the capabilities
+// supported by both ends. A server of another protocol version fails the
+// handshake with a *protocol.VersionError
+func (c *Client) handshake() error {
+	hello := protocol.Hello{
+		Version:      protocol.Version,
+		Agency:       c.config.ID,
+		Capabilities: protocol.Negotiate(c.config.Capabilities, SupportedCapabilities),
+	}
+	response, err := c.exchange(hello)
+	if err != nil {
+		return err
+	}
+	reply, ok := response.(protocol.HelloReply)
+	if !ok {
+		return unexpectedResponse(response)
+	}
+	if reply.Version != protocol.Version {
+		err := &protocol.VersionError{Client: protocol.Version, Server: reply.Version}
+		log.Criticalf("action: handshake | result: fail | client_id: %v | client_version: %v | server_version: %v | error: %v",
+			c.config.ID,
+			protocol.Version,
+			reply.Version,
+			err,
+		)
+		return err
+	}
+
+	// The server can only enable what was offered, anything else is ignored
+	c.session = protocol.Negotiate(hello.Capabilities, reply.Capabilities)
+	c.framing.Continuation = c.enabled(protocol.CapabilityLargeFrames)
+	c.framing.Checksums = c.enabled(protocol.CapabilityChecksums)
+	log.Debugf("action: handshake | result: success | client_id: %v | version: %v | capabilities: %v",
+		c.config.ID,
+		reply.Version,
+		strings.Join(c.session, ","),
+	)
+	return nil
+}
+
+// enabled Returns true if the capability was negotiated for the current
+// connection
+func (c *Client) enabled(capability string) bool {
+	return protocol.Has(c.session, capability)
+}
@@ -0,0 +1,193 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/fakeserver"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/metrics"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+)
+
+func startFakeServer(t *testing.T) *fakeserver.Server {
+	t.Helper()
+	server, err := fakeserver.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+// hellos Hello messages received by the server, in order
+func hellos(t *testing.T, server *fakeserver.Server) []protocol.Hello {
+	t.Helper()
+	var hellos []protocol.Hello
+	for _, received := range server.Received() {
+		if received.Frame.Type != protocol.TypeHello {
+			continue
+		}
+		msg, err := protocol.Decode(received.Frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hellos = append(hellos, msg.(protocol.Hello))
+	}
+	return hellos
+}
+
+func TestEveryConnectionStartsWithAHello(t *testing.T) {
+	server := startFakeServer(t)
+	config := betsConfig(server.Addr(), writeDataset(t, testDataset))
+	if err := NewClient(config, NewClientMetrics(metrics.NewRegistry())).StartClientLoop(); err != nil {
+		t.Fatal(err)
+	}
+
+	connections := server.Connections()
+	received := hellos(t, server)
+	if len(received) != connections {
+		t.Fatalf("expected a hello on each of the %v connections, got %v", connections, len(received))
+	}
+	for _, hello := range received {
+		if hello.Version != protocol.Version || hello.Agency != "1" || len(hello.Capabilities) != 0 {
+			t.Fatalf("unexpected hello %+v", hello)
+		}
+	}
+	opened := make(map[int]bool)
+	for _, r := range server.Received() {
+		if !opened[r.Conn] && r.Frame.Type != protocol.TypeHello {
+			t.Fatalf("connection %v started with a %v frame", r.Conn, r.Frame.Type)
+		}
+		opened[r.Conn] = true
+	}
+}
+
+func TestHandshakeEnablesOnlyMutualCapabilities(t *testing.T) {
+	server := startFakeServer(t)
+	server.SetCapabilities(protocol.CapabilityPush, protocol.CapabilityChecksums)
+
+	config := betsConfig(server.Addr(), writeDataset(t, testDataset))
+	config.Capabilities = []string{protocol.CapabilityCompression, protocol.CapabilityPush, "future"}
+	client := NewClient(config, NewClientMetrics(metrics.NewRegistry()))
+	if err := client.connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.closeConnection()
+
+	// The client only offers what it implements, whatever the configuration
+	if offered := hellos(t, server)[0].Capabilities; !reflect.DeepEqual(offered, []string{protocol.CapabilityCompression, protocol.CapabilityPush}) {
+		t.Fatalf("unexpected capabilities offered %v", offered)
+	}
+	if !client.enabled(protocol.CapabilityPush) || client.enabled(protocol.CapabilityChecksums) {
+		t.Fatalf("unexpected session %v", client.session)
+	}
+
+	server.SetCapabilities()
+	client.closeConnection()
+	if err := client.connect(); err != nil {
+		t.Fatal(err)
+	}
+	if client.enabled(protocol.CapabilityPush) {
+		t.Fatalf("expected nothing enabled by a server without capabilities, got %v", client.session)
+	}
+}
+
+func TestVersionMismatchFailsWithoutRetrying(t *testing.T) {
+	server := startFakeServer(t)
+	server.SetVersion(protocol.Version + 1)
+	quietLogs(t)
+
+	config := betsConfig(server.Addr(), writeDataset(t, testDataset))
+	err := NewClient(config, NewClientMetrics(metrics.NewRegistry())).StartClientLoop()
+
+	var versionErr *protocol.VersionError
+	if !errors.As(err, &versionErr) || versionErr.Client != protocol.Version || versionErr.Server != protocol.Version+1 {
+		t.Fatalf("expected a version error, got %v", err)
+	}
+	if connections := server.Connections(); connections != 1 {
+		t.Fatalf("expected a single connection, got %v", connections)
+	}
+	if frames := framesOfType(server, protocol.TypeBets); frames != 0 {
+		t.Fatalf("expected no bets sent, got %v batches", frames)
+	}
+
+	if err := ProbeLotteryServer(server.Addr(), nil, nil, "1", time.Second); !errors.As(err, &versionErr) {
+		t.Fatalf("expected the probe to report the version mismatch, got %v", err)
+	}
+}
+
+func TestPushedWinnersReplacePolling(t *testing.T) {
+	server := startFakeServer(t)
+	server.SetPendingQueries(1)
+	server.SetWinners("1", "30904465")
+
+	config := betsConfig(server.Addr(), writeDataset(t, testDataset))
+	config.Capabilities = []string{protocol.CapabilityPush}
+	done := make(chan error, 1)
+	go func() {
+		done <- NewClient(config, NewClientMetrics(metrics.NewRegistry())).StartClientLoop()
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for framesOfType(server, protocol.TypeWinnersQuery) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the client never asked for the winners")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("the client finished before the lottery: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	server.Draw()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if queries := framesOfType(server, protocol.TypeWinnersQuery); queries != 1 {
+		t.Fatalf("expected a single winners query, got %v", queries)
+	}
+}
+
+func TestWinnersLargerThanAFrameNeedLargeFrames(t *testing.T) {
+	documents := make([]string, 10000)
+	for i := range documents {
+		documents[i] = fmt.Sprintf("%08d", i)
+	}
+	dataset := writeDataset(t, testDataset)
+	quietLogs(t)
+
+	tests := []struct {
+		name               string
+		serverCapabilities []string
+		maxFrameSize       int
+		succeeds           bool
+	}{
+		{name: "negotiated", serverCapabilities: []string{protocol.CapabilityLargeFrames}, succeeds: true},
+		{name: "exactly the maximum size", serverCapabilities: []string{protocol.CapabilityLargeFrames}, maxFrameSize: 9*len(documents) - 1, succeeds: true},
+		{name: "above the maximum size", serverCapabilities: []string{protocol.CapabilityLargeFrames}, maxFrameSize: 9*len(documents) - 2},
+		{name: "not supported by the server"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := startFakeServer(t)
+			server.SetCapabilities(test.serverCapabilities...)
+			server.SetWinners("1", documents...)
+
+			config := betsConfig(server.Addr(), dataset)
+			config.Capabilities = SupportedCapabilities
+			config.MaxFrameSize = test.maxFrameSize
+			err := NewClient(config, NewClientMetrics(metrics.NewRegistry())).StartClientLoop()
+			if test.succeeds && err != nil {
+				t.Fatal(err)
+			}
+			if !test.succeeds && err == nil {
+				t.Fatal("expected the winners query to fail")
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package common
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/auth"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/transport"
+)
+
+// DefaultProbeTimeout Maximum time a readiness probe waits for the server
+const DefaultProbeTimeout = 3 * time.Second
+
+// ReadinessCheck Named condition that must hold for the client to be ready
+type ReadinessCheck struct {
+	Name  string
+	Check func() error
+}
+
+// Health Keeps the conditions used to answer the liveness and readiness
+// endpoints of the admin server and the healthcheck command
+type Health struct {
+	mu     sync.Mutex
+	checks []ReadinessCheck
+}
+
+// NewHealth Initializes a health tracker without readiness checks. A client
+// without checks is always ready
+func NewHealth() *Health {
+	return &Health{}
+}
+
+// AddReadinessCheck Registers a condition evaluated on every readiness probe
+func (h *Health) AddReadinessCheck(name string, check func() error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks = append(h.checks, ReadinessCheck{Name: name, Check: check})
+}
+
+// Ready Evaluates every readiness check in registration order and returns
+// the first failure, prefixed with the name of the check
+func (h *Health) Ready() error {
+	h.mu.Lock()
+	checks := append([]ReadinessCheck(nil), h.checks...)
+	h.mu.Unlock()
+
+	for _, check := range checks {
+		if err := check.Check(); err != nil {
+			return errors.Wrapf(err, "%s", check.Name)
+		}
+	}
+	return nil
+}
+
+// ServeLiveness Answers /healthz. The process is alive as long as it is
+// able to answer HTTP requests
+func (h *Health) ServeLiveness(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+// ServeReadiness Answers /readyz with 200 when every readiness check holds
+// and with 503 and the failing check otherwise
+func (h *Health) ServeReadiness(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err := h.Ready(); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, err)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// dialProbe Connects to the server within the timeout, securing the
+// connection with TLS when a configuration is given
+func dialProbe(address string, config *tls.Config, timeout time.Duration) (net.Conn, error) {
+	conn, err := transport.Dial(address, timeout)
+	if err != nil || config == nil {
+		return conn, err
+	}
+	return secure(conn, config, timeout)
+}
+
+// ProbeServer Connects to the server and performs the protocol handshake.
+// For the echo protocol the handshake is a single round trip: the probe
+// message must come back unaltered. A nil TLS configuration probes over
+// plain TCP
+func ProbeServer(address string, config *tls.Config, id string, timeout time.Duration) error {
+	conn, err := dialProbe(address, config, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	probe := fmt.Sprintf("[CLIENT %v] Health check\n", id)
+	if _, err := conn.Write([]byte(probe)); err != nil {
+		return err
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if reply != probe {
+		return fmt.Errorf("unexpected handshake reply %q", reply)
+	}
+	return nil
+}
+
+// ProbeLotteryServer Connects to the lottery server and performs the
+// protocol handshake, which has no side effects. A hello reply of the same
+// protocol version proves that the server is up and can serve the agency. A
+// nil TLS configuration probes over plain TCP, and the hello is authenticated
+// with the secret of the agency when there is one
+func ProbeLotteryServer(address string, config *tls.Config, secret []byte, agency string, timeout time.Duration) error {
+	conn, err := dialProbe(address, config, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	frame, err := protocol.Encode(protocol.Hello{Version: protocol.Version, Agency: agency})
+	if err != nil {
+		return err
+	}
+	if len(secret) > 0 {
+		frame = auth.NewSigner(agency, secret, time.Now).Seal(frame)
+	}
+	if err := protocol.WriteFrame(conn, frame); err != nil {
+		return err
+	}
+	response, err := protocol.ReadMessage(conn)
+	if err != nil {
+		return err
+	}
+	reply, ok := response.(protocol.HelloReply)
+	if !ok {
+		return unexpectedResponse(response)
+	}
+	if reply.Version != protocol.Version {
+		return &protocol.VersionError{Client: protocol.Version, Server: reply.Version}
+	}
+	return nil
+}
+
+// CheckDataset Verifies that the dataset of the agency can be opened. The
+// standard input is always ready
+func CheckDataset(path string) error {
+	if path == StdinDataset {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
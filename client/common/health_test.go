@@ -0,0 +1,91 @@
+package common
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/metrics"
+)
+
+func get(t *testing.T, url string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestProbeServerSucceedsAgainstEchoServer(t *testing.T) {
+	if err := ProbeServer(startEchoServer(t), nil, "1", time.Second); err != nil {
+		t.Fatalf("expected probe to succeed: %v", err)
+	}
+}
+
+func TestProbeServerFailsWhenNobodyListens(t *testing.T) {
+	if err := ProbeServer(closedAddress(t), nil, "1", time.Second); err == nil {
+		t.Fatal("expected probe to fail")
+	}
+}
+
+func TestProbeServerFailsOnUnexpectedReply(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		io.WriteString(conn, "something else\n")
+		conn.Close()
+	}()
+
+	if err := ProbeServer(listener.Addr().String(), nil, "1", time.Second); err == nil {
+		t.Fatal("expected probe to fail")
+	}
+}
+
+func TestAdminServerHealthEndpoints(t *testing.T) {
+	var serverErr error
+	health := NewHealth()
+	health.AddReadinessCheck("server", func() error { return serverErr })
+
+	admin := NewAdminServer("127.0.0.1:0", metrics.NewRegistry(), health)
+	if err := admin.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+	base := "http://" + admin.Addr()
+
+	if code, _ := get(t, base+"/healthz"); code != http.StatusOK {
+		t.Fatalf("expected /healthz to answer 200, got %d", code)
+	}
+	if code, _ := get(t, base+"/readyz"); code != http.StatusOK {
+		t.Fatalf("expected /readyz to answer 200, got %d", code)
+	}
+
+	serverErr = errors.New("connection refused")
+	code, body := get(t, base+"/readyz")
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("expected /readyz to answer 503, got %d", code)
+	}
+	if !strings.Contains(body, "server: connection refused") {
+		t.Fatalf("expected failing check in body, got %q", body)
+	}
+	if code, _ := get(t, base+"/healthz"); code != http.StatusOK {
+		t.Fatalf("liveness must not depend on readiness, got %d", code)
+	}
+}
@@ -0,0 +1,68 @@
+package common
+
+import (
+	"net"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/metrics"
+)
+
+// Names of the metrics exposed by the client. They are part of the public
+// surface of the admin endpoint, so renaming one breaks existing dashboards
+const (
+	MetricBetsRead      = "tp0_client_bets_read_total"
+	MetricBetsSent      = "tp0_client_bets_sent_total"
+	MetricBetsRejected  = "tp0_client_bets_rejected_total"
+	MetricBatchesAcked  = "tp0_client_batches_acked_total"
+	MetricReconnects    = "tp0_client_reconnects_total"
+	MetricBytesSent     = "tp0_client_bytes_sent_total"
+	MetricBytesReceived = "tp0_client_bytes_received_total"
+	MetricCorruptFrames = "tp0_client_corrupt_frames_total"
+	MetricRTT           = "tp0_client_rtt_seconds"
+)
+
+// ClientMetrics Instruments updated by the client while it talks to the server
+type ClientMetrics struct {
+	BetsRead      *metrics.Counter
+	BetsSent      *metrics.Counter
+	BetsRejected  *metrics.Counter
+	BatchesAcked  *metrics.Counter
+	Reconnects    *metrics.Counter
+	BytesSent     *metrics.Counter
+	BytesReceived *metrics.Counter
+	CorruptFrames *metrics.Counter
+	RTT           *metrics.Histogram
+}
+
+// NewClientMetrics Creates the client instruments and registers them in the
+// given registry
+func NewClientMetrics(registry *metrics.Registry) *ClientMetrics {
+	return &ClientMetrics{
+		BetsRead:      registry.NewCounter(MetricBetsRead, "Bets read from the agency dataset."),
+		BetsSent:      registry.NewCounter(MetricBetsSent, "Bets sent to the server."),
+		BetsRejected:  registry.NewCounter(MetricBetsRejected, "Bets the server answered with an error."),
+		BatchesAcked:  registry.NewCounter(MetricBatchesAcked, "Batches acknowledged by the server."),
+		Reconnects:    registry.NewCounter(MetricReconnects, "Connections opened to the server after the first one."),
+		BytesSent:     registry.NewCounter(MetricBytesSent, "Bytes written to the server socket."),
+		BytesReceived: registry.NewCounter(MetricBytesReceived, "Bytes read from the server socket."),
+		CorruptFrames: registry.NewCounter(MetricCorruptFrames, "Frames received from the server that did not match their checksum."),
+		RTT:           registry.NewHistogram(MetricRTT, "Time between sending a message and receiving its response.", metrics.DefaultLatencyBuckets),
+	}
+}
+
+// meteredConn Wraps a connection to account every byte that goes through it
+type meteredConn struct {
+	net.Conn
+	metrics *ClientMetrics
+}
+
+func (m *meteredConn) Read(p []byte) (int, error) {
+	n, err := m.Conn.Read(p)
+	m.metrics.BytesReceived.Add(uint64(n))
+	return n, err
+}
+
+func (m *meteredConn) Write(p []byte) (int, error) {
+	n, err := m.Conn.Write(p)
+	m.metrics.BytesSent.Add(uint64(n))
+	return n, err
+}
@@ -0,0 +1,80 @@
+package common
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/lottery"
+)
+
+// QuarantineEntry A row of the dataset that was not sent, as written to the
+// quarantine
+type QuarantineEntry struct {
+	Agency string `json:"agency"`
+	// Line Line of the row in the dataset. Zero when the row is not
+	// rejected by the reader but when it is encoded for the protocol
+	Line int `json:"line,omitempty"`
+	// Row Content of the row, in the format of the dataset
+	Row    string `json:"row"`
+	Reason string `json:"reason"`
+}
+
+// Quarantine Keeps the rows of the dataset that were skipped, one JSON
+// QuarantineEntry per line, so they can be fixed and sent again. Every
+// dataset format shares it
+type Quarantine struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewQuarantine Creates a quarantine that writes its entries to w
+func NewQuarantine(w io.Writer) *Quarantine {
+	return &Quarantine{enc: json.NewEncoder(w)}
+}
+
+// Add Writes the entry to the quarantine
+func (q *Quarantine) Add(entry QuarantineEntry) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.enc.Encode(entry)
+}
+
+// ReadQuarantine Reads every entry written to a quarantine
+func ReadQuarantine(r io.Reader) ([]QuarantineEntry, error) {
+	var entries []QuarantineEntry
+	dec := json.NewDecoder(r)
+	for {
+		var entry QuarantineEntry
+		err := dec.Decode(&entry)
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// quarantineRow Puts the row rejected by the reader in the quarantine, if
+// there is one
+func (b *batcher) quarantineRow(rowErr *lottery.RowError) {
+	b.quarantineEntry(QuarantineEntry{Agency: b.id, Line: rowErr.Line, Row: rowErr.Row, Reason: rowErr.Err.Error()})
+}
+
+// quarantineBet Puts a bet that could not be encoded in the quarantine, if
+// there is one, as a row of the course dataset
+func (b *batcher) quarantineBet(bet lottery.Bet, err error) {
+	record := []string{bet.FirstName, bet.LastName, bet.Document, bet.Birthdate, bet.Number}
+	b.quarantineEntry(QuarantineEntry{Agency: b.id, Row: lottery.CSVRow(record), Reason: err.Error()})
+}
+
+func (b *batcher) quarantineEntry(entry QuarantineEntry) {
+	if b.quarantine == nil {
+		return
+	}
+	if err := b.quarantine.Add(entry); err != nil {
+		log.Warningf("action: cuarentena | result: fail | client_id: %v | line: %v | error: %v", b.id, entry.Line, err)
+	}
+}
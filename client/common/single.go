@@ -0,0 +1,92 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/lottery"
+)
+
+// Sources of the bets sent in bets mode
+const (
+	// BetSourceAuto Sends the dataset if its file exists, or it is read from
+	// the standard input, and, otherwise, the single bet of SingleBetVars if
+	// any of them is set
+	BetSourceAuto = "auto"
+	// BetSourceDataset Always sends the dataset, ignoring SingleBetVars
+	BetSourceDataset = "dataset"
+	// BetSourceEnv Always sends the single bet of SingleBetVars, which must
+	// all be set
+	BetSourceEnv = "env"
+)
+
+// BetSources Every valid source of the bets
+var BetSources = []string{BetSourceAuto, BetSourceDataset, BetSourceEnv}
+
+// SingleBetVars Environment variables that define the single bet of the
+// agency, in the order of the dataset columns
+var SingleBetVars = []string{"NOMBRE", "APELLIDO", "DOCUMENTO", "NACIMIENTO", "NUMERO"}
+
+// ErrBetRejected The server did not store the single bet
+var ErrBetRejected = errors.New("the server rejected the bet")
+
+// SingleBet Decides, following the source, whether the agency sends the bet
+// made of values, given in the order of SingleBetVars, instead of the
+// dataset at datasetPath. Returns nil when the dataset must be sent. The bet
+// is not validated
+func SingleBet(source string, datasetPath string, agency string, values []string) (*lottery.Bet, error) {
+	var missing []string
+	set := false
+	for i, name := range SingleBetVars {
+		if i >= len(values) || values[i] == "" {
+			missing = append(missing, name)
+		} else {
+			set = true
+		}
+	}
+
+	switch source {
+	case BetSourceDataset:
+		return nil, nil
+	case BetSourceAuto:
+		if !set {
+			return nil, nil
+		}
+		if _, err := os.Stat(datasetPath); err == nil || datasetPath == StdinDataset {
+			log.Warningf("action: bet_source | result: success | client_id: %v | source: %v | ignored: %v", agency, BetSourceDataset, strings.Join(SingleBetVars, ","))
+			return nil, nil
+		}
+	case BetSourceEnv:
+	default:
+		return nil, fmt.Errorf("unknown bet source %q, expected one of %v", source, strings.Join(BetSources, ", "))
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("the single bet needs %v", strings.Join(missing, ", "))
+	}
+	return &lottery.Bet{
+		Agency:    agency,
+		FirstName: values[0],
+		LastName:  values[1],
+		Document:  values[2],
+		Birthdate: values[3],
+		Number:    values[4],
+	}, nil
+}
+
+// sendSingleBet Sends the single bet of the agency and waits for the server
+// to confirm it was stored
+func (c *Client) sendSingleBet(bet lottery.Bet) error {
+	c.pipeline.wait()
+	_, rejected, err := c.SubmitBet(bet)
+	if err == nil && rejected {
+		err = ErrBetRejected
+	}
+	if err != nil {
+		log.Errorf("action: apuesta_enviada | result: fail | dni: %v | numero: %v | error: %v", bet.Document, bet.Number, err)
+		return err
+	}
+	log.Infof("action: apuesta_enviada | result: success | dni: %v | numero: %v", bet.Document, bet.Number)
+	return nil
+}
@@ -0,0 +1,108 @@
+package common
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/lottery"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/metrics"
+)
+
+var singleBetValues = []string{"Santiago Lionel", "Lorca", "30904465", "1999-03-17", "7574"}
+
+func TestSingleBetPrecedence(t *testing.T) {
+	quietLogs(t)
+	dataset := writeDataset(t, testDataset)
+	missing := filepath.Join(t.TempDir(), "missing.csv")
+	partial := []string{"Santiago Lionel", "Lorca", "", "1999-03-17", ""}
+
+	tests := []struct {
+		name    string
+		source  string
+		dataset string
+		values  []string
+		single  bool
+		err     string
+	}{
+		{name: "auto without vars", source: BetSourceAuto, dataset: missing, single: false},
+		{name: "auto without dataset", source: BetSourceAuto, dataset: missing, values: singleBetValues, single: true},
+		{name: "auto prefers the dataset", source: BetSourceAuto, dataset: dataset, values: singleBetValues, single: false},
+		{name: "auto with missing vars", source: BetSourceAuto, dataset: missing, values: partial, err: "DOCUMENTO, NUMERO"},
+		{name: "dataset ignores the vars", source: BetSourceDataset, dataset: missing, values: singleBetValues, single: false},
+		{name: "env ignores the dataset", source: BetSourceEnv, dataset: dataset, values: singleBetValues, single: true},
+		{name: "env without vars", source: BetSourceEnv, dataset: dataset, err: "NOMBRE, APELLIDO, DOCUMENTO, NACIMIENTO, NUMERO"},
+		{name: "unknown source", source: "stdin", dataset: dataset, values: singleBetValues, err: "unknown bet source"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bet, err := SingleBet(test.source, test.dataset, "1", test.values)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected an error about %v, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (bet != nil) != test.single {
+				t.Fatalf("expected single bet %v, got %+v", test.single, bet)
+			}
+			expected := lottery.Bet{Agency: "1", FirstName: "Santiago Lionel", LastName: "Lorca", Document: "30904465", Birthdate: "1999-03-17", Number: "7574"}
+			if bet != nil && *bet != expected {
+				t.Fatalf("expected %+v, got %+v", expected, *bet)
+			}
+		})
+	}
+}
+
+func TestSingleBetModeSendsOnlyTheBet(t *testing.T) {
+	server := startFakeServer(t)
+	server.SetWinners("1", "30904465")
+	logs := captureLogs(t)
+
+	bet, err := SingleBet(BetSourceEnv, "", "1", singleBetValues)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := betsConfig(server.Addr(), filepath.Join(t.TempDir(), "missing.csv"))
+	config.Bets.Single = bet
+	if err := NewClient(config, NewClientMetrics(metrics.NewRegistry())).StartClientLoop(); err != nil {
+		t.Fatal(err)
+	}
+
+	if documents := storedDocuments(server); documents != "30904465" {
+		t.Fatalf("expected only the single bet stored, got %v", documents)
+	}
+	if !server.Finished("1") {
+		t.Fatal("expected the delivery to be finished")
+	}
+	if line := "action: apuesta_enviada | result: success | dni: 30904465 | numero: 7574"; !strings.Contains(logs.String(), line) {
+		t.Fatalf("expected %q in the logs, got:\n%v", line, logs)
+	}
+}
+
+func TestSingleBetModeFailsOnInvalidOrRejectedBets(t *testing.T) {
+	quietLogs(t)
+	server := startFakeServer(t)
+	server.RejectDocuments("30904465")
+
+	config := betsConfig(server.Addr(), "")
+	config.Bets.Single, _ = SingleBet(BetSourceEnv, "", "1", singleBetValues)
+	if err := NewClient(config, NewClientMetrics(metrics.NewRegistry())).StartClientLoop(); !errors.Is(err, ErrBetRejected) {
+		t.Fatalf("expected the bet to be rejected, got %v", err)
+	}
+
+	invalid := *config.Bets.Single
+	invalid.Birthdate = "17/03/1999"
+	config.Bets.Single = &invalid
+	var betErr *lottery.InvalidBetError
+	if err := NewClient(config, NewClientMetrics(metrics.NewRegistry())).StartClientLoop(); !errors.As(err, &betErr) {
+		t.Fatalf("expected the invalid bet to fail, got %v", err)
+	}
+	if server.Finished("1") {
+		t.Fatal("expected the delivery not to be finished after a failed bet")
+	}
+}
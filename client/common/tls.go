@@ -0,0 +1,189 @@
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/pki"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/transport"
+)
+
+// TLSConfig TLS settings of the connection to the server
+type TLSConfig struct {
+	Enabled bool
+	// CAFile PEM file with the authorities trusted to sign the certificate
+	// of the server. Empty trusts the authorities of the system
+	CAFile string
+	// ServerName Name the certificate of the server must be valid for.
+	// Empty uses the host of the server address
+	ServerName string
+	// MinVersion Oldest TLS version accepted: 1.2 or 1.3. Empty means 1.2
+	MinVersion string
+	// CertFile and KeyFile PEM files with the client certificate of the
+	// agency and its key, presented to servers that require mutual TLS.
+	// Empty connects without a client certificate
+	CertFile string
+	KeyFile  string
+}
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion Parses a TLS version written as 1.2 or 1.3
+func ParseTLSVersion(version string) (uint16, error) {
+	if version == "" {
+		return tls.VersionTLS12, nil
+	}
+	if v, ok := tlsVersions[version]; ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS version %q, expected 1.2 or 1.3", version)
+}
+
+// ClientTLS Builds the crypto/tls configuration used by the agency to reach
+// the server at the given address. A client certificate issued to another
+// agency is an IdentityError
+func (c TLSConfig) ClientTLS(address string, agency string) (*tls.Config, error) {
+	minVersion, err := ParseTLSVersion(c.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{MinVersion: minVersion, ServerName: c.ServerName}
+	if config.ServerName == "" {
+		host, err := transport.Host(address)
+		if err != nil {
+			return nil, err
+		}
+		config.ServerName = host
+	}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %v", c.CAFile)
+		}
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		certificate, err := loadAgencyCertificate(c.CertFile, c.KeyFile, agency)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
+
+// loadAgencyCertificate Loads a client certificate and checks that it was
+// issued to the agency
+func loadAgencyCertificate(certFile string, keyFile string, agency string) (tls.Certificate, error) {
+	if certFile == "" || keyFile == "" {
+		return tls.Certificate{}, errors.New("a client certificate needs both a certificate and a key file")
+	}
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	certificateAgency, err := pki.AgencyOf(leaf)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if certificateAgency != agency {
+		return tls.Certificate{}, &IdentityError{Agency: agency, Certificate: certificateAgency}
+	}
+	certificate.Leaf = leaf
+	return certificate, nil
+}
+
+// IdentityError The client certificate was issued to another agency
+type IdentityError struct {
+	// Agency The agency the client is configured as
+	Agency string
+	// Certificate The agency named by the certificate
+	Certificate string
+}
+
+func (e *IdentityError) Error() string {
+	return fmt.Sprintf("the client certificate belongs to agency %v, not to agency %v", e.Certificate, e.Agency)
+}
+
+// TLSError A connection that could not establish TLS with the server. With
+// TLS 1.3 the server verifies the client certificate after the client
+// finished its side of the handshake, so an alert on the first read of the
+// connection, like a rejected client certificate, is a TLSError too
+type TLSError struct {
+	Err error
+}
+
+func (e *TLSError) Error() string {
+	return fmt.Sprintf("tls handshake: %v", e.Err)
+}
+
+func (e *TLSError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent Reports whether retrying cannot fix the handshake: the
+// certificate of the server could not be verified or the server refused the
+// client with a TLS alert. Handshakes cut short by the network, like while
+// the server is still starting, are not permanent
+func (e *TLSError) Permanent() bool {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	var systemRoots x509.SystemRootsError
+	if errors.As(e.Err, &unknownAuthority) || errors.As(e.Err, &hostname) ||
+		errors.As(e.Err, &invalid) || errors.As(e.Err, &systemRoots) {
+		return true
+	}
+	// Alerts sent by the server arrive as a remote error
+	var opErr *net.OpError
+	return errors.As(e.Err, &opErr) && opErr.Op == "remote error"
+}
+
+// secure Performs the TLS handshake over an open connection, within the
+// timeout if there is one
+func secure(conn net.Conn, config *tls.Config, timeout time.Duration) (net.Conn, error) {
+	tlsConn := tls.Client(conn, config)
+	if timeout > 0 {
+		tlsConn.SetDeadline(time.Now().Add(timeout))
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, &TLSError{Err: err}
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return &securedConn{Conn: tlsConn}, nil
+}
+
+// securedConn TLS connection whose first read may still fail the handshake
+type securedConn struct {
+	*tls.Conn
+	// established Whether a read already received data from the server
+	established bool
+}
+
+func (c *securedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if !c.established {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "remote error" {
+			return n, &TLSError{Err: err}
+		}
+		c.established = n > 0
+	}
+	return n, err
+}
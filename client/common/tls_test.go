@@ -0,0 +1,312 @@
+package common
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	stdlog "log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/op/go-logging"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/fakeserver"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/metrics"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/pki"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/pki/pkitest"
+)
+
+// captureLogs Collects the log lines written during the test
+func captureLogs(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	logging.SetBackend(logging.NewLogBackend(&buf, "", 0))
+	t.Cleanup(func() { logging.SetBackend(logging.NewLogBackend(os.Stderr, "", stdlog.LstdFlags)) })
+	return &buf
+}
+
+// startTLSServer Starts a fake server behind a TLS listener
+func startTLSServer(t *testing.T, listener *pkitest.Listener) *fakeserver.Server {
+	server := fakeserver.StartListener(listener)
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+func TestBetsAreSentOverTLS(t *testing.T) {
+	listener := pkitest.Listen(t)
+	server := startTLSServer(t, listener)
+	config := betsConfig(server.Addr(), writeDataset(t, testDataset))
+
+	tlsConfig, err := TLSConfig{Enabled: true, CAFile: listener.CAFile}.ClientTLS(server.Addr(), "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.ServerName != "127.0.0.1" || tlsConfig.MinVersion != tls.VersionTLS12 {
+		t.Fatalf("unexpected defaults %v %v", tlsConfig.ServerName, tlsConfig.MinVersion)
+	}
+	client := NewClient(config, NewClientMetrics(metrics.NewRegistry()), WithTLS(tlsConfig))
+	if err := client.StartClientLoop(); err != nil {
+		t.Fatal(err)
+	}
+	if len(hellos(t, server)) == 0 {
+		t.Fatal("expected the handshake to go through TLS")
+	}
+	if err := ProbeLotteryServer(server.Addr(), tlsConfig, nil, "1", time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTLSFailuresAreReportedAndNotRetried(t *testing.T) {
+	other, err := pki.NewAuthority("Unknown CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		options []func(*tls.Config)
+		config  func(listener *pkitest.Listener) TLSConfig
+	}{
+		{
+			name: "unknown authority",
+			config: func(listener *pkitest.Listener) TLSConfig {
+				caFile := t.TempDir() + "/other.pem"
+				if err := os.WriteFile(caFile, other.CertPEM(), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return TLSConfig{CAFile: caFile}
+			},
+		},
+		{
+			name: "wrong server name",
+			config: func(listener *pkitest.Listener) TLSConfig {
+				return TLSConfig{CAFile: listener.CAFile, ServerName: "server"}
+			},
+		},
+		{
+			name:    "version below the minimum",
+			options: []func(*tls.Config){func(c *tls.Config) { c.MaxVersion = tls.VersionTLS12 }},
+			config: func(listener *pkitest.Listener) TLSConfig {
+				return TLSConfig{CAFile: listener.CAFile, MinVersion: "1.3"}
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			listener := pkitest.Listen(t, test.options...)
+			server := startTLSServer(t, listener)
+			tlsConfig, err := test.config(listener).ClientTLS(server.Addr(), "1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			logs := captureLogs(t)
+
+			config := betsConfig(server.Addr(), writeDataset(t, testDataset))
+			err = NewClient(config, NewClientMetrics(metrics.NewRegistry()), WithTLS(tlsConfig)).StartClientLoop()
+			var tlsErr *TLSError
+			if !errors.As(err, &tlsErr) {
+				t.Fatalf("expected a TLS error, got %v", err)
+			}
+			if !strings.Contains(logs.String(), "action: connect | result: fail | client_id: 1 | error: tls handshake: ") {
+				t.Fatalf("expected the failure to be logged, got %q", logs.String())
+			}
+			if connections := server.Connections(); connections != 1 {
+				t.Fatalf("expected a single connection, got %v", connections)
+			}
+			if received := server.Received(); len(received) != 0 {
+				t.Fatalf("expected no frames to reach the server, got %v", len(received))
+			}
+			if err := ProbeLotteryServer(server.Addr(), tlsConfig, nil, "1", time.Second); !errors.As(err, &tlsErr) {
+				t.Fatalf("expected the probe to fail the TLS handshake, got %v", err)
+			}
+		})
+	}
+}
+
+// TestTLSHandshakesCutByTheNetworkAreRetried Hangs up the first connection
+// before the handshake, like a server that is still starting
+func TestTLSHandshakesCutByTheNetworkAreRetried(t *testing.T) {
+	quietLogs(t)
+	listener := pkitest.Listen(t)
+	server := startFakeServer(t)
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	go func() {
+		for accepted := 0; ; accepted++ {
+			conn, err := raw.Accept()
+			if err != nil {
+				return
+			}
+			if accepted == 0 {
+				conn.Close()
+				continue
+			}
+			server.ServeConn(tls.Server(conn, listener.Config))
+		}
+	}()
+
+	tlsConfig, err := TLSConfig{Enabled: true, CAFile: listener.CAFile}.ClientTLS(raw.Addr().String(), "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := betsConfig(raw.Addr().String(), writeDataset(t, testDataset))
+	if err := NewClient(config, NewClientMetrics(metrics.NewRegistry()), WithTLS(tlsConfig)).StartClientLoop(); err != nil {
+		t.Fatalf("expected the handshake to be retried, got %v", err)
+	}
+	if documents := storedDocuments(server); documents != testDocuments {
+		t.Fatalf("expected every bet stored, got %v", documents)
+	}
+}
+
+// writeAgencyCertificate Issues the certificate of an agency and returns the
+// files it was written to
+func writeAgencyCertificate(t *testing.T, authority *pki.Authority, agency string) (string, string) {
+	t.Helper()
+	issued, err := authority.IssueAgency(agency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "agency.pem"), filepath.Join(dir, "agency-key.pem")
+	if err := issued.WriteFiles(certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestMutualTLSBindsTheAgencyToItsCertificate(t *testing.T) {
+	authority, err := pki.NewAuthority("test CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := pkitest.ListenWith(t, authority, pkitest.RequireClientCerts(authority))
+	server := startTLSServer(t, listener)
+	dataset := writeDataset(t, testDataset)
+	quietLogs(t)
+
+	certFile, keyFile := writeAgencyCertificate(t, authority, "1")
+	tlsConfig, err := TLSConfig{CAFile: listener.CAFile, CertFile: certFile, KeyFile: keyFile}.ClientTLS(server.Addr(), "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewClient(betsConfig(server.Addr(), dataset), NewClientMetrics(metrics.NewRegistry()), WithTLS(tlsConfig)).StartClientLoop(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The client refuses a certificate of another agency
+	otherCert, otherKey := writeAgencyCertificate(t, authority, "2")
+	_, err = TLSConfig{CAFile: listener.CAFile, CertFile: otherCert, KeyFile: otherKey}.ClientTLS(server.Addr(), "1")
+	var identityErr *IdentityError
+	if !errors.As(err, &identityErr) || identityErr.Agency != "1" || identityErr.Certificate != "2" {
+		t.Fatalf("expected an identity error, got %v", err)
+	}
+
+	// And the server does not serve a client that skips that check
+	pair, err := tls.LoadX509KeyPair(otherCert, otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	impostor := &tls.Config{RootCAs: authority.Pool(), ServerName: "127.0.0.1", Certificates: []tls.Certificate{pair}}
+	config := betsConfig(server.Addr(), dataset)
+	config.RetryAttempts = 1
+	if err := NewClient(config, NewClientMetrics(metrics.NewRegistry()), WithTLS(impostor)).StartClientLoop(); err == nil {
+		t.Fatal("expected the server to refuse an agency claimed with another certificate")
+	}
+
+	// Nor a client without a certificate
+	anonymous := &tls.Config{RootCAs: authority.Pool(), ServerName: "127.0.0.1"}
+	if err := NewClient(config, NewClientMetrics(metrics.NewRegistry()), WithTLS(anonymous)).StartClientLoop(); err == nil {
+		t.Fatal("expected the server to refuse a client without a certificate")
+	}
+}
+
+// TestRejectedClientCertificatesAreNotRetried Connects with TLS 1.3 to a
+// server that requires client certificates. The server verifies the client
+// certificate after the client finished its handshake, so its alert arrives
+// on the first read
+func TestRejectedClientCertificatesAreNotRetried(t *testing.T) {
+	authority, err := pki.NewAuthority("test CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := pki.NewAuthority("Unknown CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tls13 := func(c *tls.Config) { c.MinVersion = tls.VersionTLS13 }
+	unknownCert, unknownKey := writeAgencyCertificate(t, other, "1")
+	unknown, err := tls.LoadX509KeyPair(unknownCert, unknownKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		certificate *tls.Certificate
+	}{
+		{name: "without a certificate"},
+		{name: "certificate of an unknown authority", certificate: &unknown},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			listener := pkitest.ListenWith(t, authority, tls13, pkitest.RequireClientCerts(authority))
+			server := startTLSServer(t, listener)
+			tlsConfig := &tls.Config{RootCAs: authority.Pool(), ServerName: "127.0.0.1"}
+			if test.certificate != nil {
+				// Presented even if the server asks for another authority
+				tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+					return test.certificate, nil
+				}
+			}
+			quietLogs(t)
+
+			config := betsConfig(server.Addr(), writeDataset(t, testDataset))
+			err = NewClient(config, NewClientMetrics(metrics.NewRegistry()), WithTLS(tlsConfig)).StartClientLoop()
+			var tlsErr *TLSError
+			if !errors.As(err, &tlsErr) || !tlsErr.Permanent() {
+				t.Fatalf("expected a permanent TLS error, got %v", err)
+			}
+			if connections := server.Connections(); connections != 1 {
+				t.Fatalf("expected a single connection, got %v", connections)
+			}
+		})
+	}
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	empty := t.TempDir() + "/empty.pem"
+	if err := os.WriteFile(empty, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		config  TLSConfig
+		address string
+	}{
+		{name: "unsupported version", config: TLSConfig{MinVersion: "1.1"}, address: "server:12345"},
+		{name: "missing CA file", config: TLSConfig{CAFile: t.TempDir() + "/missing.pem"}, address: "server:12345"},
+		{name: "CA file without certificates", config: TLSConfig{CAFile: empty}, address: "server:12345"},
+		{name: "address without port", config: TLSConfig{}, address: "server"},
+		{name: "certificate without key", config: TLSConfig{CertFile: empty}, address: "server:12345"},
+		{name: "invalid certificate", config: TLSConfig{CertFile: empty, KeyFile: empty}, address: "server:12345"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := test.config.ClientTLS(test.address, "1"); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+
+	config, err := TLSConfig{ServerName: "lottery", MinVersion: "1.3"}.ClientTLS("server:12345", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ServerName != "lottery" || config.MinVersion != tls.VersionTLS13 || config.RootCAs != nil {
+		t.Fatalf("unexpected config %+v", config)
+	}
+}
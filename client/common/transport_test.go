@@ -0,0 +1,87 @@
+package common
+
+import (
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/fakeserver"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/metrics"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/transport"
+)
+
+// TestBetsModeOnEveryTransport Delivers the dataset over every transport,
+// hanging up on the first batch so the retry reconnects through it too
+func TestBetsModeOnEveryTransport(t *testing.T) {
+	addresses := []string{
+		"tcp://127.0.0.1:0",
+		"unix://" + filepath.Join(t.TempDir(), "server.sock"),
+		"mem://lottery",
+	}
+	for _, address := range addresses {
+		t.Run(address, func(t *testing.T) {
+			listener, err := transport.Listen(address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			server := fakeserver.StartListener(listener)
+			defer server.Close()
+			server.Script(fakeserver.Action{Kind: fakeserver.Hangup})
+			quietLogs(t)
+
+			config := betsConfig(server.Addr(), writeDataset(t, testDataset))
+			config.Capabilities = SupportedCapabilities
+			clientMetrics := NewClientMetrics(metrics.NewRegistry())
+			if err := NewClient(config, clientMetrics).StartClientLoop(); err != nil {
+				t.Fatal(err)
+			}
+
+			if documents := storedDocuments(server); documents != testDocuments {
+				t.Errorf("expected every bet stored once and in order, got %v", documents)
+			}
+			if frames := framesOfType(server, protocol.TypeBets); frames != 4 {
+				t.Errorf("expected 4 batch frames, got %v", frames)
+			}
+			if acked := clientMetrics.BatchesAcked.Value(); acked != 3 {
+				t.Errorf("expected 3 batches acked, got %v", acked)
+			}
+			if clientMetrics.BytesSent.Value() == 0 || clientMetrics.BytesReceived.Value() == 0 {
+				t.Error("expected the bytes of the connection to be accounted")
+			}
+			if err := ProbeLotteryServer(server.Addr(), nil, nil, "1", config.Timeout); err != nil {
+				t.Errorf("expected the probe to reach the server, got %v", err)
+			}
+		})
+	}
+}
+
+// TestNetDialerOnAddressesWithAScheme Gives the client a *net.Dialer, which
+// only understands addresses without a scheme and on their own network
+func TestNetDialerOnAddressesWithAScheme(t *testing.T) {
+	for _, address := range []string{"tcp://127.0.0.1:0", "unix://" + filepath.Join(t.TempDir(), "server.sock")} {
+		t.Run(address, func(t *testing.T) {
+			listener, err := transport.Listen(address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			server := fakeserver.StartListener(listener)
+			defer server.Close()
+			quietLogs(t)
+
+			// TCP servers give their address without the scheme
+			serverAddress := server.Addr()
+			if !strings.Contains(serverAddress, "://") {
+				serverAddress = "tcp://" + serverAddress
+			}
+			config := betsConfig(serverAddress, writeDataset(t, testDataset))
+			if err := NewClient(config, NewClientMetrics(metrics.NewRegistry()), WithDialer(&net.Dialer{})).StartClientLoop(); err != nil {
+				t.Fatal(err)
+			}
+			if documents := storedDocuments(server); documents != testDocuments {
+				t.Errorf("expected every bet stored once and in order, got %v", documents)
+			}
+		})
+	}
+}
@@ -0,0 +1,521 @@
+// Package fakeserver provides an in-process lottery server for tests. It
+// listens on a loopback port, speaks the client protocol and can be scripted
+// to acknowledge, reject, delay, cut or corrupt its responses, recording every
+// frame the clients send.
+//
+// Hello frames are answered with the version and capabilities of the server
+// without consuming the script, so scripted actions always apply to the
+// requests that follow the handshake. Connections that skip the handshake
+// are served with no capabilities enabled. Behind a listener that requires
+// client certificates, a hello for an agency other than the one of the
+// certificate closes the connection without a reply, and so does a frame
+// rejected by the verifier given to RequireAuth, or a frame that does not
+// match its checksum.
+package fakeserver
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/auth"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/lottery"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/pki"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/transport"
+)
+
+// ActionKind What the server does with a request
+type ActionKind int
+
+const (
+	// Reply Answers the request as a lottery server would
+	Reply ActionKind = iota
+	// RejectBets Rejects the bets of a batch at the given indexes
+	RejectBets
+	// CloseMidFrame Writes only part of the response and closes the connection
+	CloseMidFrame
+	// Garbage Writes bytes that are not a valid response and closes the
+	// connection
+	Garbage
+	// Hangup Closes the connection without answering
+	Hangup
+)
+
+// Action Scripted reaction to a single request
+type Action struct {
+	Kind ActionKind
+	// Delay Time to wait before reacting
+	Delay time.Duration
+	// Indexes Bets rejected by a RejectBets action
+	Indexes []int
+	// Bytes Written by a Garbage action
+	Bytes []byte
+}
+
+// After Returns a copy of the action that waits d before reacting
+func (a Action) After(d time.Duration) Action {
+	a.Delay = d
+	return a
+}
+
+// Received A frame sent by a client
+type Received struct {
+	// Conn Number of the connection the frame arrived on, starting at 1
+	Conn int
+	// Frame The frame, decompressed if it arrived compressed
+	Frame protocol.Frame
+	// Compressed Whether the frame arrived compressed
+	Compressed bool
+	// Size Bytes the frame took on the wire
+	Size int
+}
+
+// Server Scriptable lottery server
+type Server struct {
+	listener net.Listener
+	wg       sync.WaitGroup
+	done     chan struct{}
+	drawn    chan struct{}
+	drawOnce sync.Once
+
+	mu             sync.Mutex
+	conns          map[net.Conn]bool
+	connCount      int
+	script         []Action
+	received       []Received
+	rejected       map[string]bool
+	bets           map[string][]lottery.Bet
+	batches        map[string]map[uint32]bool
+	finished       map[string]bool
+	winners        map[string][]string
+	pendingQueries int
+	version        uint16
+	capabilities   []string
+	verifier       *auth.Verifier
+	authErrors     []error
+	corruptFrames  int
+}
+
+// Start Listens on a random loopback port and serves clients in background
+func Start() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	return StartListener(listener), nil
+}
+
+// StartListener Serves in background the clients accepted by the listener,
+// like a TLS one or one of package transport. The server takes ownership of the listener
+func StartListener(listener net.Listener) *Server {
+	s := &Server{
+		listener:     listener,
+		done:         make(chan struct{}),
+		drawn:        make(chan struct{}),
+		conns:        make(map[net.Conn]bool),
+		rejected:     make(map[string]bool),
+		bets:         make(map[string][]lottery.Bet),
+		batches:      make(map[string]map[uint32]bool),
+		finished:     make(map[string]bool),
+		winners:      make(map[string][]string),
+		version:      protocol.Version,
+		capabilities: []string{protocol.CapabilityPush, protocol.CapabilityLargeFrames, protocol.CapabilityCompression, protocol.CapabilityChecksums},
+	}
+	s.wg.Add(1)
+	go s.accept()
+	return s
+}
+
+// Addr Address clients must connect to, with the scheme of the transport
+// of the listener unless it is TCP
+func (s *Server) Addr() string {
+	return transport.URL(s.listener.Addr())
+}
+
+// Close Stops accepting clients, closes every open connection and waits for
+// the server goroutines to finish
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.mu.Lock()
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// Script Queues actions consumed one per received request, in order. Once
+// the queue is empty every request is answered normally
+func (s *Server) Script(actions ...Action) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.script = append(s.script, actions...)
+}
+
+// RejectDocuments Rejects every batch that holds a bet with one of the given
+// documents, reporting the position of those bets
+func (s *Server) RejectDocuments(documents ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, document := range documents {
+		s.rejected[document] = true
+	}
+}
+
+// SetWinners Documents answered to the winners query of the agency
+func (s *Server) SetWinners(agency string, documents ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.winners[agency] = documents
+}
+
+// SetPendingQueries Amount of winners queries answered with WinnersPending
+// before the lottery is considered done
+func (s *Server) SetPendingQueries(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pendingQueries = n
+}
+
+// SetVersion Protocol version answered to the hello of the clients
+func (s *Server) SetVersion(version uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version = version
+}
+
+// SetCapabilities Capabilities the server enables when a client offers them
+func (s *Server) SetCapabilities(capabilities ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.capabilities = capabilities
+}
+
+// RequireAuth Makes the server open every frame with the verifier, hellos
+// included, closing the connection on the first frame it rejects
+func (s *Server) RequireAuth(verifier *auth.Verifier) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.verifier = verifier
+}
+
+// AuthErrors Why every frame rejected by the verifier was rejected
+func (s *Server) AuthErrors() []error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]error(nil), s.authErrors...)
+}
+
+// Draw Makes the lottery take place: pending queries are over and the
+// winners are pushed to the clients waiting for them
+func (s *Server) Draw() {
+	s.mu.Lock()
+	s.pendingQueries = 0
+	s.mu.Unlock()
+	s.drawOnce.Do(func() { close(s.drawn) })
+}
+
+// Received Every frame received so far, in arrival order
+func (s *Server) Received() []Received {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Received(nil), s.received...)
+}
+
+// Connections Amount of connections accepted so far
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connCount
+}
+
+// Bets Bets stored for the agency. Batches received more than once are
+// stored only the first time
+func (s *Server) Bets(agency string) []lottery.Bet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]lottery.Bet(nil), s.bets[agency]...)
+}
+
+// CorruptFrames Amount of frames received that did not match their checksum.
+// The server closes the connection on each of them
+func (s *Server) CorruptFrames() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.corruptFrames
+}
+
+// Finished Returns true if the agency notified the end of its delivery
+func (s *Server) Finished(agency string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.finished[agency]
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.ServeConn(conn)
+	}
+}
+
+// ServeConn Serves in background a connection that did not arrive through
+// the listener, like one end of a net.Pipe. It must not be called after Close
+func (s *Server) ServeConn(conn net.Conn) {
+	s.mu.Lock()
+	s.conns[conn] = true
+	s.connCount++
+	id := s.connCount
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go s.serve(conn, id)
+}
+
+func (s *Server) serve(conn net.Conn, id int) {
+	defer s.wg.Done()
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+
+	var session []string
+	var framing protocol.Framing
+	// agency Agency claimed by the hello, whose secret opens the frames
+	var agency string
+	for {
+		frame, err := framing.ReadFrame(conn)
+		if err != nil {
+			if errors.Is(err, protocol.ErrCorruptFrame) {
+				s.mu.Lock()
+				s.corruptFrames++
+				s.mu.Unlock()
+			}
+			return
+		}
+		size := framing.WireSize(len(frame.Payload))
+		if frame, err = s.open(&agency, frame); err != nil {
+			return
+		}
+		compressed := frame.Compressed()
+		if frame, err = protocol.Decompress(frame, framing.MaxSize); err != nil {
+			return
+		}
+		if frame.Type == protocol.TypeHello {
+			var reply protocol.HelloReply
+			reply, session = s.greet(Received{Conn: id, Frame: frame, Compressed: compressed, Size: size})
+			if !authorized(conn, frame) {
+				return
+			}
+			if err := protocol.WriteMessage(conn, reply); err != nil {
+				return
+			}
+			framing.Continuation = protocol.Has(session, protocol.CapabilityLargeFrames)
+			framing.Checksums = protocol.Has(session, protocol.CapabilityChecksums)
+			continue
+		}
+		action := s.record(Received{Conn: id, Frame: frame, Compressed: compressed, Size: size})
+
+		time.Sleep(action.Delay)
+		switch action.Kind {
+		case Hangup:
+			return
+		case Garbage:
+			conn.Write(action.Bytes)
+			return
+		}
+
+		response := s.respond(frame, action)
+		if action.Kind == CloseMidFrame {
+			encoded, err := protocol.Encode(response)
+			if err != nil {
+				return
+			}
+			var buf bytes.Buffer
+			framing.WriteFrame(&buf, encoded)
+			conn.Write(buf.Bytes()[:buf.Len()/2])
+			return
+		}
+		if err := writeMessage(conn, framing, session, response); err != nil {
+			return
+		}
+		if _, pending := response.(protocol.WinnersPending); pending && protocol.Has(session, protocol.CapabilityPush) {
+			if !s.push(conn, framing, session, frame) {
+				return
+			}
+		}
+	}
+}
+
+// greet Records a hello and builds its reply along with the capabilities
+// enabled for the connection. Nothing is enabled for another version
+func (s *Server) greet(hello Received) (protocol.HelloReply, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.received = append(s.received, hello)
+	reply := protocol.HelloReply{Version: s.version}
+	msg, err := protocol.Decode(hello.Frame)
+	if err != nil {
+		return reply, nil
+	}
+	if hello := msg.(protocol.Hello); hello.Version == s.version {
+		reply.Capabilities = protocol.Negotiate(hello.Capabilities, s.capabilities)
+	}
+	return reply, reply.Capabilities
+}
+
+// open Removes the trailer of the frame once the verifier accepts it, if the
+// server requires authentication. A hello sets the agency of the connection
+func (s *Server) open(agency *string, frame protocol.Frame) (protocol.Frame, error) {
+	s.mu.Lock()
+	verifier := s.verifier
+	s.mu.Unlock()
+	if verifier == nil {
+		return frame, nil
+	}
+	if frame.Type == protocol.TypeHello {
+		if plain, _, err := auth.Split(frame); err == nil {
+			if msg, err := protocol.Decode(plain); err == nil {
+				*agency = msg.(protocol.Hello).Agency
+			}
+		}
+	}
+	opened, err := verifier.Open(*agency, frame)
+	if err != nil {
+		s.mu.Lock()
+		s.authErrors = append(s.authErrors, err)
+		s.mu.Unlock()
+	}
+	return opened, err
+}
+
+// authorized Returns false if the hello claims an agency other than the one
+// of the client certificate of the connection. Connections without a client
+// certificate can claim any agency
+func authorized(conn net.Conn, hello protocol.Frame) bool {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok || len(tlsConn.ConnectionState().PeerCertificates) == 0 {
+		return true
+	}
+	agency, err := pki.AgencyOf(tlsConn.ConnectionState().PeerCertificates[0])
+	if err != nil {
+		return false
+	}
+	msg, err := protocol.Decode(hello)
+	return err == nil && msg.(protocol.Hello).Agency == agency
+}
+
+// push Waits for the lottery and sends the winners of the query on the
+// connection. Returns false if the server closed or the write failed
+func (s *Server) push(conn net.Conn, framing protocol.Framing, session []string, query protocol.Frame) bool {
+	select {
+	case <-s.drawn:
+	case <-s.done:
+		return false
+	}
+	msg, err := protocol.Decode(query)
+	if err != nil {
+		return false
+	}
+	s.mu.Lock()
+	winners := protocol.Winners{Documents: s.winners[msg.(protocol.WinnersQuery).Agency]}
+	s.mu.Unlock()
+	return writeMessage(conn, framing, session, winners) == nil
+}
+
+func writeMessage(conn net.Conn, framing protocol.Framing, session []string, msg protocol.Message) error {
+	frame, err := protocol.Encode(msg)
+	if err != nil {
+		return err
+	}
+	if protocol.Has(session, protocol.CapabilityCompression) {
+		frame = protocol.Compress(frame, 0)
+	}
+	return framing.WriteFrame(conn, frame)
+}
+
+// record Stores the frame and pops the action to apply to it
+func (s *Server) record(received Received) Action {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.received = append(s.received, received)
+	if len(s.script) == 0 {
+		return Action{Kind: Reply}
+	}
+	action := s.script[0]
+	s.script = s.script[1:]
+	return action
+}
+
+// respond Builds the response a lottery server gives to the request
+func (s *Server) respond(frame protocol.Frame, action Action) protocol.Message {
+	msg, err := protocol.Decode(frame)
+	if err != nil {
+		// There is no error message in the protocol, a reject of batch 0
+		// is the closest answer to a malformed request
+		return protocol.Reject{}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch m := msg.(type) {
+	case protocol.Bets:
+		indexes := action.Indexes
+		if action.Kind != RejectBets {
+			indexes = nil
+			for i, bet := range m.Bets {
+				if s.rejected[bet.Document] {
+					indexes = append(indexes, i)
+				}
+			}
+		}
+		if len(indexes) > 0 {
+			return protocol.Reject{BatchID: m.BatchID, Indexes: indexes}
+		}
+		s.store(m)
+		return protocol.Ack{BatchID: m.BatchID, Count: len(m.Bets)}
+	case protocol.DeliveryEnded:
+		s.finished[m.Agency] = true
+		return protocol.Ack{}
+	case protocol.WinnersQuery:
+		if s.pendingQueries > 0 {
+			s.pendingQueries--
+			return protocol.WinnersPending{}
+		}
+		return protocol.Winners{Documents: s.winners[m.Agency]}
+	default:
+		return protocol.Reject{}
+	}
+}
+
+// store Keeps the bets of a batch unless the batch was already received
+func (s *Server) store(batch protocol.Bets) {
+	if len(batch.Bets) == 0 {
+		return
+	}
+	agency := batch.Bets[0].Agency
+	if s.batches[agency] == nil {
+		s.batches[agency] = make(map[uint32]bool)
+	}
+	if s.batches[agency][batch.BatchID] {
+		return
+	}
+	s.batches[agency][batch.BatchID] = true
+	s.bets[agency] = append(s.bets[agency], batch.Bets...)
+}
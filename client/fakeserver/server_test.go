@@ -0,0 +1,213 @@
+package fakeserver
+
+import (
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/lottery"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+)
+
+var testBets = []lottery.Bet{
+	{Agency: "1", FirstName: "Valentina", LastName: "Vera", Document: "30170921", Birthdate: "1982-05-22", Number: "6053"},
+	{Agency: "1", FirstName: "Lionel", LastName: "Lorca", Document: "30904465", Birthdate: "1999-03-17", Number: "7574"},
+}
+
+func startServer(t *testing.T) *Server {
+	t.Helper()
+	server, err := Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+// dial Connects to the server and sends the hello of agency 1 offering the
+// capabilities. Returns the connection, the reply and the framing of the
+// session it opens
+func dial(t *testing.T, server *Server, capabilities ...string) (net.Conn, protocol.HelloReply, protocol.Framing) {
+	t.Helper()
+	conn, err := net.Dial("tcp", server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	response := exchange(t, conn, protocol.StandardFraming, protocol.Hello{Version: protocol.Version, Agency: "1", Capabilities: capabilities})
+	reply, ok := response.(protocol.HelloReply)
+	if !ok {
+		t.Fatalf("expected a hello reply, got %+v", response)
+	}
+	session := protocol.Negotiate(capabilities, reply.Capabilities)
+	framing := protocol.Framing{
+		Continuation: protocol.Has(session, protocol.CapabilityLargeFrames),
+		Checksums:    protocol.Has(session, protocol.CapabilityChecksums),
+	}
+	return conn, reply, framing
+}
+
+// exchange Sends the message with the framing and reads the response
+func exchange(t *testing.T, conn net.Conn, framing protocol.Framing, msg protocol.Message) protocol.Message {
+	t.Helper()
+	frame, err := protocol.Encode(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := framing.WriteFrame(conn, frame); err != nil {
+		t.Fatal(err)
+	}
+	return read(t, conn, framing)
+}
+
+// read Reads a response, decompressing it if needed
+func read(t *testing.T, conn net.Conn, framing protocol.Framing) protocol.Message {
+	t.Helper()
+	frame, err := framing.ReadFrame(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame, err = protocol.Decompress(frame, 0); err != nil {
+		t.Fatal(err)
+	}
+	response, err := protocol.Decode(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func assertResponse(t *testing.T, got protocol.Message, expected protocol.Message) {
+	t.Helper()
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %T%+v, got %T%+v", expected, expected, got, got)
+	}
+}
+
+func TestServerStoresBetsOnceAndAnswersQueries(t *testing.T) {
+	server := startServer(t)
+	server.RejectDocuments("21073376")
+	server.SetWinners("1", "30904465")
+	server.SetPendingQueries(1)
+	conn, reply, framing := dial(t, server)
+	if reply.Version != protocol.Version || len(reply.Capabilities) != 0 {
+		t.Fatalf("expected nothing enabled when nothing is offered, got %+v", reply)
+	}
+
+	assertResponse(t, exchange(t, conn, framing, protocol.Bets{BatchID: 1, Bets: testBets}), protocol.Ack{BatchID: 1, Count: 2})
+	// A batch sent again is acknowledged without storing its bets twice
+	assertResponse(t, exchange(t, conn, framing, protocol.Bets{BatchID: 1, Bets: testBets}), protocol.Ack{BatchID: 1, Count: 2})
+	rejected := append([]lottery.Bet{testBets[0]}, testBets[1])
+	rejected[1].Document = "21073376"
+	assertResponse(t, exchange(t, conn, framing, protocol.Bets{BatchID: 2, Bets: rejected}), protocol.Reject{BatchID: 2, Indexes: []int{1}})
+	if bets := server.Bets("1"); !reflect.DeepEqual(bets, testBets) {
+		t.Fatalf("expected the first batch stored once, got %+v", bets)
+	}
+
+	assertResponse(t, exchange(t, conn, framing, protocol.DeliveryEnded{Agency: "1"}), protocol.Ack{})
+	if !server.Finished("1") || server.Finished("2") {
+		t.Fatal("expected only agency 1 to finish its delivery")
+	}
+	assertResponse(t, exchange(t, conn, framing, protocol.WinnersQuery{Agency: "1"}), protocol.WinnersPending{})
+	assertResponse(t, exchange(t, conn, framing, protocol.WinnersQuery{Agency: "1"}), protocol.Winners{Documents: []string{"30904465"}})
+
+	// The hello is recorded but does not consume the script
+	if received := server.Received(); len(received) != 7 || received[0].Frame.Type != protocol.TypeHello || received[1].Conn != 1 {
+		t.Fatalf("unexpected frames received %+v", received)
+	}
+}
+
+func TestServerFollowsTheScript(t *testing.T) {
+	server := startServer(t)
+	server.Script(
+		Action{Kind: RejectBets, Indexes: []int{0}},
+		Action{Kind: Reply}.After(50*time.Millisecond),
+		Action{Kind: Garbage, Bytes: []byte{0, 1}},
+		Action{Kind: CloseMidFrame},
+		Action{Kind: Hangup},
+	)
+
+	conn, _, framing := dial(t, server)
+	assertResponse(t, exchange(t, conn, framing, protocol.Bets{BatchID: 1, Bets: testBets}), protocol.Reject{BatchID: 1, Indexes: []int{0}})
+	start := time.Now()
+	assertResponse(t, exchange(t, conn, framing, protocol.Bets{BatchID: 1, Bets: testBets}), protocol.Ack{BatchID: 1, Count: 2})
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("expected the reply to be delayed, took %v", elapsed)
+	}
+
+	for _, expected := range []error{io.ErrUnexpectedEOF, io.ErrUnexpectedEOF, io.EOF} {
+		conn, _, framing := dial(t, server)
+		frame, _ := protocol.Encode(protocol.WinnersQuery{Agency: "1"})
+		framing.WriteFrame(conn, frame)
+		if _, err := framing.ReadFrame(conn); err != expected {
+			t.Fatalf("expected %v, got %v", expected, err)
+		}
+	}
+	if connections := server.Connections(); connections != 4 {
+		t.Fatalf("expected 4 connections, got %v", connections)
+	}
+}
+
+func TestServerNegotiatesCapabilitiesAndPushesWinners(t *testing.T) {
+	server := startServer(t)
+	server.SetWinners("1", "30904465")
+	server.SetPendingQueries(1)
+	conn, reply, framing := dial(t, server, protocol.CapabilityPipelining, protocol.CapabilityChecksums, protocol.CapabilityPush, protocol.CapabilityCompression)
+	if expected := []string{protocol.CapabilityChecksums, protocol.CapabilityPush, protocol.CapabilityCompression}; !reflect.DeepEqual(reply.Capabilities, expected) {
+		t.Fatalf("expected %v enabled, got %+v", expected, reply)
+	}
+
+	// Large enough to be compressed
+	bets := make([]lottery.Bet, 20)
+	for i := range bets {
+		bets[i] = testBets[i%2]
+	}
+	frame, _ := protocol.Encode(protocol.Bets{BatchID: 1, Bets: bets})
+	if err := framing.WriteFrame(conn, protocol.Compress(frame, 0)); err != nil {
+		t.Fatal(err)
+	}
+	assertResponse(t, read(t, conn, framing), protocol.Ack{BatchID: 1, Count: 20})
+	if received := server.Received(); !received[1].Compressed || received[1].Size != framing.WireSize(len(protocol.Compress(frame, 0).Payload)) {
+		t.Fatalf("expected the batch to arrive compressed, got %+v", received[1])
+	}
+
+	assertResponse(t, exchange(t, conn, framing, protocol.WinnersQuery{Agency: "1"}), protocol.WinnersPending{})
+	server.Draw()
+	assertResponse(t, read(t, conn, framing), protocol.Winners{Documents: []string{"30904465"}})
+}
+
+func TestServerOfAnotherVersionEnablesNothing(t *testing.T) {
+	server := startServer(t)
+	server.SetVersion(protocol.Version + 1)
+	if _, reply, _ := dial(t, server, protocol.CapabilityChecksums); reply.Version != protocol.Version+1 || len(reply.Capabilities) != 0 {
+		t.Fatalf("unexpected reply %+v", reply)
+	}
+
+	server = startServer(t)
+	server.SetCapabilities(protocol.CapabilityPush)
+	if _, reply, _ := dial(t, server, protocol.CapabilityChecksums, protocol.CapabilityPush); !reflect.DeepEqual(reply.Capabilities, []string{protocol.CapabilityPush}) {
+		t.Fatalf("expected only push enabled, got %+v", reply)
+	}
+}
+
+func TestServerClosesConnectionsWithCorruptFrames(t *testing.T) {
+	server := startServer(t)
+	conn, _, framing := dial(t, server, protocol.CapabilityChecksums)
+	if !framing.Checksums {
+		t.Fatal("expected checksums to be enabled")
+	}
+	frame, _ := protocol.Encode(protocol.WinnersQuery{Agency: "1"})
+	// The checksum of a frame without it
+	conn.Write([]byte{0, 6, byte(frame.Type), frame.Payload[0], 0, 0, 0, 0})
+	if _, err := framing.ReadFrame(conn); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected the connection to be closed, got %v", err)
+	}
+	if corrupt := server.CorruptFrames(); corrupt != 1 {
+		t.Fatalf("expected 1 corrupt frame, got %v", corrupt)
+	}
+}
@@ -0,0 +1,217 @@
+package faultproxy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kind Type of fault injected on a connection
+type Kind int
+
+const (
+	// Latency Delays every chunk forwarded by Delay
+	Latency Kind = iota + 1
+	// Bandwidth Limits the throughput to BytesPerSecond
+	Bandwidth
+	// Fragment Forwards the data one byte per write, so the receiver gets
+	// short reads
+	Fragment
+	// Reset Aborts both sides of the connection with a TCP RST once
+	// AfterBytes bytes were forwarded
+	Reset
+	// HalfClose Closes the write side towards the receiver once AfterBytes
+	// bytes were forwarded, discarding whatever the sender writes afterwards
+	HalfClose
+	// BlackHole Silently discards everything once AfterBytes bytes were
+	// forwarded, keeping the connection open
+	BlackHole
+	// Corrupt Flips a bit of the byte that follows the first AfterBytes bytes
+	// and keeps forwarding, like a faulty relay
+	Corrupt
+)
+
+var kindNames = map[Kind]string{
+	Latency:   "latency",
+	Bandwidth: "bandwidth",
+	Fragment:  "fragment",
+	Reset:     "reset",
+	HalfClose: "halfclose",
+	BlackHole: "blackhole",
+	Corrupt:   "corrupt",
+}
+
+func (k Kind) String() string {
+	if name, ok := kindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("kind(%d)", int(k))
+}
+
+// triggered Returns true for the faults that start after AfterBytes bytes
+func (k Kind) triggered() bool {
+	return k == Reset || k == HalfClose || k == BlackHole || k == Corrupt
+}
+
+// Direction Side of the connection a fault applies to
+type Direction int
+
+const (
+	// Both Client to server and server to client
+	Both Direction = iota
+	// Upstream Client to server
+	Upstream
+	// Downstream Server to client
+	Downstream
+)
+
+var directionNames = map[Direction]string{
+	Both:       "both",
+	Upstream:   "up",
+	Downstream: "down",
+}
+
+func (d Direction) String() string {
+	if name, ok := directionNames[d]; ok {
+		return name
+	}
+	return fmt.Sprintf("direction(%d)", int(d))
+}
+
+// includes Returns true if the direction covers the given pipe direction
+func (d Direction) includes(pipe Direction) bool {
+	return d == Both || d == pipe
+}
+
+// Fault Misbehavior injected on a proxied connection
+type Fault struct {
+	Kind      Kind
+	Direction Direction
+	// Delay Added before every chunk by a Latency fault
+	Delay time.Duration
+	// BytesPerSecond Throughput allowed by a Bandwidth fault
+	BytesPerSecond int
+	// AfterBytes Bytes forwarded in the direction of a Reset, HalfClose,
+	// BlackHole or Corrupt fault before it triggers
+	AfterBytes int
+}
+
+// String Formats the fault with the syntax accepted by ParseFault
+func (f Fault) String() string {
+	s := f.Kind.String()
+	switch f.Kind {
+	case Latency:
+		s += "=" + f.Delay.String()
+	case Bandwidth:
+		s += "=" + strconv.Itoa(f.BytesPerSecond)
+	}
+	if f.Kind.triggered() {
+		s += "@" + strconv.Itoa(f.AfterBytes)
+	}
+	if f.Direction != Both {
+		s += "/" + f.Direction.String()
+	}
+	return s
+}
+
+// ParseFault Parses a fault written as kind[=value][@after][/direction], for
+// example latency=50ms, bandwidth=1024, fragment/down, reset@64, corrupt@10 or
+// blackhole@0/up. Latency requires a duration, bandwidth requires bytes per
+// second and @after is only accepted by reset, halfclose, blackhole and
+// corrupt
+func ParseFault(spec string) (Fault, error) {
+	var fault Fault
+	rest := strings.TrimSpace(spec)
+
+	if i := strings.LastIndex(rest, "/"); i >= 0 {
+		direction, ok := parseDirection(rest[i+1:])
+		if !ok {
+			return fault, fmt.Errorf("invalid direction in fault %q", spec)
+		}
+		fault.Direction = direction
+		rest = rest[:i]
+	}
+
+	after := ""
+	if i := strings.Index(rest, "@"); i >= 0 {
+		after = rest[i+1:]
+		rest = rest[:i]
+	}
+	value := ""
+	if i := strings.Index(rest, "="); i >= 0 {
+		value = rest[i+1:]
+		rest = rest[:i]
+	}
+
+	kind, ok := parseKind(rest)
+	if !ok {
+		return fault, fmt.Errorf("unknown fault %q", spec)
+	}
+	fault.Kind = kind
+
+	switch kind {
+	case Latency:
+		delay, err := time.ParseDuration(value)
+		if err != nil || delay < 0 {
+			return fault, fmt.Errorf("fault %q requires a delay, like latency=50ms", spec)
+		}
+		fault.Delay = delay
+	case Bandwidth:
+		rate, err := strconv.Atoi(value)
+		if err != nil || rate <= 0 {
+			return fault, fmt.Errorf("fault %q requires bytes per second, like bandwidth=1024", spec)
+		}
+		fault.BytesPerSecond = rate
+	default:
+		if value != "" {
+			return fault, fmt.Errorf("fault %q does not take a value", spec)
+		}
+	}
+
+	if after != "" {
+		if !kind.triggered() {
+			return fault, fmt.Errorf("fault %q does not take @after", spec)
+		}
+		n, err := strconv.Atoi(after)
+		if err != nil || n < 0 {
+			return fault, fmt.Errorf("invalid byte count in fault %q", spec)
+		}
+		fault.AfterBytes = n
+	}
+	return fault, nil
+}
+
+// ParseFaults Parses a comma separated list of faults
+func ParseFaults(specs string) ([]Fault, error) {
+	var faults []Fault
+	for _, spec := range strings.Split(specs, ",") {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		fault, err := ParseFault(spec)
+		if err != nil {
+			return nil, err
+		}
+		faults = append(faults, fault)
+	}
+	return faults, nil
+}
+
+func parseKind(name string) (Kind, bool) {
+	for kind, kindName := range kindNames {
+		if kindName == name {
+			return kind, true
+		}
+	}
+	return 0, false
+}
+
+func parseDirection(name string) (Direction, bool) {
+	for direction, directionName := range directionNames {
+		if directionName == name {
+			return direction, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,319 @@
+// Package faultproxy provides a TCP proxy that sits between a client and a
+// server and injects network faults on the connections it forwards:
+// latency, bandwidth limits, 1-byte fragmentation, resets, half-closes,
+// black holes and corrupted bytes. The faults of every connection are chosen
+// by a Schedule, so a failing run can be reproduced deterministically.
+package faultproxy
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Config Configuration of a proxy
+type Config struct {
+	// Listen Address the proxy listens on. Defaults to a random loopback port
+	Listen string
+	// Target Address of the server connections are forwarded to
+	Target string
+	// Schedule Faults injected on every connection. Nil forwards every
+	// connection untouched
+	Schedule Schedule
+	// OnConnection If set, called with the faults chosen for every accepted
+	// connection before forwarding it
+	OnConnection func(conn int, faults []Fault)
+}
+
+// Proxy Fault injecting TCP proxy
+type Proxy struct {
+	config   Config
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu        sync.Mutex
+	closed    bool
+	conns     map[net.Conn]bool
+	connCount int
+}
+
+// Start Listens on the configured address and forwards connections to the
+// target in background
+func Start(config Config) (*Proxy, error) {
+	if config.Listen == "" {
+		config.Listen = "127.0.0.1:0"
+	}
+	listener, err := net.Listen("tcp", config.Listen)
+	if err != nil {
+		return nil, err
+	}
+	p := &Proxy{
+		config:   config,
+		listener: listener,
+		conns:    make(map[net.Conn]bool),
+	}
+	p.wg.Add(1)
+	go p.accept()
+	return p, nil
+}
+
+// Addr Address clients must connect to
+func (p *Proxy) Addr() string {
+	return p.listener.Addr().String()
+}
+
+// Connections Amount of connections accepted so far
+func (p *Proxy) Connections() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.connCount
+}
+
+// Close Stops accepting connections, closes every forwarded connection and
+// waits for the proxy goroutines to finish
+func (p *Proxy) Close() error {
+	err := p.listener.Close()
+	p.mu.Lock()
+	p.closed = true
+	for conn := range p.conns {
+		conn.Close()
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+	return err
+}
+
+func (p *Proxy) accept() {
+	defer p.wg.Done()
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		p.mu.Lock()
+		p.connCount++
+		id := p.connCount
+		p.mu.Unlock()
+
+		var faults []Fault
+		if p.config.Schedule != nil {
+			faults = p.config.Schedule.Faults(id)
+		}
+		if p.config.OnConnection != nil {
+			p.config.OnConnection(id, faults)
+		}
+
+		p.wg.Add(1)
+		go p.forward(conn, faults)
+	}
+}
+
+// track Registers the connection so Close can interrupt it. Returns false if
+// the proxy is already closed
+func (p *Proxy) track(conn net.Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return false
+	}
+	p.conns[conn] = true
+	return true
+}
+
+func (p *Proxy) untrack(conn net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.conns, conn)
+}
+
+// forward Connects to the target and copies data both ways, applying the
+// faults of the connection, until both directions finish
+func (p *Proxy) forward(client net.Conn, faults []Fault) {
+	defer p.wg.Done()
+
+	defer client.Close()
+	if !p.track(client) {
+		return
+	}
+	defer p.untrack(client)
+
+	server, err := net.Dial("tcp", p.config.Target)
+	if err != nil {
+		return
+	}
+	defer server.Close()
+	if !p.track(server) {
+		return
+	}
+	defer p.untrack(server)
+
+	l := &link{client: client, server: server}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		l.pipe(client, server, faultsFor(faults, Upstream))
+	}()
+	go func() {
+		defer wg.Done()
+		l.pipe(server, client, faultsFor(faults, Downstream))
+	}()
+	wg.Wait()
+}
+
+// faultsFor Faults that apply to a single direction
+func faultsFor(faults []Fault, direction Direction) []Fault {
+	var selected []Fault
+	for _, fault := range faults {
+		if fault.Direction.includes(direction) {
+			selected = append(selected, fault)
+		}
+	}
+	return selected
+}
+
+// link Both sides of a forwarded connection
+type link struct {
+	client net.Conn
+	server net.Conn
+	once   sync.Once
+}
+
+// abort Closes both sides with a TCP RST instead of a graceful FIN
+func (l *link) abort() {
+	l.once.Do(func() {
+		for _, conn := range []net.Conn{l.client, l.server} {
+			if tcp, ok := conn.(*net.TCPConn); ok {
+				tcp.SetLinger(0)
+			}
+			conn.Close()
+		}
+	})
+}
+
+// pipe Copies src into dst applying the faults of the direction. A graceful
+// close of src is forwarded as a half-close of dst; any other error aborts
+// the whole connection
+func (l *link) pipe(src net.Conn, dst net.Conn, faults []Fault) {
+	var trigger *Fault
+	for i := range faults {
+		// Corruption does not interrupt the forwarding
+		if faults[i].Kind == Corrupt {
+			continue
+		}
+		if faults[i].Kind.triggered() && (trigger == nil || faults[i].AfterBytes < trigger.AfterBytes) {
+			trigger = &faults[i]
+		}
+	}
+
+	buf := make([]byte, 32*1024)
+	forwarded := 0
+	for {
+		n, err := src.Read(buf)
+		data := buf[:n]
+		corrupt(data, forwarded, faults)
+		if trigger != nil && forwarded+n > trigger.AfterBytes {
+			if writeErr := write(dst, data[:trigger.AfterBytes-forwarded], faults); writeErr != nil {
+				l.abort()
+				return
+			}
+			l.inject(*trigger, src, dst)
+			return
+		}
+		if err := write(dst, data, faults); err != nil {
+			l.abort()
+			return
+		}
+		forwarded += n
+
+		if err == io.EOF {
+			closeWrite(dst)
+			return
+		}
+		if err != nil {
+			l.abort()
+			return
+		}
+	}
+}
+
+// inject Applies a fault that triggers after a byte count
+func (l *link) inject(fault Fault, src net.Conn, dst net.Conn) {
+	switch fault.Kind {
+	case Reset:
+		l.abort()
+	case HalfClose:
+		closeWrite(dst)
+		io.Copy(io.Discard, src)
+	case BlackHole:
+		io.Copy(io.Discard, src)
+	}
+}
+
+// corrupt Flips a bit of the byte targeted by every Corrupt fault that falls
+// within data, which starts offset bytes into the stream
+func corrupt(data []byte, offset int, faults []Fault) {
+	for _, fault := range faults {
+		if fault.Kind == Corrupt && fault.AfterBytes >= offset && fault.AfterBytes < offset+len(data) {
+			data[fault.AfterBytes-offset] ^= 0x01
+		}
+	}
+}
+
+// write Writes data into dst, delayed, fragmented and throttled according to
+// the faults
+func write(dst net.Conn, data []byte, faults []Fault) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	chunkSize := len(data)
+	rate := 0
+	for _, fault := range faults {
+		switch fault.Kind {
+		case Latency:
+			time.Sleep(fault.Delay)
+		case Fragment:
+			chunkSize = 1
+		case Bandwidth:
+			if rate == 0 || fault.BytesPerSecond < rate {
+				rate = fault.BytesPerSecond
+			}
+		}
+	}
+	if rate > 0 {
+		// Writing a twentieth of the rate at a time keeps the throughput
+		// smooth instead of bursting once per second
+		if slice := rate / 20; slice < chunkSize {
+			chunkSize = slice
+		}
+		if chunkSize < 1 {
+			chunkSize = 1
+		}
+	}
+
+	for len(data) > 0 {
+		n := chunkSize
+		if n > len(data) {
+			n = len(data)
+		}
+		if _, err := dst.Write(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+		if rate > 0 {
+			time.Sleep(time.Duration(n) * time.Second / time.Duration(rate))
+		}
+	}
+	return nil
+}
+
+// closeWrite Half-closes the connection if it supports it, or closes it
+func closeWrite(conn net.Conn) {
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		c.CloseWrite()
+		return
+	}
+	conn.Close()
+}
@@ -0,0 +1,271 @@
+package faultproxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// startEchoServer Echoes every connection until the client half-closes it
+func startEchoServer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func startProxy(t *testing.T, target string, faults ...Fault) *Proxy {
+	t.Helper()
+	proxy, err := Start(Config{Target: target, Schedule: Always(faults)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { proxy.Close() })
+	return proxy
+}
+
+// exchange Sends data through the proxy, half-closes and reads until the
+// connection ends or the timeout expires
+func exchange(t *testing.T, address string, data []byte, timeout time.Duration) ([]byte, error) {
+	t.Helper()
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err := conn.Write(data); err != nil {
+		return nil, err
+	}
+	conn.(*net.TCPConn).CloseWrite()
+	return io.ReadAll(conn)
+}
+
+func TestParseFault(t *testing.T) {
+	tests := []struct {
+		spec     string
+		expected Fault
+	}{
+		{spec: "latency=50ms", expected: Fault{Kind: Latency, Delay: 50 * time.Millisecond}},
+		{spec: "bandwidth=1024/up", expected: Fault{Kind: Bandwidth, BytesPerSecond: 1024, Direction: Upstream}},
+		{spec: "fragment/down", expected: Fault{Kind: Fragment, Direction: Downstream}},
+		{spec: "reset@64", expected: Fault{Kind: Reset, AfterBytes: 64}},
+		{spec: "halfclose@0/down", expected: Fault{Kind: HalfClose, Direction: Downstream}},
+		{spec: "blackhole@3/up", expected: Fault{Kind: BlackHole, AfterBytes: 3, Direction: Upstream}},
+		{spec: "corrupt@10/down", expected: Fault{Kind: Corrupt, AfterBytes: 10, Direction: Downstream}},
+	}
+
+	for _, test := range tests {
+		fault, err := ParseFault(test.spec)
+		if err != nil {
+			t.Fatalf("%v: %v", test.spec, err)
+		}
+		if fault != test.expected {
+			t.Fatalf("%v: expected %+v, got %+v", test.spec, test.expected, fault)
+		}
+		if fault.String() != test.spec {
+			t.Fatalf("expected %v to format back to itself, got %v", test.spec, fault.String())
+		}
+	}
+
+	for _, spec := range []string{"", "lag", "latency", "latency=-1s", "bandwidth=0", "fragment=1", "fragment@2", "reset@x", "reset/sideways"} {
+		if _, err := ParseFault(spec); err == nil {
+			t.Fatalf("expected %q to be invalid", spec)
+		}
+	}
+}
+
+func TestRandomScheduleIsReproducible(t *testing.T) {
+	schedule := RandomSchedule{
+		Seed:        42,
+		Probability: 0.5,
+		Candidates:  []Fault{{Kind: Fragment}, {Kind: Reset, AfterBytes: 100}, {Kind: BlackHole, AfterBytes: 10}},
+	}
+
+	injected := 0
+	for conn := 1; conn <= 100; conn++ {
+		faults := schedule.Faults(conn)
+		if !reflect.DeepEqual(faults, schedule.Faults(conn)) {
+			t.Fatalf("connection %v got different faults from the same seed", conn)
+		}
+		for _, fault := range faults {
+			injected++
+			if fault.Kind == Reset && fault.AfterBytes > 100 || fault.Kind == BlackHole && fault.AfterBytes > 10 {
+				t.Fatalf("AfterBytes above the candidate maximum: %+v", fault)
+			}
+		}
+	}
+	if injected < 25 || injected > 75 {
+		t.Fatalf("expected about half of the connections to get a fault, got %v", injected)
+	}
+
+	other := schedule
+	other.Seed = 43
+	same := true
+	for conn := 1; conn <= 100; conn++ {
+		same = same && reflect.DeepEqual(schedule.Faults(conn), other.Faults(conn))
+	}
+	if same {
+		t.Fatal("expected a different seed to give a different schedule")
+	}
+
+	never := RandomSchedule{Seed: 1, Probability: 0, Candidates: schedule.Candidates}
+	always := RandomSchedule{Seed: 1, Probability: 1, Candidates: schedule.Candidates}
+	for conn := 1; conn <= 20; conn++ {
+		if len(never.Faults(conn)) != 0 || len(always.Faults(conn)) != 1 {
+			t.Fatal("probability 0 must never inject and probability 1 must always inject")
+		}
+	}
+}
+
+func TestPlanAppliesFaultsByConnection(t *testing.T) {
+	plan := Plan{nil, {{Kind: Fragment}}}
+	if plan.Faults(1) != nil || len(plan.Faults(2)) != 1 || plan.Faults(3) != nil {
+		t.Fatalf("unexpected plan lookups")
+	}
+}
+
+// recordingConn Records the size of every write
+type recordingConn struct {
+	net.Conn
+	writes []int
+}
+
+func (c *recordingConn) Write(p []byte) (int, error) {
+	c.writes = append(c.writes, len(p))
+	return len(p), nil
+}
+
+func TestWriteFragmentsAndThrottles(t *testing.T) {
+	conn := &recordingConn{}
+	if err := write(conn, []byte("hello"), []Fault{{Kind: Fragment}}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(conn.writes, []int{1, 1, 1, 1, 1}) {
+		t.Fatalf("expected 1-byte writes, got %v", conn.writes)
+	}
+
+	conn = &recordingConn{}
+	start := time.Now()
+	if err := write(conn, make([]byte, 1000), []Fault{{Kind: Bandwidth, BytesPerSecond: 10000}}); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Fatalf("1000 bytes at 10000 B/s took only %v", elapsed)
+	}
+	if len(conn.writes) != 2 || conn.writes[0] != 500 {
+		t.Fatalf("expected writes of a twentieth of the rate, got %v", conn.writes)
+	}
+}
+
+func TestProxyForwardsUntouchedWithoutFaults(t *testing.T) {
+	proxy := startProxy(t, startEchoServer(t))
+	data := bytes.Repeat([]byte("lotería "), 10000)
+
+	received, err := exchange(t, proxy.Addr(), data, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received, data) {
+		t.Fatalf("expected %v bytes back, got %v", len(data), len(received))
+	}
+}
+
+func TestProxyInjectsFaults(t *testing.T) {
+	data := []byte("0123456789")
+	tests := []struct {
+		name  string
+		fault Fault
+		check func(t *testing.T, received []byte, err error, elapsed time.Duration)
+	}{
+		{
+			name:  "latency",
+			fault: Fault{Kind: Latency, Delay: 50 * time.Millisecond},
+			check: func(t *testing.T, received []byte, err error, elapsed time.Duration) {
+				if err != nil || !bytes.Equal(received, data) {
+					t.Fatalf("expected the data back, got %q, %v", received, err)
+				}
+				if elapsed < 100*time.Millisecond {
+					t.Fatalf("expected a delay on both directions, took %v", elapsed)
+				}
+			},
+		},
+		{
+			name:  "fragment",
+			fault: Fault{Kind: Fragment},
+			check: func(t *testing.T, received []byte, err error, _ time.Duration) {
+				if err != nil || !bytes.Equal(received, data) {
+					t.Fatalf("expected the data back, got %q, %v", received, err)
+				}
+			},
+		},
+		{
+			name:  "reset",
+			fault: Fault{Kind: Reset, AfterBytes: 4, Direction: Downstream},
+			check: func(t *testing.T, received []byte, err error, _ time.Duration) {
+				if err == nil || errors.Is(err, io.EOF) {
+					t.Fatalf("expected a connection reset, got %q, %v", received, err)
+				}
+			},
+		},
+		{
+			name:  "half close",
+			fault: Fault{Kind: HalfClose, AfterBytes: 4, Direction: Downstream},
+			check: func(t *testing.T, received []byte, err error, _ time.Duration) {
+				if err != nil || string(received) != "0123" {
+					t.Fatalf("expected 4 bytes and EOF, got %q, %v", received, err)
+				}
+			},
+		},
+		{
+			name:  "black hole",
+			fault: Fault{Kind: BlackHole, AfterBytes: 4, Direction: Upstream},
+			check: func(t *testing.T, received []byte, err error, _ time.Duration) {
+				var netErr net.Error
+				if !errors.As(err, &netErr) || !netErr.Timeout() || string(received) != "0123" {
+					t.Fatalf("expected 4 bytes and a timeout, got %q, %v", received, err)
+				}
+			},
+		},
+		{
+			name:  "corrupt",
+			fault: Fault{Kind: Corrupt, AfterBytes: 4, Direction: Upstream},
+			check: func(t *testing.T, received []byte, err error, _ time.Duration) {
+				if err != nil || string(received) != "0123556789" {
+					t.Fatalf("expected the fifth byte flipped and the rest untouched, got %q, %v", received, err)
+				}
+			},
+		},
+	}
+
+	target := startEchoServer(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			proxy := startProxy(t, target, test.fault)
+			start := time.Now()
+			received, err := exchange(t, proxy.Addr(), data, 300*time.Millisecond)
+			test.check(t, received, err, time.Since(start))
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package faultproxy
+
+import (
+	"math/rand"
+)
+
+// Schedule Decides the faults injected on every connection. Connections are
+// numbered from 1 in the order the proxy accepts them
+type Schedule interface {
+	Faults(conn int) []Fault
+}
+
+// Plan Fixed schedule: connection n gets the faults at index n-1. Connections
+// beyond the end of the plan are forwarded untouched
+type Plan [][]Fault
+
+// Faults Faults planned for the connection
+func (p Plan) Faults(conn int) []Fault {
+	if conn < 1 || conn > len(p) {
+		return nil
+	}
+	return p[conn-1]
+}
+
+// Always Schedule that injects the same faults on every connection
+type Always []Fault
+
+// Faults Returns the faults, whatever the connection
+func (a Always) Faults(int) []Fault {
+	return a
+}
+
+// RandomSchedule Seeded schedule that injects one of Candidates on each
+// connection with the given Probability. For Reset, HalfClose, BlackHole and
+// Corrupt candidates AfterBytes is the maximum: the actual value is drawn
+// uniformly between 0 and it. The faults of a connection only depend on the
+// seed and the connection number, so reusing the seed reproduces a run
+type RandomSchedule struct {
+	Seed        int64
+	Probability float64
+	Candidates  []Fault
+}
+
+// Faults Faults drawn for the connection
+func (s RandomSchedule) Faults(conn int) []Fault {
+	if len(s.Candidates) == 0 {
+		return nil
+	}
+	// Mixing the connection number into the seed keeps the draws independent
+	// from the order in which concurrent connections ask for them
+	r := rand.New(rand.NewSource(s.Seed*1000003 + int64(conn)))
+	if r.Float64() >= s.Probability {
+		return nil
+	}
+	fault := s.Candidates[r.Intn(len(s.Candidates))]
+	if fault.Kind.triggered() {
+		fault.AfterBytes = r.Intn(fault.AfterBytes + 1)
+	}
+	return []Fault{fault}
+}
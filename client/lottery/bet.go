@@ -0,0 +1,73 @@
+// Package lottery holds the domain model of the client: the bets placed by
+// the people that play in an agency, independently of how they are read or
+// how they travel to the server.
+package lottery
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BirthdateLayout Layout of the birthdate of a bet, as expected by the server
+const BirthdateLayout = "2006-01-02"
+
+// Bet A lottery bet registry. Fields are kept as strings, in the same format
+// they are sent to the server
+type Bet struct {
+	Agency    string
+	FirstName string
+	LastName  string
+	Document  string
+	Birthdate string
+	Number    string
+}
+
+// InvalidBetError A bet that cannot be sent to the server
+type InvalidBetError struct {
+	Field  string
+	Value  string
+	Reason string
+}
+
+func (e *InvalidBetError) Error() string {
+	return fmt.Sprintf("invalid %s %q: %s", e.Field, e.Value, e.Reason)
+}
+
+// Validate Checks that every field of the bet can be stored by the server:
+// agency and number must be non negative integers, document must be numeric,
+// birthdate must follow BirthdateLayout and names cannot be empty
+func (b Bet) Validate() error {
+	if _, err := strconv.ParseUint(b.Agency, 10, 32); err != nil {
+		return &InvalidBetError{Field: "agency", Value: b.Agency, Reason: "must be a non negative integer"}
+	}
+	if strings.TrimSpace(b.FirstName) == "" {
+		return &InvalidBetError{Field: "first_name", Value: b.FirstName, Reason: "cannot be empty"}
+	}
+	if strings.TrimSpace(b.LastName) == "" {
+		return &InvalidBetError{Field: "last_name", Value: b.LastName, Reason: "cannot be empty"}
+	}
+	if !isNumeric(b.Document) {
+		return &InvalidBetError{Field: "document", Value: b.Document, Reason: "must be numeric"}
+	}
+	if _, err := time.Parse(BirthdateLayout, b.Birthdate); err != nil {
+		return &InvalidBetError{Field: "birthdate", Value: b.Birthdate, Reason: "must be a YYYY-MM-DD date"}
+	}
+	if _, err := strconv.ParseUint(b.Number, 10, 32); err != nil {
+		return &InvalidBetError{Field: "number", Value: b.Number, Reason: "must be a non negative integer"}
+	}
+	return nil
+}
+
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,89 @@
+package lottery
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// csvFields Columns of the agency datasets provided by the course:
+// first_name,last_name,document,birthdate,number
+const csvFields = 5
+
+// RowError A dataset row that could not be turned into a valid bet. Reading
+// can continue after it
+type RowError struct {
+	Line int
+	// Row Content of the row, in the format of the dataset, when it could be
+	// read
+	Row string
+	Err error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// CSVReader Reads the bets of an agency dataset. The agency is not part of
+// the dataset, so it is given when the reader is created
+type CSVReader struct {
+	reader *csv.Reader
+	agency string
+}
+
+// NewCSVReader Initializes a reader of the bets of the given agency
+func NewCSVReader(r io.Reader, agency string) *CSVReader {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = csvFields
+	reader.ReuseRecord = true
+	return &CSVReader{reader: reader, agency: agency}
+}
+
+// Read Returns the next bet of the dataset. It returns io.EOF once the whole
+// dataset was read and a *RowError for rows that are malformed or do not
+// hold a valid bet
+func (c *CSVReader) Read() (Bet, error) {
+	record, err := c.reader.Read()
+	if err == io.EOF {
+		return Bet{}, io.EOF
+	}
+	if parseErr, ok := err.(*csv.ParseError); ok {
+		// Rows with another amount of columns are still returned
+		return Bet{}, &RowError{Line: parseErr.StartLine, Row: CSVRow(record), Err: parseErr.Err}
+	}
+	if err != nil {
+		return Bet{}, err
+	}
+	line, _ := c.reader.FieldPos(0)
+
+	bet := Bet{
+		Agency:    c.agency,
+		FirstName: record[0],
+		LastName:  record[1],
+		Document:  record[2],
+		Birthdate: record[3],
+		Number:    record[4],
+	}
+	if err := bet.Validate(); err != nil {
+		return Bet{}, &RowError{Line: line, Row: CSVRow(record), Err: err}
+	}
+	return bet, nil
+}
+
+// CSVRow Encodes the columns of a record back into a CSV row, without the
+// line break
+func CSVRow(record []string) string {
+	if record == nil {
+		return ""
+	}
+	var row strings.Builder
+	writer := csv.NewWriter(&row)
+	writer.Write(record)
+	writer.Flush()
+	return strings.TrimSuffix(row.String(), "\n")
+}
@@ -0,0 +1,67 @@
+package lottery
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCSVReaderReadsBetsAndReportsInvalidRows(t *testing.T) {
+	dataset := strings.Join([]string{
+		"Valentina,Vera,30170921,1982-05-22,6053",
+		"Santiago,Álvarez,33936970,1986-04-25,7068",
+		"Missing,Columns,1",
+		"Bad,Date,21073376,1994-13-01,6293",
+		",Empty,21073376,1994-09-01,6293",
+		"Bad,Number,21073376,1994-09-01,-1",
+		`"Camila, Agustina",Pineda,29665629,2000-01-06,9999`,
+	}, "\n")
+	reader := NewCSVReader(strings.NewReader(dataset), "3")
+
+	var bets []Bet
+	var lines []int
+	var rows []string
+	for {
+		bet, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var rowErr *RowError
+		if errors.As(err, &rowErr) {
+			lines = append(lines, rowErr.Line)
+			rows = append(rows, rowErr.Row)
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		bets = append(bets, bet)
+	}
+
+	if len(bets) != 3 {
+		t.Fatalf("expected 3 bets, got %+v", bets)
+	}
+	expected := Bet{Agency: "3", FirstName: "Santiago", LastName: "Álvarez", Document: "33936970", Birthdate: "1986-04-25", Number: "7068"}
+	if bets[1] != expected {
+		t.Fatalf("expected %+v, got %+v", expected, bets[1])
+	}
+	if bets[2].FirstName != "Camila, Agustina" {
+		t.Fatalf("quoted fields must be supported, got %+v", bets[2])
+	}
+	if len(lines) != 4 || lines[0] != 3 || lines[3] != 6 {
+		t.Fatalf("unexpected invalid lines %v", lines)
+	}
+	// The rows are kept as they were read so they can be fixed
+	if len(rows) != 4 || rows[0] != "Missing,Columns,1" || rows[2] != ",Empty,21073376,1994-09-01,6293" {
+		t.Fatalf("unexpected invalid rows %q", rows)
+	}
+}
+
+func TestValidateReportsTheInvalidField(t *testing.T) {
+	bet := Bet{Agency: "1", FirstName: "a", LastName: "b", Document: "1", Birthdate: "2000-01-01", Number: "x"}
+	var invalid *InvalidBetError
+	if err := bet.Validate(); !errors.As(err, &invalid) || invalid.Field != "number" {
+		t.Fatalf("expected number to be invalid, got %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package lottery
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Formats of the bets of an agency
+const (
+	// FormatAuto Detects the format from the extension of the file or, when
+	// there is no file, from its first character
+	FormatAuto = "auto"
+	// FormatCSV Dataset of the course, read with CSVReader
+	FormatCSV = "csv"
+	// FormatJSONLines One JSONBet per line, read with JSONLinesReader
+	FormatJSONLines = "jsonl"
+)
+
+// Formats Every format a reader can be created for
+var Formats = []string{FormatAuto, FormatCSV, FormatJSONLines}
+
+// Reader Source of the bets of an agency. Read returns io.EOF once there are
+// no more bets and a *RowError for entries that must be skipped. Every
+// implementation validates its bets the same way
+type Reader interface {
+	Read() (Bet, error)
+}
+
+// DetectFormat Returns the format of the file from its extension:
+// .jsonl and .ndjson are JSON lines and anything else is read as CSV, the
+// format of the course datasets
+func DetectFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jsonl", ".ndjson":
+		return FormatJSONLines
+	}
+	return FormatCSV
+}
+
+// NewReader Initializes a reader of the bets of the agency in the given
+// format. FormatAuto looks at the first character that is not blank: JSON
+// lines start with '{'
+func NewReader(r io.Reader, format string, agency string) (Reader, error) {
+	if format == FormatAuto {
+		buffered := bufio.NewReader(r)
+		format = sniffFormat(buffered)
+		r = buffered
+	}
+	switch format {
+	case FormatCSV:
+		return NewCSVReader(r, agency), nil
+	case FormatJSONLines:
+		return NewJSONLinesReader(r, agency), nil
+	}
+	return nil, fmt.Errorf("unknown bets format %q, expected one of %v", format, strings.Join(Formats, ", "))
+}
+
+// sniffFormat Peeks the buffered reader, without consuming it, until its
+// first character that is not blank
+func sniffFormat(r *bufio.Reader) string {
+	for size := 1; ; size++ {
+		peeked, err := r.Peek(size)
+		if len(peeked) < size {
+			return FormatCSV
+		}
+		switch c := peeked[size-1]; c {
+		case ' ', '\t', '\r', '\n':
+			if err != nil {
+				return FormatCSV
+			}
+		case '{':
+			return FormatJSONLines
+		default:
+			return FormatCSV
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package lottery
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDetectFormatFromTheExtension(t *testing.T) {
+	tests := map[string]string{
+		"/data/agency.csv":    FormatCSV,
+		"/data/agency.jsonl":  FormatJSONLines,
+		"/data/agency.NDJSON": FormatJSONLines,
+		"/data/agency.txt":    FormatCSV,
+		"/data/agency":        FormatCSV,
+	}
+	for path, expected := range tests {
+		if format := DetectFormat(path); format != expected {
+			t.Errorf("%v: expected %v, got %v", path, expected, format)
+		}
+	}
+}
+
+func TestNewReaderDetectsTheFormatOfAStream(t *testing.T) {
+	expected := Bet{Agency: "1", FirstName: "Santiago Lionel", LastName: "Lorca", Document: "30904465", Birthdate: "1999-03-17", Number: "7574"}
+	streams := map[string]string{
+		FormatCSV:       "Santiago Lionel,Lorca,30904465,1999-03-17,7574\n",
+		FormatJSONLines: "\n  " + `{"type": "bet", "data": {"first_name": "Santiago Lionel", "last_name": "Lorca", "document_number": "30904465", "birth_date": "1999-03-17", "number": "7574"}}`,
+	}
+	for format, stream := range streams {
+		reader, err := NewReader(strings.NewReader(stream), FormatAuto, "1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bet, err := reader.Read(); err != nil || bet != expected {
+			t.Errorf("%v: expected %+v, got %+v %v", format, expected, bet, err)
+		}
+	}
+
+	if _, err := NewReader(strings.NewReader(""), "xml", "1"); err == nil {
+		t.Fatal("expected an unknown format to fail")
+	}
+}
@@ -0,0 +1,43 @@
+package lottery
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// FuzzCSVReader Parses untrusted datasets. The reader must never panic, must
+// stop at the end of the input and every bet it returns must be valid
+func FuzzCSVReader(f *testing.F) {
+	f.Add([]byte("Santiago Lionel,Lorca,30904465,1999-03-17,7574\n"))
+	f.Add([]byte("\"Camila, Agustina\",Pineda,29665629,2000-01-06,9999\r\n"))
+	f.Add([]byte("a,b\n\"unterminated\n"))
+	f.Add([]byte("Ñandú,Peña,1,2000-02-29,0\nMissing,Columns,1\n,,,,\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		reader := NewCSVReader(bytes.NewReader(data), "1")
+		// Every call consumes at least one byte or ends the input, so more
+		// calls than bytes means the reader is stuck
+		for calls := 0; calls <= len(data)+1; calls++ {
+			bet, err := reader.Read()
+			if err == io.EOF {
+				return
+			}
+			var rowErr *RowError
+			if errors.As(err, &rowErr) {
+				continue
+			}
+			if err != nil {
+				return
+			}
+			if err := bet.Validate(); err != nil {
+				t.Fatalf("returned invalid bet %+v: %v", bet, err)
+			}
+			if bet.Agency != "1" {
+				t.Fatalf("expected the reader agency, got %+v", bet)
+			}
+		}
+		t.Fatal("the reader never reached the end of the input")
+	})
+}
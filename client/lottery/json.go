@@ -0,0 +1,56 @@
+package lottery
+
+import "fmt"
+
+// JSONBetType Type of the JSON envelope that holds a bet
+const JSONBetType = "bet"
+
+// JSONBet A bet as exchanged in JSON, inside an envelope that names its
+// type:
+//
+//	{"type": "bet", "data": {"client_id": "1", "first_name": "Santiago Lionel", ...}}
+type JSONBet struct {
+	Type string      `json:"type"`
+	Data JSONBetData `json:"data"`
+}
+
+// JSONBetData Fields of a bet in JSON. The client id is the agency
+type JSONBetData struct {
+	ClientID       string `json:"client_id"`
+	FirstName      string `json:"first_name"`
+	LastName       string `json:"last_name"`
+	DocumentNumber string `json:"document_number"`
+	BirthDate      string `json:"birth_date"`
+	Number         string `json:"number"`
+}
+
+// NewJSONBet Wraps the bet in its JSON envelope
+func NewJSONBet(bet Bet) JSONBet {
+	return JSONBet{
+		Type: JSONBetType,
+		Data: JSONBetData{
+			ClientID:       bet.Agency,
+			FirstName:      bet.FirstName,
+			LastName:       bet.LastName,
+			DocumentNumber: bet.Document,
+			BirthDate:      bet.Birthdate,
+			Number:         bet.Number,
+		},
+	}
+}
+
+// Bet Returns the bet of the envelope. Envelopes of another type fail, but
+// the bet is not validated
+func (j JSONBet) Bet() (Bet, error) {
+	if j.Type != JSONBetType {
+		return Bet{}, fmt.Errorf("unexpected type %q, expected %q", j.Type, JSONBetType)
+	}
+	return Bet{
+		Agency:    j.Data.ClientID,
+		FirstName: j.Data.FirstName,
+		LastName:  j.Data.LastName,
+		Document:  j.Data.DocumentNumber,
+		Birthdate: j.Data.BirthDate,
+		Number:    j.Data.Number,
+	}, nil
+}
@@ -0,0 +1,38 @@
+package lottery
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestJSONBetMatchesTheDocumentedStructure(t *testing.T) {
+	documented := `{
+		"type": "bet",
+		"data": {
+			"client_id": "1",
+			"first_name": "Santiago Lionel",
+			"last_name": "Lorca",
+			"document_number": "30904465",
+			"birth_date": "1999-03-17",
+			"number": "7574"
+		}
+	}`
+	expected := Bet{Agency: "1", FirstName: "Santiago Lionel", LastName: "Lorca", Document: "30904465", Birthdate: "1999-03-17", Number: "7574"}
+
+	var envelope JSONBet
+	if err := json.Unmarshal([]byte(documented), &envelope); err != nil {
+		t.Fatal(err)
+	}
+	bet, err := envelope.Bet()
+	if err != nil || bet != expected {
+		t.Fatalf("expected %+v, got %+v %v", expected, bet, err)
+	}
+	if NewJSONBet(bet) != envelope {
+		t.Fatalf("expected the bet to wrap back into %+v", envelope)
+	}
+
+	envelope.Type = "winner"
+	if _, err := envelope.Bet(); err == nil {
+		t.Fatal("expected an envelope of another type to fail")
+	}
+}
@@ -0,0 +1,66 @@
+package lottery
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// JSONLinesReader Reads the bets of an agency from JSON lines, one JSONBet
+// per line. The client id of every bet defaults to the agency the reader is
+// created for, and bets of other agencies are invalid
+type JSONLinesReader struct {
+	reader *bufio.Reader
+	agency string
+	line   int
+}
+
+// NewJSONLinesReader Initializes a reader of the bets of the given agency
+func NewJSONLinesReader(r io.Reader, agency string) *JSONLinesReader {
+	return &JSONLinesReader{reader: bufio.NewReader(r), agency: agency}
+}
+
+// Read Returns the bet of the next line. Blank lines are skipped. It
+// returns io.EOF once every line was read and a *RowError for lines that are
+// not a JSONBet or do not hold a valid bet
+func (j *JSONLinesReader) Read() (Bet, error) {
+	for {
+		raw, err := j.reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return Bet{}, err
+		}
+		if len(raw) == 0 && err == io.EOF {
+			return Bet{}, io.EOF
+		}
+		j.line++
+		if raw = bytes.TrimSpace(raw); len(raw) == 0 {
+			continue
+		}
+		bet, parseErr := j.parse(raw)
+		if parseErr != nil {
+			return Bet{}, &RowError{Line: j.line, Row: string(raw), Err: parseErr}
+		}
+		return bet, nil
+	}
+}
+
+// parse Turns a line into a valid bet of the agency
+func (j *JSONLinesReader) parse(raw []byte) (Bet, error) {
+	var envelope JSONBet
+	if err := json.Unmarshal(raw, &envelope); err != nil {
+		return Bet{}, err
+	}
+	if envelope.Data.ClientID == "" {
+		envelope.Data.ClientID = j.agency
+	}
+	bet, err := envelope.Bet()
+	if err != nil {
+		return Bet{}, err
+	}
+	if bet.Agency != j.agency {
+		return Bet{}, fmt.Errorf("client_id %q does not match agency %q", bet.Agency, j.agency)
+	}
+	return bet, bet.Validate()
+}
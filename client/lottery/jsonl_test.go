@@ -0,0 +1,60 @@
+package lottery
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJSONLinesReaderReadsBetsAndReportsInvalidLines(t *testing.T) {
+	lines := strings.Join([]string{
+		`{"type": "bet", "data": {"client_id": "3", "first_name": "Valentina", "last_name": "Vera", "document_number": "30170921", "birth_date": "1982-05-22", "number": "6053"}}`,
+		`{"type": "bet", "data": {"first_name": "Santiago", "last_name": "Álvarez", "document_number": "33936970", "birth_date": "1986-04-25", "number": "7068"}}`,
+		``,
+		`Valentina,Vera,30170921,1982-05-22,6053`,
+		`{"type": "winner", "data": {"document_number": "30170921"}}`,
+		`{"type": "bet", "data": {"client_id": "4", "first_name": "Martina", "last_name": "Borges", "document_number": "21073376", "birth_date": "1994-09-01", "number": "6293"}}`,
+		`{"type": "bet", "data": {"first_name": "Bad", "last_name": "Date", "document_number": "21073376", "birth_date": "1994-13-01", "number": "6293"}}`,
+		`  {"type": "bet", "data": {"first_name": "Camila, Agustina", "last_name": "Pineda", "document_number": "29665629", "birth_date": "2000-01-06", "number": "9999"}}`,
+	}, "\n")
+	reader := NewJSONLinesReader(strings.NewReader(lines), "3")
+
+	var bets []Bet
+	var invalid []int
+	var rows []string
+	for {
+		bet, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var rowErr *RowError
+		if errors.As(err, &rowErr) {
+			invalid = append(invalid, rowErr.Line)
+			rows = append(rows, rowErr.Row)
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		bets = append(bets, bet)
+	}
+
+	if len(bets) != 3 {
+		t.Fatalf("expected 3 bets, got %+v", bets)
+	}
+	expected := Bet{Agency: "3", FirstName: "Santiago", LastName: "Álvarez", Document: "33936970", Birthdate: "1986-04-25", Number: "7068"}
+	if bets[1] != expected {
+		t.Fatalf("expected the client id to default to the agency, got %+v", bets[1])
+	}
+	if bets[2].FirstName != "Camila, Agustina" {
+		t.Fatalf("unexpected last bet %+v", bets[2])
+	}
+	if !reflect.DeepEqual(invalid, []int{4, 5, 6, 7}) {
+		t.Fatalf("unexpected invalid lines %v", invalid)
+	}
+	if len(rows) != 4 || rows[0] != "Valentina,Vera,30170921,1982-05-22,6053" || !strings.HasPrefix(rows[3], `{"type": "bet", "data": {"first_name": "Bad"`) {
+		t.Fatalf("unexpected invalid rows %q", rows)
+	}
+}
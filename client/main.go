@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
 	"os"
 	"strings"
@@ -10,7 +11,13 @@ import (
 	"github.com/pkg/errors"
 	"github.com/spf13/viper"
 
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/auth"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/capture"
 	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/lottery"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/metrics"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/transport"
 )
 
 var log = logging.MustGetLogger("log")
@@ -37,6 +44,84 @@ func InitConfig() (*viper.Viper, error) {
 	v.BindEnv("loop", "period")
 	v.BindEnv("loop", "amount")
 	v.BindEnv("log", "level")
+	v.BindEnv("mode")
+	v.BindEnv("server.timeout")
+	v.BindEnv("retry.attempts")
+	v.BindEnv("retry.backoff")
+	v.BindEnv("dataset.path")
+	v.BindEnv("dataset.format")
+	v.BindEnv("dataset.quarantinePath")
+	v.BindEnv("bet.source")
+	// The single bet of the agency is read from the variables of the
+	// exercise 5, without the CLI_ prefix
+	v.BindEnv("bet.firstName", "NOMBRE")
+	v.BindEnv("bet.lastName", "APELLIDO")
+	v.BindEnv("bet.document", "DOCUMENTO")
+	v.BindEnv("bet.birthdate", "NACIMIENTO")
+	v.BindEnv("bet.number", "NUMERO")
+	v.BindEnv("batch.maxAmount")
+	v.BindEnv("winners.period")
+	v.BindEnv("echo.payload")
+	v.BindEnv("echo.size")
+	v.BindEnv("admin.enabled")
+	v.BindEnv("admin.address")
+	v.BindEnv("control.enabled")
+	v.BindEnv("control.address")
+	v.BindEnv("record.path")
+	v.BindEnv("protocol.capabilities")
+	v.BindEnv("protocol.maxFrameSize")
+	v.BindEnv("protocol.compressionThreshold")
+	v.BindEnv("tls.enabled")
+	v.BindEnv("tls.caFile")
+	v.BindEnv("tls.serverName")
+	v.BindEnv("tls.minVersion")
+	v.BindEnv("tls.certFile")
+	v.BindEnv("tls.keyFile")
+	v.BindEnv("auth.secret")
+	v.BindEnv("auth.secretFile")
+
+	v.SetDefault("mode", common.ModeEcho)
+	v.SetDefault("server.timeout", "10s")
+	v.SetDefault("retry.attempts", 3)
+	v.SetDefault("retry.backoff", "1s")
+	v.SetDefault("dataset.path", "/data/agency.csv")
+	v.SetDefault("dataset.format", lottery.FormatAuto)
+	// Skipped rows are only logged unless a quarantine file is configured
+	v.SetDefault("dataset.quarantinePath", "")
+	v.SetDefault("bet.source", common.BetSourceAuto)
+	v.SetDefault("batch.maxAmount", 100)
+	v.SetDefault("winners.period", "500ms")
+	v.SetDefault("echo.payload", common.PayloadText)
+	v.SetDefault("echo.size", 0)
+
+	// The admin listener is opt-in and only reachable from the same host
+	// unless an explicit address is configured
+	v.SetDefault("admin.enabled", false)
+	v.SetDefault("admin.address", "127.0.0.1:9100")
+
+	// Like the admin listener, the control service is opt-in and local
+	v.SetDefault("control.enabled", false)
+	v.SetDefault("control.address", "127.0.0.1:9101")
+
+	// Frames are only recorded when a capture file is configured
+	v.SetDefault("record.path", "")
+
+	// Every capability the client implements is offered by default
+	v.SetDefault("protocol.capabilities", strings.Join(common.SupportedCapabilities, ","))
+	v.SetDefault("protocol.maxFrameSize", protocol.DefaultMaxSize)
+	v.SetDefault("protocol.compressionThreshold", protocol.DefaultCompressionThreshold)
+	// An empty CA file trusts the authorities of the system and an empty
+	// server name uses the host of server.address
+	v.SetDefault("tls.enabled", false)
+	v.SetDefault("tls.caFile", "")
+	v.SetDefault("tls.serverName", "")
+	v.SetDefault("tls.minVersion", "1.2")
+	// Without a client certificate the agency does not prove its identity
+	v.SetDefault("tls.certFile", "")
+	v.SetDefault("tls.keyFile", "")
+	// Without a secret the frames of the agency are not authenticated
+	v.SetDefault("auth.secret", "")
+	v.SetDefault("auth.secretFile", "")
 
 	// Try to read configuration from config file. If config file
 	// does not exists then ReadInConfig will fail but configuration
@@ -49,13 +134,133 @@ func InitConfig() (*viper.Viper, error) {
 
 	// Parse time.Duration variables and return an error if those variables cannot be parsed
 
+	if _, _, err := transport.Parse(v.GetString("server.address")); err != nil {
+		return nil, errors.Wrapf(err, "Invalid CLI_SERVER_ADDRESS.")
+	}
+	if _, _, err := transport.Parse(v.GetString("control.address")); err != nil {
+		return nil, errors.Wrapf(err, "Invalid CLI_CONTROL_ADDRESS.")
+	}
+
 	if _, err := time.ParseDuration(v.GetString("loop.period")); err != nil {
 		return nil, errors.Wrapf(err, "Could not parse CLI_LOOP_PERIOD env var as time.Duration.")
 	}
+	if _, err := time.ParseDuration(v.GetString("server.timeout")); err != nil {
+		return nil, errors.Wrapf(err, "Could not parse CLI_SERVER_TIMEOUT env var as time.Duration.")
+	}
+	if _, err := time.ParseDuration(v.GetString("retry.backoff")); err != nil {
+		return nil, errors.Wrapf(err, "Could not parse CLI_RETRY_BACKOFF env var as time.Duration.")
+	}
+	if _, err := time.ParseDuration(v.GetString("winners.period")); err != nil {
+		return nil, errors.Wrapf(err, "Could not parse CLI_WINNERS_PERIOD env var as time.Duration.")
+	}
+
+	for _, capability := range parseList(v.GetString("protocol.capabilities")) {
+		if !protocol.Has(protocol.Capabilities, capability) {
+			return nil, errors.Errorf("Invalid capability %q in CLI_PROTOCOL_CAPABILITIES, expected some of %v.", capability, strings.Join(protocol.Capabilities, ","))
+		}
+	}
+
+	if v.GetInt("protocol.maxFrameSize") < protocol.MaxPayloadSize {
+		return nil, errors.Errorf("Invalid CLI_PROTOCOL_MAXFRAMESIZE %v, it must allow at least a full frame of %v bytes.", v.GetString("protocol.maxFrameSize"), protocol.MaxPayloadSize)
+	}
+	if v.GetInt("protocol.compressionThreshold") < 0 {
+		return nil, errors.Errorf("Invalid CLI_PROTOCOL_COMPRESSIONTHRESHOLD %v, it must not be negative.", v.GetString("protocol.compressionThreshold"))
+	}
+
+	if v.GetString("auth.secret") != "" && v.GetString("auth.secretFile") != "" {
+		return nil, errors.Errorf("CLI_AUTH_SECRET and CLI_AUTH_SECRETFILE are exclusive, set only one of them.")
+	}
+
+	if _, err := common.ParseTLSVersion(v.GetString("tls.minVersion")); err != nil {
+		return nil, errors.Wrapf(err, "Invalid CLI_TLS_MINVERSION.")
+	}
+
+	if !protocol.Has(lottery.Formats, v.GetString("dataset.format")) {
+		return nil, errors.Errorf("Invalid CLI_DATASET_FORMAT %q, expected one of %v.", v.GetString("dataset.format"), strings.Join(lottery.Formats, ","))
+	}
+	if !protocol.Has(common.BetSources, v.GetString("bet.source")) {
+		return nil, errors.Errorf("Invalid CLI_BET_SOURCE %q, expected one of %v.", v.GetString("bet.source"), strings.Join(common.BetSources, ","))
+	}
+
+	switch v.GetString("mode") {
+	case common.ModeEcho, common.ModeBets:
+	default:
+		return nil, errors.Errorf("Invalid CLI_MODE %q, expected %v or %v.", v.GetString("mode"), common.ModeEcho, common.ModeBets)
+	}
+
+	// The single bet would be silently ignored by the echo client
+	if v.GetString("mode") == common.ModeEcho && strings.Join(singleBetValues(v), "") != "" {
+		return nil, errors.Errorf("%v only apply in %v mode, set CLI_MODE=%v.", strings.Join(common.SingleBetVars, ", "), common.ModeBets, common.ModeBets)
+	}
 
 	return v, nil
 }
 
+// parseList Splits a comma separated configuration value, ignoring blanks
+func parseList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// InitTLS Builds the TLS configuration of the connections to the server, or
+// nil when TLS is disabled
+func InitTLS(v *viper.Viper) (*tls.Config, error) {
+	config := common.TLSConfig{
+		Enabled:    v.GetBool("tls.enabled"),
+		CAFile:     v.GetString("tls.caFile"),
+		ServerName: v.GetString("tls.serverName"),
+		MinVersion: v.GetString("tls.minVersion"),
+		CertFile:   v.GetString("tls.certFile"),
+		KeyFile:    v.GetString("tls.keyFile"),
+	}
+	if !config.Enabled {
+		return nil, nil
+	}
+	return config.ClientTLS(v.GetString("server.address"), v.GetString("id"))
+}
+
+// InitAuthSecret Returns the secret the frames of the agency are
+// authenticated with, read from the configuration or from a file, or nil when
+// authentication is disabled
+func InitAuthSecret(v *viper.Viper) ([]byte, error) {
+	if path := v.GetString("auth.secretFile"); path != "" {
+		return auth.LoadSecret(path)
+	}
+	if secret := v.GetString("auth.secret"); secret != "" {
+		return []byte(secret), nil
+	}
+	return nil, nil
+}
+
+// InitSingleBet Returns the single bet the agency sends instead of its
+// dataset, or nil when the dataset must be sent. The bet source decides
+// which one is used when both are present
+func InitSingleBet(v *viper.Viper) (*lottery.Bet, error) {
+	return common.SingleBet(
+		v.GetString("bet.source"),
+		v.GetString("dataset.path"),
+		v.GetString("id"),
+		singleBetValues(v),
+	)
+}
+
+// singleBetValues Values of the single bet, in the order of
+// common.SingleBetVars
+func singleBetValues(v *viper.Viper) []string {
+	return []string{
+		v.GetString("bet.firstName"),
+		v.GetString("bet.lastName"),
+		v.GetString("bet.document"),
+		v.GetString("bet.birthdate"),
+		v.GetString("bet.number"),
+	}
+}
+
 // InitLogger Receives the log level to be set in go-logging as a string. This method
 // parses the string and set the level to the logger. If the level string is not
 // valid an error is returned
@@ -66,50 +271,253 @@ func InitLogger(logLevel string) error {
 	)
 	backendFormatter := logging.NewBackendFormatter(baseBackend, format)
 
-	backendLeveled := logging.AddModuleLevel(backendFormatter)
 	logLevelCode, err := logging.LogLevel(logLevel)
 	if err != nil {
 		return err
 	}
-	backendLeveled.SetLevel(logLevelCode, "")
 
-	// Set the backends to be used.
-	logging.SetBackend(backendLeveled)
+	// Set the backends to be used. The level is set on the default backend,
+	// so the control service can change it later
+	logging.SetBackend(backendFormatter)
+	logging.SetLevel(logLevelCode, "")
 	return nil
 }
 
 // PrintConfig Print all the configuration parameters of the program.
 // For debugging purposes only
 func PrintConfig(v *viper.Viper) {
-	log.Infof("action: config | result: success | client_id: %s | server_address: %s | loop_amount: %v | loop_period: %v | log_level: %s",
+	if v.GetString("mode") == common.ModeBets {
+		log.Infof("action: config | result: success | client_id: %s | server_address: %s | mode: %s | bet_source: %s | dataset_path: %s | dataset_format: %s | quarantine_path: %s | batch_max_amount: %v | server_timeout: %v | retry_attempts: %v | retry_backoff: %v | record_path: %s | capabilities: %s | max_frame_size: %v | compression_threshold: %v | tls: %v | auth: %v | log_level: %s",
+			v.GetString("id"),
+			v.GetString("server.address"),
+			v.GetString("mode"),
+			v.GetString("bet.source"),
+			v.GetString("dataset.path"),
+			v.GetString("dataset.format"),
+			v.GetString("dataset.quarantinePath"),
+			v.GetInt("batch.maxAmount"),
+			v.GetDuration("server.timeout"),
+			v.GetInt("retry.attempts"),
+			v.GetDuration("retry.backoff"),
+			v.GetString("record.path"),
+			strings.Join(parseList(v.GetString("protocol.capabilities")), ","),
+			v.GetInt("protocol.maxFrameSize"),
+			v.GetInt("protocol.compressionThreshold"),
+			v.GetBool("tls.enabled"),
+			v.GetString("auth.secret") != "" || v.GetString("auth.secretFile") != "",
+			v.GetString("log.level"),
+		)
+		return
+	}
+	log.Infof("action: config | result: success | client_id: %s | server_address: %s | loop_amount: %v | loop_period: %v | tls: %v | log_level: %s | echo_payload: %s | echo_size: %v",
 		v.GetString("id"),
 		v.GetString("server.address"),
 		v.GetInt("loop.amount"),
 		v.GetDuration("loop.period"),
+		v.GetBool("tls.enabled"),
 		v.GetString("log.level"),
+		v.GetString("echo.payload"),
+		v.GetInt("echo.size"),
 	)
 }
 
+// InitHealth Builds the readiness conditions of the client: the server must
+// be reachable and answer the protocol handshake and, in bets mode, the
+// dataset of the agency must be readable. The server is probed with the same
+// TLS configuration the client connects with
+func InitHealth(v *viper.Viper, tlsConfig *tls.Config, secret []byte) *common.Health {
+	health := common.NewHealth()
+	if v.GetString("mode") == common.ModeBets {
+		// An agency that sends its single bet has no dataset to read
+		if single, err := InitSingleBet(v); err != nil || single == nil {
+			health.AddReadinessCheck("dataset", func() error {
+				return common.CheckDataset(v.GetString("dataset.path"))
+			})
+		}
+		health.AddReadinessCheck("server", func() error {
+			return common.ProbeLotteryServer(
+				v.GetString("server.address"),
+				tlsConfig,
+				secret,
+				v.GetString("id"),
+				common.DefaultProbeTimeout,
+			)
+		})
+		return health
+	}
+	health.AddReadinessCheck("server", func() error {
+		return common.ProbeServer(
+			v.GetString("server.address"),
+			tlsConfig,
+			v.GetString("id"),
+			common.DefaultProbeTimeout,
+		)
+	})
+	return health
+}
+
+// RunHealthcheck Performs the readiness probe once and returns the exit code
+// of the process, so it can be used as a Docker HEALTHCHECK
+func RunHealthcheck(v *viper.Viper, tlsConfig *tls.Config, secret []byte) int {
+	if err := InitHealth(v, tlsConfig, secret).Ready(); err != nil {
+		log.Errorf("action: healthcheck | result: fail | client_id: %v | error: %v", v.GetString("id"), err)
+		return 1
+	}
+	log.Infof("action: healthcheck | result: success | client_id: %v", v.GetString("id"))
+	return 0
+}
+
+// startControl Starts the control service of the client on the address
+func startControl(address string, client *common.Client) (*common.ControlServer, error) {
+	control, err := common.NewControlServer(address, client)
+	if err != nil {
+		return nil, err
+	}
+	if err := control.Start(); err != nil {
+		return nil, err
+	}
+	return control, nil
+}
+
 func main() {
 	v, err := InitConfig()
 	if err != nil {
 		log.Criticalf("%s", err)
+		os.Exit(1)
 	}
 
 	if err := InitLogger(v.GetString("log.level")); err != nil {
 		log.Criticalf("%s", err)
+		os.Exit(1)
+	}
+
+	// Without a valid TLS configuration no connection to the server could be
+	// secured, and with a certificate of another agency the client would
+	// claim an identity it cannot prove, so in both cases it does not start
+	tlsConfig, err := InitTLS(v)
+	var identityErr *common.IdentityError
+	if errors.As(err, &identityErr) {
+		log.Criticalf("action: identity | result: fail | client_id: %v | certificate_agency: %v | error: %v", v.GetString("id"), identityErr.Certificate, err)
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Criticalf("action: connect | result: fail | client_id: %v | error: tls config: %v", v.GetString("id"), err)
+		os.Exit(1)
+	}
+
+	secret, err := InitAuthSecret(v)
+	if err != nil {
+		log.Criticalf("action: auth | result: fail | client_id: %v | error: %v", v.GetString("id"), err)
+		os.Exit(1)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "healthcheck" {
+		os.Exit(RunHealthcheck(v, tlsConfig, secret))
 	}
 
 	// Print program config with debugging purposes
 	PrintConfig(v)
 
+	var single *lottery.Bet
+	if v.GetString("mode") == common.ModeBets {
+		if single, err = InitSingleBet(v); err != nil {
+			log.Criticalf("action: bet_source | result: fail | client_id: %v | error: %v", v.GetString("id"), err)
+			os.Exit(1)
+		}
+	}
+
 	clientConfig := common.ClientConfig{
 		ServerAddress: v.GetString("server.address"),
 		ID:            v.GetString("id"),
+		Mode:          v.GetString("mode"),
 		LoopAmount:    v.GetInt("loop.amount"),
 		LoopPeriod:    v.GetDuration("loop.period"),
+		Echo: common.EchoConfig{
+			Payload: v.GetString("echo.payload"),
+			Size:    v.GetInt("echo.size"),
+		},
+		Bets: common.BetsConfig{
+			DatasetPath:    v.GetString("dataset.path"),
+			DatasetFormat:  v.GetString("dataset.format"),
+			Single:         single,
+			BatchMaxAmount: v.GetInt("batch.maxAmount"),
+			WinnersPeriod:  v.GetDuration("winners.period"),
+		},
+		Timeout:              v.GetDuration("server.timeout"),
+		RetryAttempts:        v.GetInt("retry.attempts"),
+		RetryBackoff:         v.GetDuration("retry.backoff"),
+		Capabilities:         parseList(v.GetString("protocol.capabilities")),
+		MaxFrameSize:         v.GetInt("protocol.maxFrameSize"),
+		AuthSecret:           secret,
+		CompressionThreshold: v.GetInt("protocol.compressionThreshold"),
+	}
+
+	registry := metrics.NewRegistry()
+	clientMetrics := common.NewClientMetrics(registry)
+
+	var admin *common.AdminServer
+	if v.GetBool("admin.enabled") {
+		admin = common.NewAdminServer(v.GetString("admin.address"), registry, InitHealth(v, tlsConfig, secret))
+		if err := admin.Start(); err != nil {
+			log.Criticalf("%s", err)
+			admin = nil
+		}
+	}
+
+	// A capture that cannot be created is reported but does not prevent the
+	// agency from sending its bets
+	var options []common.Option
+	if tlsConfig != nil {
+		options = append(options, common.WithTLS(tlsConfig))
+	}
+	var recording *os.File
+	if path := v.GetString("record.path"); path != "" {
+		if recording, err = os.Create(path); err != nil {
+			log.Errorf("action: record | result: fail | client_id: %v | error: %v", v.GetString("id"), err)
+		} else {
+			options = append(options, common.WithRecorder(capture.NewWriter(recording)))
+		}
+	}
+
+	// Unlike the capture, the rows skipped would be lost without their
+	// quarantine, so the client does not start if it cannot be opened
+	var quarantine *os.File
+	if path := v.GetString("dataset.quarantinePath"); path != "" && v.GetString("mode") == common.ModeBets {
+		if quarantine, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+			log.Criticalf("action: cuarentena | result: fail | client_id: %v | error: %v", v.GetString("id"), err)
+			os.Exit(1)
+		}
+		options = append(options, common.WithQuarantine(common.NewQuarantine(quarantine)))
 	}
 
-	client := common.NewClient(clientConfig)
-	client.StartClientLoop()
+	client := common.NewClient(clientConfig, clientMetrics, options...)
+
+	// The control service drives the bets pipeline, so it is only started in
+	// bets mode. Failing to start it does not stop the agency either
+	var control *common.ControlServer
+	if v.GetBool("control.enabled") {
+		if v.GetString("mode") != common.ModeBets {
+			log.Warningf("action: control_serve | result: fail | client_id: %v | error: only available in %v mode", v.GetString("id"), common.ModeBets)
+		} else if control, err = startControl(v.GetString("control.address"), client); err != nil {
+			log.Criticalf("%s", err)
+		}
+	}
+
+	err = client.StartClientLoop()
+
+	if control != nil {
+		control.Close()
+	}
+	if admin != nil {
+		admin.Close()
+	}
+	if quarantine != nil {
+		quarantine.Close()
+	}
+	if recording != nil {
+		recording.Close()
+	}
+	if err != nil {
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCounterIsRenderedWithHelpAndType(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_total", "Things counted.")
+	c.Inc()
+	c.Add(2)
+
+	var out bytes.Buffer
+	if _, err := r.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "# HELP test_total Things counted.\n" +
+		"# TYPE test_total counter\n" +
+		"test_total 3\n"
+	if out.String() != expected {
+		t.Fatalf("unexpected exposition:\n%s", out.String())
+	}
+}
+
+func TestHistogramBucketsAreCumulative(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogram("test_seconds", "Durations.", []float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(0.1)
+	h.Observe(0.5)
+	h.Observe(3)
+
+	var out bytes.Buffer
+	r.WriteTo(&out)
+
+	expected := "# HELP test_seconds Durations.\n" +
+		"# TYPE test_seconds histogram\n" +
+		"test_seconds_bucket{le=\"0.1\"} 2\n" +
+		"test_seconds_bucket{le=\"1\"} 3\n" +
+		"test_seconds_bucket{le=\"+Inf\"} 4\n" +
+		"test_seconds_sum 3.65\n" +
+		"test_seconds_count 4\n"
+	if out.String() != expected {
+		t.Fatalf("unexpected exposition:\n%s", out.String())
+	}
+}
+
+func TestRegisteringTheSameNameTwicePanics(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("dup_total", "first")
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic")
+		}
+	}()
+	r.NewCounter("dup_total", "second")
+}
+
+func TestRegistryServesTextFormat(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("served_total", "Served.").Inc()
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != ContentType {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	if !strings.Contains(rec.Body.String(), "served_total 1\n") {
+		t.Fatalf("counter missing from body:\n%s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,239 @@
+// Package pki issues the certificates used to secure the connections between
+// the agencies and the server: a local certificate authority and the
+// certificates it signs, encoded as PEM so they can be written to files and
+// loaded with crypto/tls.
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultValidity Time certificates are valid for, unless configured
+const DefaultValidity = 365 * 24 * time.Hour
+
+// Authority Certificate authority that signs the certificates of the server
+type Authority struct {
+	Certificate *x509.Certificate
+	Key         crypto.Signer
+	// Validity Time the certificates it issues are valid for. Zero means
+	// DefaultValidity
+	Validity time.Duration
+}
+
+// NewAuthority Creates a self-signed certificate authority
+func NewAuthority(name string) (*Authority, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template, err := newTemplate(name, DefaultValidity)
+	if err != nil {
+		return nil, err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &Authority{Certificate: certificate, Key: key}, nil
+}
+
+// LoadAuthority Reads an authority written with WriteFiles
+func LoadAuthority(certFile string, keyFile string) (*Authority, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok || !certificate.IsCA {
+		return nil, errors.New("pki: the certificate is not a certificate authority")
+	}
+	return &Authority{Certificate: certificate, Key: key}, nil
+}
+
+// CertPEM The certificate of the authority, which clients must trust
+func (a *Authority) CertPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: a.Certificate.Raw})
+}
+
+// Pool Certificate pool that trusts the authority
+func (a *Authority) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(a.Certificate)
+	return pool
+}
+
+// WriteFiles Writes the certificate and the key of the authority as PEM
+func (a *Authority) WriteFiles(certFile string, keyFile string) error {
+	return writePair(certFile, keyFile, a.Certificate.Raw, a.Key)
+}
+
+// IssueServer Issues a certificate for a server reachable at the given host
+// names or IP addresses
+func (a *Authority) IssueServer(hosts ...string) (*Issued, error) {
+	if len(hosts) == 0 {
+		return nil, errors.New("pki: a server certificate needs at least one host")
+	}
+	template, err := newTemplate(hosts[0], a.validity())
+	if err != nil {
+		return nil, err
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	return a.issue(template)
+}
+
+// IssueAgency Issues the client certificate of an agency. Its common name and
+// its only DNS name are AgencyName(agency), which is how the server learns
+// the agency of a connection
+func (a *Authority) IssueAgency(agency string) (*Issued, error) {
+	if n, err := strconv.Atoi(agency); err != nil || n < 1 {
+		return nil, fmt.Errorf("pki: invalid agency %q, expected a positive number", agency)
+	}
+	template, err := newTemplate(AgencyName(agency), a.validity())
+	if err != nil {
+		return nil, err
+	}
+	template.DNSNames = []string{AgencyName(agency)}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	return a.issue(template)
+}
+
+// AgencyName Name that identifies an agency in its certificate
+func AgencyName(agency string) string {
+	return agencyPrefix + agency
+}
+
+const agencyPrefix = "agency-"
+
+// AgencyOf Returns the agency a client certificate was issued to, read from
+// its common name or its DNS names. Every name that identifies an agency
+// must identify the same one
+func AgencyOf(certificate *x509.Certificate) (string, error) {
+	names := append([]string{certificate.Subject.CommonName}, certificate.DNSNames...)
+	agency := ""
+	for _, name := range names {
+		if !strings.HasPrefix(name, agencyPrefix) {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimPrefix(name, agencyPrefix))
+		if err != nil || n < 1 {
+			return "", fmt.Errorf("pki: invalid agency name %q in the certificate", name)
+		}
+		if agency != "" && agency != strconv.Itoa(n) {
+			return "", fmt.Errorf("pki: the certificate names agencies %v and %v", agency, n)
+		}
+		agency = strconv.Itoa(n)
+	}
+	if agency == "" {
+		return "", errors.New("pki: the certificate does not name an agency")
+	}
+	return agency, nil
+}
+
+func (a *Authority) validity() time.Duration {
+	if a.Validity <= 0 {
+		return DefaultValidity
+	}
+	return a.Validity
+}
+
+// issue Signs a certificate for a new key
+func (a *Authority) issue(template *x509.Certificate) (*Issued, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, a.Certificate, key.Public(), a.Key)
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &Issued{Certificate: certificate, Key: key}, nil
+}
+
+// Issued A certificate signed by an authority along with its key
+type Issued struct {
+	Certificate *x509.Certificate
+	Key         crypto.Signer
+}
+
+// TLSCertificate The certificate and key ready to be served by crypto/tls
+func (i *Issued) TLSCertificate() tls.Certificate {
+	return tls.Certificate{
+		Certificate: [][]byte{i.Certificate.Raw},
+		PrivateKey:  i.Key,
+		Leaf:        i.Certificate,
+	}
+}
+
+// WriteFiles Writes the certificate and the key as PEM
+func (i *Issued) WriteFiles(certFile string, keyFile string) error {
+	return writePair(certFile, keyFile, i.Certificate.Raw, i.Key)
+}
+
+func newTemplate(commonName string, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	// Backdating tolerates small clock differences between the hosts
+	now := time.Now().Add(-time.Hour)
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    now,
+		NotAfter:     now.Add(validity),
+	}, nil
+}
+
+// writePair Writes a certificate readable by everyone and a key readable
+// only by its owner
+func writePair(certFile string, keyFile string, der []byte, key crypto.Signer) error {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600)
+}
@@ -0,0 +1,133 @@
+package pki
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"path/filepath"
+	"testing"
+)
+
+func TestServerCertificatesVerifyAgainstTheAuthority(t *testing.T) {
+	authority, err := NewAuthority("test CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	issued, err := authority.IssueServer("server", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{"server", "127.0.0.1"} {
+		_, err := issued.Certificate.Verify(x509.VerifyOptions{
+			DNSName:   host,
+			Roots:     authority.Pool(),
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		})
+		if err != nil {
+			t.Fatalf("%v: %v", host, err)
+		}
+	}
+	if err := issued.Certificate.VerifyHostname("client1"); err == nil {
+		t.Fatal("expected the certificate not to be valid for other hosts")
+	}
+
+	other, err := NewAuthority("other CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := issued.Certificate.Verify(x509.VerifyOptions{DNSName: "server", Roots: other.Pool()}); err == nil {
+		t.Fatal("expected another authority not to trust the certificate")
+	}
+
+	if _, err := authority.IssueServer(); err == nil {
+		t.Fatal("expected a server certificate without hosts to fail")
+	}
+}
+
+func TestAuthorityRoundTripsThroughFiles(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem")
+	authority, err := NewAuthority("test CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := authority.WriteFiles(certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadAuthority(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Certificate.Equal(authority.Certificate) {
+		t.Fatal("loaded a different certificate")
+	}
+
+	// The loaded authority keeps signing certificates the original trusts
+	issued, err := loaded.IssueServer("server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := issued.Certificate.Verify(x509.VerifyOptions{DNSName: "server", Roots: authority.Pool()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A leaf certificate cannot act as an authority
+	leafCert, leafKey := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem")
+	if err := issued.WriteFiles(leafCert, leafKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadAuthority(leafCert, leafKey); err == nil {
+		t.Fatal("expected a server certificate to be rejected as an authority")
+	}
+}
+
+func TestAgencyCertificatesNameTheirAgency(t *testing.T) {
+	authority, err := NewAuthority("test CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	issued, err := authority.IssueAgency("3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := issued.Certificate.Verify(x509.VerifyOptions{
+		Roots:     authority.Pool(),
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if agency, err := AgencyOf(issued.Certificate); err != nil || agency != "3" {
+		t.Fatalf("expected agency 3, got %q %v", agency, err)
+	}
+	for _, agency := range []string{"", "0", "-1", "x"} {
+		if _, err := authority.IssueAgency(agency); err == nil {
+			t.Fatalf("expected agency %q to be rejected", agency)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		commonName string
+		dnsNames   []string
+		agency     string
+	}{
+		{name: "common name", commonName: "agency-4", agency: "4"},
+		{name: "subject alternative name", commonName: "client", dnsNames: []string{"client4", "agency-4"}, agency: "4"},
+		{name: "leading zeros", commonName: "agency-04", agency: "4"},
+		{name: "both agree", commonName: "agency-4", dnsNames: []string{"agency-04"}, agency: "4"},
+		{name: "conflicting names", commonName: "agency-4", dnsNames: []string{"agency-5"}},
+		{name: "not a number", commonName: "agency-four"},
+		{name: "no agency", commonName: "server", dnsNames: []string{"server"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			certificate := &x509.Certificate{Subject: pkix.Name{CommonName: test.commonName}, DNSNames: test.dnsNames}
+			agency, err := AgencyOf(certificate)
+			if test.agency == "" && err == nil {
+				t.Fatalf("expected an error, got agency %q", agency)
+			}
+			if test.agency != "" && (err != nil || agency != test.agency) {
+				t.Fatalf("expected agency %q, got %q %v", test.agency, agency, err)
+			}
+		})
+	}
+}
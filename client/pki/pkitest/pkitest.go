@@ -0,0 +1,72 @@
+// Package pkitest starts TLS listeners for tests, serving certificates issued
+// on the fly by a throwaway local authority.
+package pkitest
+
+import (
+	"crypto/tls"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/pki"
+)
+
+// Listener TLS listener on a loopback port
+type Listener struct {
+	net.Listener
+	// Authority Issuer of the certificate of the listener
+	Authority *pki.Authority
+	// CAFile PEM file with the certificate of the authority, for the
+	// clients to trust it
+	CAFile string
+	// Config TLS configuration the listener serves with
+	Config *tls.Config
+}
+
+// Listen Starts a TLS listener on a random loopback port. Its certificate is
+// valid for 127.0.0.1 and localhost and is issued by a new authority. The
+// options can change the TLS configuration before the listener starts, like
+// its versions or client authentication. The listener is closed with the test
+func Listen(t testing.TB, options ...func(*tls.Config)) *Listener {
+	t.Helper()
+	authority, err := pki.NewAuthority("Lotería Nacional test CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ListenWith(t, authority, options...)
+}
+
+// ListenWith Starts a TLS listener like Listen, with a certificate issued by
+// the given authority
+func ListenWith(t testing.TB, authority *pki.Authority, options ...func(*tls.Config)) *Listener {
+	t.Helper()
+	issued, err := authority.IssueServer("127.0.0.1", "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, authority.CertPEM(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &tls.Config{Certificates: []tls.Certificate{issued.TLSCertificate()}}
+	for _, option := range options {
+		option(config)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	return &Listener{Listener: listener, Authority: authority, CAFile: caFile, Config: config}
+}
+
+// RequireClientCerts Option that makes the listener require a client
+// certificate issued by the authority, as with mutual TLS
+func RequireClientCerts(authority *pki.Authority) func(*tls.Config) {
+	return func(config *tls.Config) {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		config.ClientCAs = authority.Pool()
+	}
+}
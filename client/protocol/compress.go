@@ -0,0 +1,72 @@
+package protocol
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// FlagCompressed Bit of the type byte set on the frames whose payload is
+// compressed with DEFLATE (RFC 1951). Peers only compress payloads once both
+// enabled CapabilityCompression in the handshake
+const FlagCompressed = 0x40
+
+// DefaultCompressionThreshold Payloads smaller than this amount of bytes are
+// not worth compressing, unless configured otherwise
+const DefaultCompressionThreshold = 256
+
+// ErrInvalidCompression A compressed payload that cannot be decompressed
+var ErrInvalidCompression = errors.New("protocol: invalid compressed payload")
+
+// Compressed Returns true if the payload of the frame is compressed
+func (f Frame) Compressed() bool {
+	return f.Type&FlagCompressed != 0
+}
+
+// Compress Returns the frame with its payload compressed and FlagCompressed
+// set. Payloads smaller than threshold, or that do not shrink, are returned
+// as they are. A threshold of zero means DefaultCompressionThreshold
+func Compress(frame Frame, threshold int) Frame {
+	if threshold <= 0 {
+		threshold = DefaultCompressionThreshold
+	}
+	if frame.Compressed() || len(frame.Payload) < threshold {
+		return frame
+	}
+	var buf bytes.Buffer
+	writer, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return frame
+	}
+	writer.Write(frame.Payload)
+	writer.Close()
+	if buf.Len() >= len(frame.Payload) {
+		return frame
+	}
+	return Frame{Type: frame.Type | FlagCompressed, Payload: buf.Bytes()}
+}
+
+// Decompress Returns the frame with its payload decompressed and without
+// FlagCompressed. Payloads that decompress to more than maxSize bytes fail
+// with ErrFrameTooLarge before being fully decompressed. Frames without the
+// flag are returned as they are. A maxSize of zero means DefaultMaxSize
+func Decompress(frame Frame, maxSize int) (Frame, error) {
+	if !frame.Compressed() {
+		return frame, nil
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	reader := flate.NewReader(bytes.NewReader(frame.Payload))
+	defer reader.Close()
+	payload, err := io.ReadAll(io.LimitReader(reader, int64(maxSize)+1))
+	if err != nil {
+		return Frame{}, fmt.Errorf("%w: %v", ErrInvalidCompression, err)
+	}
+	if len(payload) > maxSize {
+		return Frame{}, fmt.Errorf("%w: more than %d bytes of decompressed payload", ErrFrameTooLarge, maxSize)
+	}
+	return Frame{Type: frame.Type &^ FlagCompressed, Payload: payload}, nil
+}
@@ -0,0 +1,109 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCompressRoundTrip(t *testing.T) {
+	payload := []byte(strings.Repeat("1|Santiago|Lorca|30904465|1999-03-17|7574\n", 100))
+	frame := Compress(Frame{Type: TypeBets, Payload: payload}, 0)
+	if !frame.Compressed() || frame.Type&^FlagCompressed != TypeBets {
+		t.Fatalf("expected a compressed bets frame, got type %v", frame.Type)
+	}
+	if len(frame.Payload) >= len(payload)/10 {
+		t.Fatalf("expected repetitive bets to compress well, got %v bytes out of %v", len(frame.Payload), len(payload))
+	}
+	if frame.Type.String() != "bets(compressed)" {
+		t.Fatalf("unexpected type name %v", frame.Type)
+	}
+
+	decompressed, err := Decompress(frame, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decompressed.Type != TypeBets || !bytes.Equal(decompressed.Payload, payload) {
+		t.Fatal("decompressing did not return the original frame")
+	}
+}
+
+func TestCompressSkipsPayloadsNotWorthIt(t *testing.T) {
+	small := Frame{Type: TypeBets, Payload: bytes.Repeat([]byte("a"), 99)}
+	if Compress(small, 100).Compressed() {
+		t.Fatal("expected a payload below the threshold to be left uncompressed")
+	}
+	if !Compress(Frame{Type: TypeBets, Payload: bytes.Repeat([]byte("a"), 100)}, 100).Compressed() {
+		t.Fatal("expected a payload at the threshold to be compressed")
+	}
+
+	random := make([]byte, 4096)
+	rand.Read(random)
+	if frame := Compress(Frame{Type: TypeBets, Payload: random}, 0); frame.Compressed() || !bytes.Equal(frame.Payload, random) {
+		t.Fatal("expected a payload that does not shrink to be left uncompressed")
+	}
+
+	plain := Frame{Type: TypeAck, Payload: []byte("1|2")}
+	if frame, err := Decompress(plain, 0); err != nil || frame.Type != plain.Type || !bytes.Equal(frame.Payload, plain.Payload) {
+		t.Fatalf("expected an uncompressed frame to be returned as is, got %+v %v", frame, err)
+	}
+}
+
+func TestDecompressErrors(t *testing.T) {
+	bomb := Compress(Frame{Type: TypeWinners, Payload: bytes.Repeat([]byte("0"), 1<<20)}, 0)
+	if _, err := Decompress(bomb, 1<<20-1); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("expected a payload above the maximum to be too large, got %v", err)
+	}
+	if _, err := Decompress(bomb, 1<<20); err != nil {
+		t.Fatalf("expected a payload of exactly the maximum to decompress, got %v", err)
+	}
+
+	corrupt := Frame{Type: TypeBets | FlagCompressed, Payload: []byte("not deflate")}
+	if _, err := Decompress(corrupt, 0); !errors.Is(err, ErrInvalidCompression) {
+		t.Fatalf("expected an invalid compressed payload, got %v", err)
+	}
+	if _, err := Decode(corrupt); !errors.Is(err, ErrInvalidCompression) {
+		t.Fatalf("expected Decode to fail on an invalid compressed payload, got %v", err)
+	}
+}
+
+func TestCompressedPayloadsTravelInContinuationFrames(t *testing.T) {
+	documents := make([]string, 0, 20000)
+	for i := 0; i < 20000; i++ {
+		documents = append(documents, strings.Repeat(string(rune('0'+i%10)), 8))
+	}
+	frame, err := Encode(Winners{Documents: documents})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Random noise keeps the compressed payload above a single frame
+	noise := make([]byte, 2*MaxPayloadSize)
+	rand.Read(noise)
+	frame.Payload = append(frame.Payload, noise...)
+	compressed := Compress(frame, 0)
+	if !compressed.Compressed() || len(compressed.Payload) <= MaxPayloadSize {
+		t.Fatalf("expected a compressed payload larger than a frame, got %v bytes", len(compressed.Payload))
+	}
+
+	var buf bytes.Buffer
+	framing := Framing{Continuation: true}
+	if err := framing.WriteFrame(&buf, compressed); err != nil {
+		t.Fatal(err)
+	}
+	if first := buf.Bytes()[HeaderSize]; first != byte(TypeWinners)|FlagCompressed|FlagContinued {
+		t.Fatalf("expected every fragment to carry the compressed flag, got type byte %#x", first)
+	}
+	read, err := framing.ReadFrame(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decompressed, err := Decompress(read, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decompressed.Type != TypeWinners || !bytes.Equal(decompressed.Payload, frame.Payload) {
+		t.Fatal("the payload changed on the wire")
+	}
+}
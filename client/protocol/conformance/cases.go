@@ -0,0 +1,149 @@
+package conformance
+
+import (
+	"math"
+	"strings"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/lottery"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+)
+
+// Case A message the vectors must cover
+type Case struct {
+	Name        string
+	Description string
+	Message     protocol.Message
+}
+
+var santiago = lottery.Bet{
+	Agency:    "1",
+	FirstName: "Santiago Lionel",
+	LastName:  "Lorca",
+	Document:  "30904465",
+	Birthdate: "1999-03-17",
+	Number:    "7574",
+}
+
+// Cases Every message covered by the vectors. New message types or edge
+// cases are added here and written to the vectors with cmd/protovectors
+func Cases() []Case {
+	return []Case{
+		{
+			Name:        "bets_single",
+			Description: "Batch with a single bet",
+			Message:     protocol.Bets{BatchID: 1, Bets: []lottery.Bet{santiago}},
+		},
+		{
+			Name:        "bets_multiple",
+			Description: "Bets are sent one per line, after the batch id",
+			Message: protocol.Bets{BatchID: 2, Bets: []lottery.Bet{
+				santiago,
+				{Agency: "1", FirstName: "Valentina", LastName: "Vera", Document: "30170921", Birthdate: "1982-05-22", Number: "6053"},
+				{Agency: "1", FirstName: "Martina", LastName: "Borges", Document: "21073376", Birthdate: "1994-09-01", Number: "0"},
+			}},
+		},
+		{
+			Name:        "bets_accents",
+			Description: "Names are UTF-8, lengths count bytes and not characters",
+			Message: protocol.Bets{BatchID: 3, Bets: []lottery.Bet{
+				{Agency: "5", FirstName: "José María", LastName: "Álvarez Muñoz", Document: "33936970", Birthdate: "1986-04-25", Number: "7068"},
+				{Agency: "5", FirstName: "Ñandú", LastName: "Peña Güemes", Document: "29665629", Birthdate: "2000-01-06", Number: "9999"},
+			}},
+		},
+		{
+			Name:        "bets_empty_batch",
+			Description: "A batch without bets only carries its id",
+			Message:     protocol.Bets{BatchID: 4, Bets: []lottery.Bet{}},
+		},
+		{
+			Name:        "bets_max_batch_id",
+			Description: "Batch ids are unsigned 32 bit integers",
+			Message:     protocol.Bets{BatchID: math.MaxUint32, Bets: []lottery.Bet{santiago}},
+		},
+		{
+			Name:        "bets_max_frame",
+			Description: "The largest frame: a payload of exactly 65534 bytes, padded with two byte characters",
+			Message:     protocol.Bets{BatchID: 1, Bets: []lottery.Bet{paddedBet(protocol.MaxPayloadSize)}},
+		},
+		{
+			Name:        "ack",
+			Description: "Every bet of the batch was stored",
+			Message:     protocol.Ack{BatchID: 1, Count: 3},
+		},
+		{
+			Name:        "ack_delivery_ended",
+			Description: "The end of the delivery is acknowledged with batch id 0",
+			Message:     protocol.Ack{},
+		},
+		{
+			Name:        "reject_single",
+			Description: "A single bet of the batch was rejected",
+			Message:     protocol.Reject{BatchID: 2, Indexes: []int{1}},
+		},
+		{
+			Name:        "reject_multiple",
+			Description: "Indexes of the rejected bets are separated by commas",
+			Message:     protocol.Reject{BatchID: 7, Indexes: []int{0, 3, 99}},
+		},
+		{
+			Name:        "delivery_ended",
+			Description: "The agency has no more bets",
+			Message:     protocol.DeliveryEnded{Agency: "1"},
+		},
+		{
+			Name:        "winners_query",
+			Description: "The agency asks for its winners",
+			Message:     protocol.WinnersQuery{Agency: "5"},
+		},
+		{
+			Name:        "winners",
+			Description: "Documents of the winners are separated by pipes",
+			Message:     protocol.Winners{Documents: []string{"30904465", "29665629", "21073376"}},
+		},
+		{
+			Name:        "winners_none",
+			Description: "An agency without winners gets an empty payload",
+			Message:     protocol.Winners{Documents: []string{}},
+		},
+		{
+			Name:        "winners_pending",
+			Description: "The lottery has not taken place yet, the payload is empty",
+			Message:     protocol.WinnersPending{},
+		},
+		{
+			Name:        "hello",
+			Description: "The agency opens a session with its version, number and the capabilities it supports, separated by commas",
+			Message: protocol.Hello{Version: 1, Agency: "3", Capabilities: []string{
+				protocol.CapabilityCompression,
+				protocol.CapabilityChecksums,
+				protocol.CapabilityPush,
+				protocol.CapabilityPipelining,
+			}},
+		},
+		{
+			Name:        "hello_no_capabilities",
+			Description: "An agency without optional capabilities sends an empty list",
+			Message:     protocol.Hello{Version: 1, Agency: "3", Capabilities: []string{}},
+		},
+		{
+			Name:        "hello_reply",
+			Description: "The server answers its version and the capabilities it enabled",
+			Message:     protocol.HelloReply{Version: 1, Capabilities: []string{protocol.CapabilityPush}},
+		},
+		{
+			Name:        "hello_reply_version_mismatch",
+			Description: "A server of another version answers its own version and enables nothing",
+			Message:     protocol.HelloReply{Version: 2, Capabilities: []string{}},
+		},
+	}
+}
+
+// paddedBet Bet whose batch of id 1 takes exactly size bytes of payload
+func paddedBet(size int) lottery.Bet {
+	bet := santiago
+	bet.FirstName = ""
+	line, _ := protocol.EncodeBet(bet)
+	missing := size - len("1\n") - len(line)
+	bet.FirstName = strings.Repeat("á", missing/2) + strings.Repeat("a", missing%2)
+	return bet
+}
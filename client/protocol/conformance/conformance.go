@@ -0,0 +1,200 @@
+// Package conformance maps protocol messages to the language neutral JSON
+// test vectors shared by the client and the server. A vector pairs a logical
+// message with its exact bytes on the wire, so both implementations can
+// check that they encode and decode the same frames.
+//
+// Vectors live in protocol/vectors at the root of the repository and are
+// generated from Cases with cmd/protovectors.
+package conformance
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/lottery"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+)
+
+// Vector A logical message and its encoding
+type Vector struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Type Name of the message type, as printed by protocol.MessageType
+	Type string `json:"type"`
+	// Message Fields of the message, see the *Fields types
+	Message json.RawMessage `json:"message"`
+	// Encoded Whole frame in hexadecimal, length header included
+	Encoded string `json:"encoded"`
+}
+
+// BetFields JSON form of a bet
+type BetFields struct {
+	Agency    string `json:"agency"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Document  string `json:"document"`
+	Birthdate string `json:"birthdate"`
+	Number    string `json:"number"`
+}
+
+// BetsFields JSON form of a Bets message
+type BetsFields struct {
+	BatchID uint32      `json:"batch_id"`
+	Bets    []BetFields `json:"bets"`
+}
+
+// AckFields JSON form of an Ack message
+type AckFields struct {
+	BatchID uint32 `json:"batch_id"`
+	Count   int    `json:"count"`
+}
+
+// RejectFields JSON form of a Reject message
+type RejectFields struct {
+	BatchID uint32 `json:"batch_id"`
+	Indexes []int  `json:"indexes"`
+}
+
+// AgencyFields JSON form of DeliveryEnded and WinnersQuery messages
+type AgencyFields struct {
+	Agency string `json:"agency"`
+}
+
+// WinnersFields JSON form of a Winners message
+type WinnersFields struct {
+	Documents []string `json:"documents"`
+}
+
+// HelloFields JSON form of a Hello message
+type HelloFields struct {
+	Version      uint16   `json:"version"`
+	Agency       string   `json:"agency"`
+	Capabilities []string `json:"capabilities"`
+}
+
+// HelloReplyFields JSON form of a HelloReply message
+type HelloReplyFields struct {
+	Version      uint16   `json:"version"`
+	Capabilities []string `json:"capabilities"`
+}
+
+// NewVector Encodes the message with the client codec and builds its vector
+func NewVector(name string, description string, m protocol.Message) (Vector, error) {
+	fields, err := MarshalMessage(m)
+	if err != nil {
+		return Vector{}, err
+	}
+	frame, err := protocol.Encode(m)
+	if err != nil {
+		return Vector{}, err
+	}
+	var wire bytes.Buffer
+	if err := protocol.WriteFrame(&wire, frame); err != nil {
+		return Vector{}, err
+	}
+	return Vector{
+		Name:        name,
+		Description: description,
+		Type:        m.Type().String(),
+		Message:     fields,
+		Encoded:     hex.EncodeToString(wire.Bytes()),
+	}, nil
+}
+
+// Bytes Decodes the hexadecimal frame of the vector
+func (v Vector) Bytes() ([]byte, error) {
+	return hex.DecodeString(v.Encoded)
+}
+
+// Decode Builds the message described by the fields of the vector
+func (v Vector) Decode() (protocol.Message, error) {
+	return UnmarshalMessage(v.Type, v.Message)
+}
+
+// MarshalMessage Returns the JSON fields of a message. Empty lists are
+// written as [] so every implementation reads the same value
+func MarshalMessage(m protocol.Message) (json.RawMessage, error) {
+	var fields interface{}
+	switch msg := m.(type) {
+	case protocol.Bets:
+		bets := make([]BetFields, 0, len(msg.Bets))
+		for _, b := range msg.Bets {
+			bets = append(bets, BetFields(b))
+		}
+		fields = BetsFields{BatchID: msg.BatchID, Bets: bets}
+	case protocol.Ack:
+		fields = AckFields(msg)
+	case protocol.Reject:
+		fields = RejectFields{BatchID: msg.BatchID, Indexes: append([]int{}, msg.Indexes...)}
+	case protocol.DeliveryEnded:
+		fields = AgencyFields(msg)
+	case protocol.WinnersQuery:
+		fields = AgencyFields(msg)
+	case protocol.Winners:
+		fields = WinnersFields{Documents: append([]string{}, msg.Documents...)}
+	case protocol.WinnersPending:
+		fields = struct{}{}
+	case protocol.Hello:
+		fields = HelloFields{Version: msg.Version, Agency: msg.Agency, Capabilities: append([]string{}, msg.Capabilities...)}
+	case protocol.HelloReply:
+		fields = HelloReplyFields{Version: msg.Version, Capabilities: append([]string{}, msg.Capabilities...)}
+	default:
+		return nil, fmt.Errorf("conformance: unknown message %T", m)
+	}
+	return json.Marshal(fields)
+}
+
+// UnmarshalMessage Builds a message of the named type from its JSON fields
+func UnmarshalMessage(typeName string, data json.RawMessage) (protocol.Message, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	switch typeName {
+	case protocol.TypeBets.String():
+		var fields BetsFields
+		if err := decoder.Decode(&fields); err != nil {
+			return nil, err
+		}
+		bets := make([]lottery.Bet, 0, len(fields.Bets))
+		for _, b := range fields.Bets {
+			bets = append(bets, lottery.Bet(b))
+		}
+		return protocol.Bets{BatchID: fields.BatchID, Bets: bets}, nil
+	case protocol.TypeAck.String():
+		var fields AckFields
+		err := decoder.Decode(&fields)
+		return protocol.Ack(fields), err
+	case protocol.TypeReject.String():
+		var fields RejectFields
+		err := decoder.Decode(&fields)
+		return protocol.Reject(fields), err
+	case protocol.TypeDeliveryEnded.String():
+		var fields AgencyFields
+		err := decoder.Decode(&fields)
+		return protocol.DeliveryEnded(fields), err
+	case protocol.TypeWinnersQuery.String():
+		var fields AgencyFields
+		err := decoder.Decode(&fields)
+		return protocol.WinnersQuery(fields), err
+	case protocol.TypeWinners.String():
+		var fields WinnersFields
+		err := decoder.Decode(&fields)
+		return protocol.Winners(fields), err
+	case protocol.TypeWinnersPending.String():
+		var fields struct{}
+		err := decoder.Decode(&fields)
+		return protocol.WinnersPending{}, err
+	case protocol.TypeHello.String():
+		var fields HelloFields
+		err := decoder.Decode(&fields)
+		return protocol.Hello(fields), err
+	case protocol.TypeHelloReply.String():
+		var fields HelloReplyFields
+		err := decoder.Decode(&fields)
+		return protocol.HelloReply(fields), err
+	default:
+		return nil, fmt.Errorf("conformance: unknown message type %q", typeName)
+	}
+}
@@ -0,0 +1,138 @@
+package conformance
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+)
+
+// vectorsDir Vectors shared with the server, at the root of the repository
+var vectorsDir = filepath.Join("..", "..", "..", "protocol", "vectors")
+
+func readVectors(t *testing.T) []Vector {
+	t.Helper()
+	vectors, err := ReadVectors(vectorsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vectors) == 0 {
+		t.Fatalf("no vectors found in %v", vectorsDir)
+	}
+	return vectors
+}
+
+func TestCodecEncodesEveryVectorExactly(t *testing.T) {
+	for _, vector := range readVectors(t) {
+		t.Run(vector.Name, func(t *testing.T) {
+			msg, err := vector.Decode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected, err := vector.Bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var wire bytes.Buffer
+			if err := protocol.WriteMessage(&wire, msg); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(wire.Bytes(), expected) {
+				t.Fatalf("expected\n%x\ngot\n%x", expected, wire.Bytes())
+			}
+		})
+	}
+}
+
+func TestCodecDecodesEveryVectorExactly(t *testing.T) {
+	for _, vector := range readVectors(t) {
+		t.Run(vector.Name, func(t *testing.T) {
+			wire, err := vector.Bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			reader := bytes.NewReader(wire)
+			msg, err := protocol.ReadMessage(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reader.Len() != 0 {
+				t.Fatalf("%v bytes left after the frame", reader.Len())
+			}
+			if msg.Type().String() != vector.Type {
+				t.Fatalf("expected a %v message, got %v", vector.Type, msg.Type())
+			}
+
+			// Comparing the JSON forms treats nil and empty lists alike
+			fields, err := MarshalMessage(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var expected, got interface{}
+			json.Unmarshal(vector.Message, &expected)
+			json.Unmarshal(fields, &got)
+			if !reflect.DeepEqual(expected, got) {
+				t.Fatalf("expected %s, got %s", vector.Message, fields)
+			}
+		})
+	}
+}
+
+// TestVectorsAreInSyncWithCases Fails when a case was added or changed
+// without running cmd/protovectors
+func TestVectorsAreInSyncWithCases(t *testing.T) {
+	cases := Cases()
+	for _, c := range cases {
+		expected, err := Render(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := os.ReadFile(filepath.Join(vectorsDir, FileName(c.Name)))
+		if err != nil || !bytes.Equal(actual, expected) {
+			t.Errorf("vector %v is out of date, run go run ./cmd/protovectors", c.Name)
+		}
+	}
+	if vectors := readVectors(t); len(vectors) != len(cases) {
+		t.Errorf("expected %v vectors, found %v: run go run ./cmd/protovectors", len(cases), len(vectors))
+	}
+}
+
+func TestEveryMessageTypeHasAVector(t *testing.T) {
+	covered := make(map[string]bool)
+	for _, c := range Cases() {
+		covered[c.Message.Type().String()] = true
+	}
+	for i := 0; i < 256; i++ {
+		// Compressed frames carry one of the types below with a flag set
+		if i&protocol.FlagCompressed != 0 {
+			continue
+		}
+		name := protocol.MessageType(i).String()
+		if !strings.HasPrefix(name, "unknown(") && !covered[name] {
+			t.Errorf("message type %v has no conformance case", name)
+		}
+	}
+}
+
+func TestMaxFrameVectorIsExactlyTheLimit(t *testing.T) {
+	for _, c := range Cases() {
+		if c.Name != "bets_max_frame" {
+			continue
+		}
+		frame, err := protocol.Encode(c.Message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(frame.Payload) != protocol.MaxPayloadSize {
+			t.Fatalf("expected a payload of %v bytes, got %v", protocol.MaxPayloadSize, len(frame.Payload))
+		}
+		return
+	}
+	t.Fatal("missing bets_max_frame case")
+}
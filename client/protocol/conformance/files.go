@@ -0,0 +1,92 @@
+package conformance
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// FileName Name of the file holding the vector of a case
+func FileName(name string) string {
+	return name + ".json"
+}
+
+// Render Generates the vector of a case as written to its file
+func Render(c Case) ([]byte, error) {
+	vector, err := NewVector(c.Name, c.Description, c.Message)
+	if err != nil {
+		return nil, fmt.Errorf("case %v: %w", c.Name, err)
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(vector); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteVectors Writes the vector of every case to dir and removes the
+// vectors of cases that no longer exist. Returns the amount of vectors
+// written and removed
+func WriteVectors(dir string) (int, int, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, 0, err
+	}
+	cases := Cases()
+	current := make(map[string]bool)
+	for _, c := range cases {
+		data, err := Render(c)
+		if err != nil {
+			return 0, 0, err
+		}
+		if err := os.WriteFile(filepath.Join(dir, FileName(c.Name)), data, 0644); err != nil {
+			return 0, 0, err
+		}
+		current[FileName(c.Name)] = true
+	}
+
+	existing, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return len(cases), 0, err
+	}
+	removed := 0
+	for _, path := range existing {
+		if !current[filepath.Base(path)] {
+			if err := os.Remove(path); err != nil {
+				return len(cases), removed, err
+			}
+			removed++
+		}
+	}
+	return len(cases), removed, nil
+}
+
+// ReadVectors Reads every vector in dir, sorted by file name
+func ReadVectors(dir string) ([]Vector, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	vectors := make([]Vector, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		var vector Vector
+		if err := decoder.Decode(&vector); err != nil {
+			return nil, fmt.Errorf("%v: %w", path, err)
+		}
+		vectors = append(vectors, vector)
+	}
+	return vectors, nil
+}
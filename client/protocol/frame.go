@@ -0,0 +1,266 @@
+// Package protocol implements the communication layer between the agencies
+// and the server: how messages are framed on the socket and how every message
+// type is serialized.
+//
+// Every frame starts with a 2 bytes big endian header holding the length of
+// the body. The body is made of a 1 byte message type followed by the payload
+// of the message:
+//
+//	+--------+--------+------+-------------------+
+//	| length (uint16) | type | payload           |
+//	+--------+--------+------+-------------------+
+//
+// Payloads larger than MaxPayloadSize are split in continuation frames: every
+// frame but the last carries the message type with the FlagContinued bit set
+// and the reader joins their payloads back into a single frame. Writers only
+// split payloads once the peer enabled CapabilityLargeFrames in the handshake.
+//
+// Payloads compressed with DEFLATE carry the FlagCompressed bit in the type of
+// every one of their frames. Compression is applied to the whole payload
+// before it is split, and only once the peer enabled CapabilityCompression.
+//
+// Once the peer enabled CapabilityChecksums, every frame ends with the CRC32C
+// (Castagnoli) of its type and payload, and the length of the frame accounts
+// for it:
+//
+//	+--------+--------+------+-------------------+---------------+
+//	| length (uint16) | type | payload           | crc32c (4 B)  |
+//	+--------+--------+------+-------------------+---------------+
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// HeaderSize Size of the length header of every frame
+const HeaderSize = 2
+
+// MaxBodySize Largest body (type plus payload) a frame can hold
+const MaxBodySize = 1<<16 - 1
+
+// MaxPayloadSize Largest payload a frame can hold
+const MaxPayloadSize = MaxBodySize - 1
+
+// FlagContinued Bit of the type byte set on every continuation frame but
+// the last one of a payload
+const FlagContinued = 0x80
+
+// ChecksumSize Size of the CRC32C trailer of every frame once checksums
+// are enabled
+const ChecksumSize = 4
+
+// DefaultMaxSize Default hard maximum of a payload joined from continuation
+// frames
+const DefaultMaxSize = 1 << 20
+
+// ErrEmptyFrame A frame without message type
+var ErrEmptyFrame = errors.New("protocol: empty frame")
+
+// ErrFrameTooLarge A payload that does not fit in a single frame, or that
+// exceeds the maximum size of the connection
+var ErrFrameTooLarge = errors.New("protocol: frame too large")
+
+// ErrInvalidContinuation A continuation frame of another message type than
+// the frames before it
+var ErrInvalidContinuation = errors.New("protocol: invalid continuation frame")
+
+// ErrCorruptFrame A frame whose checksum does not match its contents
+var ErrCorruptFrame = errors.New("protocol: corrupt frame")
+
+// castagnoli Table of the CRC32C checksums of the frames
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Frame A single message as it travels on the wire
+type Frame struct {
+	Type    MessageType
+	Payload []byte
+}
+
+// Size Returns the amount of bytes the frame takes on the wire, counting the
+// header of every continuation frame
+func (f Frame) Size() int {
+	return WireSize(len(f.Payload))
+}
+
+// WireSize Returns the amount of bytes a payload of the given size takes on
+// the wire, counting the header of every continuation frame
+func WireSize(payloadSize int) int {
+	return StandardFraming.WireSize(payloadSize)
+}
+
+// Framing Limits of the frames read and written on a connection
+type Framing struct {
+	// MaxSize Hard maximum of a payload. Larger payloads fail with
+	// ErrFrameTooLarge, and are detected by the reader before buffering them.
+	// Zero means DefaultMaxSize
+	MaxSize int
+	// Continuation Allows writing payloads larger than MaxPayloadSize as
+	// continuation frames. Readers always join continuation frames
+	Continuation bool
+	// Checksums Appends the CRC32C trailer to every frame written and
+	// verifies it on every frame read
+	Checksums bool
+}
+
+// StandardFraming Framing of a connection before the handshake: every
+// payload must fit in a single frame
+var StandardFraming = Framing{}
+
+// WireSize Returns the amount of bytes a payload of the given size takes on
+// the wire with this framing, counting the header and checksum of every
+// continuation frame
+func (f Framing) WireSize(payloadSize int) int {
+	return f.fragments(payloadSize)*(HeaderSize+1+f.checksumSize()) + payloadSize
+}
+
+// fragments Amount of frames a payload is split in
+func (f Framing) fragments(payloadSize int) int {
+	if payloadSize <= f.chunkSize() {
+		return 1
+	}
+	return (payloadSize + f.chunkSize() - 1) / f.chunkSize()
+}
+
+// chunkSize Largest payload a single frame can hold with this framing
+func (f Framing) chunkSize() int {
+	return MaxPayloadSize - f.checksumSize()
+}
+
+func (f Framing) checksumSize() int {
+	if f.Checksums {
+		return ChecksumSize
+	}
+	return 0
+}
+
+func (f Framing) maxSize() int {
+	if f.MaxSize <= 0 {
+		return DefaultMaxSize
+	}
+	return f.MaxSize
+}
+
+// WriteFrame Writes the whole frame to w with the standard framing
+func WriteFrame(w io.Writer, f Frame) error {
+	return StandardFraming.WriteFrame(w, f)
+}
+
+// ReadFrame Reads a whole frame from r with the standard framing
+func ReadFrame(r io.Reader) (Frame, error) {
+	return StandardFraming.ReadFrame(r)
+}
+
+// WriteFrame Writes the whole frame to w, split in continuation frames if
+// allowed. Writers are allowed to accept less bytes than requested (short
+// writes), so writing is retried until every byte is written or an error
+// happens
+func (f Framing) WriteFrame(w io.Writer, frame Frame) error {
+	if len(frame.Payload) > f.maxSize() || len(frame.Payload) > f.chunkSize() && !f.Continuation {
+		return fmt.Errorf("%w: %d bytes of payload", ErrFrameTooLarge, len(frame.Payload))
+	}
+
+	buf := make([]byte, 0, f.WireSize(len(frame.Payload)))
+	payload := frame.Payload
+	for {
+		chunk := payload
+		if len(chunk) > f.chunkSize() {
+			chunk = chunk[:f.chunkSize()]
+		}
+		payload = payload[len(chunk):]
+
+		msgType := byte(frame.Type)
+		if len(payload) > 0 {
+			msgType |= FlagContinued
+		}
+		var header [HeaderSize]byte
+		binary.BigEndian.PutUint16(header[:], uint16(1+len(chunk)+f.checksumSize()))
+		buf = append(buf, header[:]...)
+		body := len(buf)
+		buf = append(buf, msgType)
+		buf = append(buf, chunk...)
+		if f.Checksums {
+			var checksum [ChecksumSize]byte
+			binary.BigEndian.PutUint32(checksum[:], crc32.Checksum(buf[body:], castagnoli))
+			buf = append(buf, checksum[:]...)
+		}
+		if len(payload) == 0 {
+			return writeAll(w, buf)
+		}
+	}
+}
+
+// ReadFrame Reads a whole frame from r, joining continuation frames. Reads
+// can return less bytes than requested (short reads), so reading is retried
+// until the header and the full body arrive. A connection closed in the
+// middle of a frame returns io.ErrUnexpectedEOF, while a connection closed
+// between frames returns io.EOF. With checksums, a frame that does not match
+// its checksum returns ErrCorruptFrame
+func (f Framing) ReadFrame(r io.Reader) (Frame, error) {
+	var frame Frame
+	for first := true; ; first = false {
+		var header [HeaderSize]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if !first && err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return Frame{}, err
+		}
+		length := int(binary.BigEndian.Uint16(header[:]))
+		if length == 0 {
+			return Frame{}, ErrEmptyFrame
+		}
+		if f.Checksums && length < 1+ChecksumSize {
+			return Frame{}, fmt.Errorf("%w: %d bytes of body cannot hold a checksum", ErrCorruptFrame, length)
+		}
+		if len(frame.Payload)+length-1-f.checksumSize() > f.maxSize() {
+			return Frame{}, fmt.Errorf("%w: more than %d bytes of payload", ErrFrameTooLarge, f.maxSize())
+		}
+
+		body := make([]byte, length)
+		if _, err := io.ReadFull(r, body); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return Frame{}, err
+		}
+		if f.Checksums {
+			end := len(body) - ChecksumSize
+			expected, actual := binary.BigEndian.Uint32(body[end:]), crc32.Checksum(body[:end], castagnoli)
+			if expected != actual {
+				return Frame{}, fmt.Errorf("%w: checksum %08x, expected %08x", ErrCorruptFrame, actual, expected)
+			}
+			body = body[:end]
+		}
+		msgType := MessageType(body[0] &^ FlagContinued)
+		if !first && msgType != frame.Type {
+			return Frame{}, fmt.Errorf("%w: %v frame continued by a %v frame", ErrInvalidContinuation, frame.Type, msgType)
+		}
+		frame.Type = msgType
+		if first {
+			frame.Payload = body[1:]
+		} else {
+			frame.Payload = append(frame.Payload, body[1:]...)
+		}
+		if body[0]&FlagContinued == 0 {
+			return frame, nil
+		}
+	}
+}
+
+func writeAll(w io.Writer, buf []byte) error {
+	for len(buf) > 0 {
+		n, err := w.Write(buf)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return io.ErrShortWrite
+		}
+		buf = buf[n:]
+	}
+	return nil
+}
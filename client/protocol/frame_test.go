@@ -0,0 +1,253 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"testing/iotest"
+)
+
+// shortWriter Accepts at most one byte per call, like a congested socket
+type shortWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return w.buf.Write(p[:1])
+}
+
+func TestFrameRoundTripSurvivesShortReadsAndWrites(t *testing.T) {
+	frame := Frame{Type: TypeBets, Payload: []byte("1\n1|Santiago|Lorca|30904465|1999-03-17|7574")}
+
+	w := &shortWriter{}
+	if err := WriteFrame(w, frame); err != nil {
+		t.Fatal(err)
+	}
+	if w.buf.Len() != frame.Size() {
+		t.Fatalf("expected %v bytes written, got %v", frame.Size(), w.buf.Len())
+	}
+
+	read, err := ReadFrame(iotest.OneByteReader(&w.buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Type != frame.Type || !bytes.Equal(read.Payload, frame.Payload) {
+		t.Fatalf("expected %+v, got %+v", frame, read)
+	}
+}
+
+func TestFrameHeaderIsBigEndianBodyLength(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteFrame(&buf, Frame{Type: TypeAck, Payload: []byte("7|2")}); err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{0, 4, byte(TypeAck), '7', '|', '2'}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Fatalf("expected %v, got %v", expected, buf.Bytes())
+	}
+}
+
+func TestWriteFrameRejectsPayloadsLargerThanAFrame(t *testing.T) {
+	err := WriteFrame(io.Discard, Frame{Type: TypeBets, Payload: make([]byte, MaxPayloadSize+1)})
+	if !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("expected ErrFrameTooLarge, got %v", err)
+	}
+	if err := WriteFrame(io.Discard, Frame{Type: TypeBets, Payload: make([]byte, MaxPayloadSize)}); err != nil {
+		t.Fatalf("a payload of exactly MaxPayloadSize must fit: %v", err)
+	}
+}
+
+func TestReadFrameErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []byte
+		expected error
+	}{
+		{name: "closed between frames", input: nil, expected: io.EOF},
+		{name: "closed inside the header", input: []byte{0}, expected: io.ErrUnexpectedEOF},
+		{name: "closed inside the body", input: []byte{0, 5, byte(TypeAck), '1'}, expected: io.ErrUnexpectedEOF},
+		{name: "closed right after the header", input: []byte{0, 5}, expected: io.ErrUnexpectedEOF},
+		{name: "zero length", input: []byte{0, 0}, expected: ErrEmptyFrame},
+		{name: "closed between continuation frames", input: []byte{0, 2, byte(TypeWinners) | FlagContinued, '1'}, expected: io.ErrUnexpectedEOF},
+		{name: "empty continuation frame", input: []byte{0, 2, byte(TypeWinners) | FlagContinued, '1', 0, 0}, expected: ErrEmptyFrame},
+		{name: "continued by another type", input: []byte{0, 2, byte(TypeWinners) | FlagContinued, '1', 0, 2, byte(TypeAck), '2'}, expected: ErrInvalidContinuation},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ReadFrame(bytes.NewReader(test.input))
+			if !errors.Is(err, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, err)
+			}
+		})
+	}
+}
+
+// headers Lengths and type bytes of the frames in a stream
+func headers(t *testing.T, stream []byte) (lengths []int, types []byte) {
+	t.Helper()
+	for len(stream) > 0 {
+		length := int(stream[0])<<8 | int(stream[1])
+		lengths = append(lengths, length)
+		types = append(types, stream[HeaderSize])
+		stream = stream[HeaderSize+length:]
+	}
+	return lengths, types
+}
+
+func TestContinuationFramesAtTheBoundaries(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		lengths []int
+	}{
+		{name: "largest single frame", size: MaxPayloadSize, lengths: []int{MaxBodySize}},
+		{name: "one byte more than a frame", size: MaxPayloadSize + 1, lengths: []int{MaxBodySize, 2}},
+		{name: "exactly two frames", size: 2 * MaxPayloadSize, lengths: []int{MaxBodySize, MaxBodySize}},
+		{name: "one byte more than two frames", size: 2*MaxPayloadSize + 1, lengths: []int{MaxBodySize, MaxBodySize, 2}},
+	}
+
+	framing := Framing{Continuation: true}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			frame := Frame{Type: TypeWinners, Payload: bytes.Repeat([]byte("30904465|"), test.size/9+1)[:test.size]}
+			var buf bytes.Buffer
+			if err := framing.WriteFrame(&buf, frame); err != nil {
+				t.Fatal(err)
+			}
+			if buf.Len() != frame.Size() {
+				t.Fatalf("expected %v bytes written, got %v", frame.Size(), buf.Len())
+			}
+
+			lengths, types := headers(t, buf.Bytes())
+			if !reflect.DeepEqual(lengths, test.lengths) {
+				t.Fatalf("expected frames of %v bytes, got %v", test.lengths, lengths)
+			}
+			for i, msgType := range types {
+				if continued := msgType&FlagContinued != 0; continued != (i < len(types)-1) || MessageType(msgType&^FlagContinued) != TypeWinners {
+					t.Fatalf("unexpected type byte %#x on frame %v of %v", msgType, i+1, len(types))
+				}
+			}
+
+			read, err := ReadFrame(iotest.HalfReader(&buf))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if read.Type != frame.Type || !bytes.Equal(read.Payload, frame.Payload) {
+				t.Fatalf("expected the %v bytes payload back, got %v bytes", len(frame.Payload), len(read.Payload))
+			}
+		})
+	}
+
+	if err := WriteFrame(io.Discard, Frame{Type: TypeWinners, Payload: make([]byte, MaxPayloadSize+1)}); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("expected continuation frames to require negotiation, got %v", err)
+	}
+}
+
+// countingReader Counts the bytes read from the underlying reader
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+func TestFramingEnforcesTheHardMaximum(t *testing.T) {
+	const max = 100000
+	limited := Framing{MaxSize: max, Continuation: true}
+	unlimited := Framing{MaxSize: 2 * max, Continuation: true}
+
+	var buf bytes.Buffer
+	if err := limited.WriteFrame(&buf, Frame{Type: TypeWinners, Payload: make([]byte, max)}); err != nil {
+		t.Fatalf("a payload of exactly the maximum must be written: %v", err)
+	}
+	if frame, err := limited.ReadFrame(&buf); err != nil || len(frame.Payload) != max {
+		t.Fatalf("a payload of exactly the maximum must be read: %v", err)
+	}
+
+	if err := limited.WriteFrame(io.Discard, Frame{Type: TypeWinners, Payload: make([]byte, max+1)}); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("expected ErrFrameTooLarge writing one byte more than the maximum, got %v", err)
+	}
+	if err := unlimited.WriteFrame(&buf, Frame{Type: TypeWinners, Payload: make([]byte, max+1)}); err != nil {
+		t.Fatal(err)
+	}
+	total := buf.Len()
+	counter := &countingReader{r: &buf}
+	if _, err := limited.ReadFrame(counter); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("expected ErrFrameTooLarge reading one byte more than the maximum, got %v", err)
+	}
+	// The second frame would exceed the maximum, so its body is never read
+	if counter.n >= total-HeaderSize {
+		t.Fatalf("expected the reader to stop before the oversized body, read %v of %v bytes", counter.n, total)
+	}
+
+	small := Framing{MaxSize: 10}
+	buf.Reset()
+	WriteFrame(&buf, Frame{Type: TypeWinners, Payload: make([]byte, 10)})
+	WriteFrame(&buf, Frame{Type: TypeWinners, Payload: make([]byte, 11)})
+	if _, err := small.ReadFrame(&buf); err != nil {
+		t.Fatalf("a single frame of exactly the maximum must be read: %v", err)
+	}
+	if _, err := small.ReadFrame(&buf); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("expected ErrFrameTooLarge for a single frame above the maximum, got %v", err)
+	}
+}
+
+func TestChecksumsDetectCorruptFrames(t *testing.T) {
+	framing := Framing{Checksums: true, Continuation: true}
+	frame := Frame{Type: TypeAck, Payload: []byte("7|2")}
+	var buf bytes.Buffer
+	if err := framing.WriteFrame(&buf, frame); err != nil {
+		t.Fatal(err)
+	}
+	// CRC32C of the type and the payload
+	expected := []byte{0, 8, byte(TypeAck), '7', '|', '2', 0xaf, 0x09, 0xc5, 0x14}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Fatalf("expected %x, got %x", expected, buf.Bytes())
+	}
+	if buf.Len() != framing.WireSize(len(frame.Payload)) {
+		t.Fatalf("expected %v bytes written, got %v", framing.WireSize(len(frame.Payload)), buf.Len())
+	}
+	if read, err := framing.ReadFrame(bytes.NewReader(buf.Bytes())); err != nil || !bytes.Equal(read.Payload, frame.Payload) {
+		t.Fatalf("expected %+v, got %+v %v", frame, read, err)
+	}
+
+	// Flipping any bit of the body is detected
+	for i := HeaderSize; i < buf.Len(); i++ {
+		corrupt := append([]byte(nil), buf.Bytes()...)
+		corrupt[i] ^= 0x10
+		if _, err := framing.ReadFrame(bytes.NewReader(corrupt)); !errors.Is(err, ErrCorruptFrame) {
+			t.Fatalf("expected ErrCorruptFrame flipping byte %v, got %v", i, err)
+		}
+	}
+	if _, err := framing.ReadFrame(bytes.NewReader([]byte{0, 3, byte(TypeAck), 0, 0})); !errors.Is(err, ErrCorruptFrame) {
+		t.Fatalf("expected ErrCorruptFrame on a body too short for its checksum, got %v", err)
+	}
+
+	// Every continuation frame carries its own checksum
+	large := Frame{Type: TypeWinners, Payload: bytes.Repeat([]byte("30904465|"), MaxPayloadSize/9+1)}
+	buf.Reset()
+	if err := framing.WriteFrame(&buf, large); err != nil {
+		t.Fatal(err)
+	}
+	if lengths, _ := headers(t, buf.Bytes()); len(lengths) != 2 || lengths[0] != MaxBodySize {
+		t.Fatalf("expected two full frames, got frames of %v bytes", lengths)
+	}
+	corrupt := append([]byte(nil), buf.Bytes()...)
+	corrupt[len(corrupt)-ChecksumSize-1] ^= 1
+	if _, err := framing.ReadFrame(bytes.NewReader(corrupt)); !errors.Is(err, ErrCorruptFrame) {
+		t.Fatalf("expected ErrCorruptFrame on the last continuation frame, got %v", err)
+	}
+	if read, err := framing.ReadFrame(&buf); err != nil || !bytes.Equal(read.Payload, large.Payload) {
+		t.Fatalf("expected the %v bytes payload back, got %v", len(large.Payload), err)
+	}
+}
@@ -0,0 +1,113 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// addVectorSeeds Seeds a fuzz target with the frames of the conformance
+// vectors shared with the server
+func addVectorSeeds(f *testing.F, add func(frame []byte)) {
+	paths, _ := filepath.Glob(filepath.Join("..", "..", "protocol", "vectors", "*.json"))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		var vector struct {
+			Encoded string `json:"encoded"`
+		}
+		if err := json.Unmarshal(data, &vector); err != nil {
+			f.Fatal(err)
+		}
+		frame, err := hex.DecodeString(vector.Encoded)
+		if err != nil {
+			f.Fatal(err)
+		}
+		add(frame)
+	}
+}
+
+// FuzzReadFrame Reads frames from untrusted bytes until the stream ends. The
+// reader must never panic, only fail with the documented errors, and every
+// frame it returns must be written back as the bytes it consumed, unless it
+// was joined from continuation frames split differently than the writer does
+func FuzzReadFrame(f *testing.F) {
+	addVectorSeeds(f, func(frame []byte) { f.Add(frame) })
+	f.Add([]byte{})
+	f.Add([]byte{0})
+	f.Add([]byte{0, 0})
+	f.Add([]byte{0xff, 0xff, 1})
+	f.Add([]byte{0, 2, byte(TypeAck), '1', 0, 1})
+	f.Add([]byte{0, 2, byte(TypeWinners) | FlagContinued, '1', 0, 2, byte(TypeWinners), '2'})
+	f.Add([]byte{0, 2, byte(TypeWinners) | FlagContinued, '1', 0, 2, byte(TypeAck), '2'})
+
+	framing := Framing{MaxSize: 4 * MaxPayloadSize, Continuation: true}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		reader := bytes.NewReader(data)
+		for {
+			offset := len(data) - reader.Len()
+			frame, err := framing.ReadFrame(reader)
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return
+			}
+			if errors.Is(err, ErrEmptyFrame) || errors.Is(err, ErrInvalidContinuation) || errors.Is(err, ErrFrameTooLarge) {
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			var written bytes.Buffer
+			if err := framing.WriteFrame(&written, frame); err != nil {
+				t.Fatal(err)
+			}
+			consumed := data[offset : len(data)-reader.Len()]
+			if consumed[HeaderSize]&FlagContinued == 0 && !bytes.Equal(written.Bytes(), consumed) {
+				t.Fatalf("frame read from %x is written as %x", consumed, written.Bytes())
+			}
+			again, err := framing.ReadFrame(&written)
+			if err != nil || again.Type != frame.Type || !bytes.Equal(again.Payload, frame.Payload) {
+				t.Fatalf("frame %+v is read back as %+v, %v", frame, again, err)
+			}
+		}
+	})
+}
+
+// FuzzDecode Decodes untrusted payloads of every type. The decoder must never
+// panic, and a payload it accepts must encode to a payload that decodes to
+// the same message
+func FuzzDecode(f *testing.F) {
+	addVectorSeeds(f, func(frame []byte) {
+		f.Add(frame[HeaderSize], frame[HeaderSize+1:])
+	})
+	f.Add(byte(TypeBets), []byte("1\n\n"))
+	f.Add(byte(TypeAck), []byte("+1|-0"))
+	f.Add(byte(TypeReject), []byte("1|,"))
+	f.Add(byte(TypeWinners), []byte("|"))
+	f.Add(byte(0), []byte(nil))
+
+	f.Fuzz(func(t *testing.T, msgType byte, payload []byte) {
+		msg, err := Decode(Frame{Type: MessageType(msgType), Payload: payload})
+		if err != nil {
+			return
+		}
+		frame, err := Encode(msg)
+		if err != nil {
+			t.Fatalf("decoded %+v from %q but cannot encode it: %v", msg, payload, err)
+		}
+		again, err := Decode(frame)
+		if err != nil {
+			t.Fatalf("encoded %+v as %q, which does not decode: %v", msg, frame.Payload, err)
+		}
+		if !equalMessages(msg, again) {
+			t.Fatalf("decoded %+v from %q but %+v after encoding it again", msg, payload, again)
+		}
+	})
+}
@@ -0,0 +1,72 @@
+package protocol
+
+import "fmt"
+
+// Version Version of the protocol implemented by this package. Both ends
+// send it in the handshake and a session is only opened when they match
+const Version uint16 = 1
+
+// Capabilities an agency and a server can enable for a session. A capability
+// is only used when both ends support it
+const (
+	// CapabilityCompression Payloads can be compressed
+	CapabilityCompression = "compression"
+	// CapabilityChecksums Frames carry a checksum of their body
+	CapabilityChecksums = "checksums"
+	// CapabilityPush A winners query answered with WinnersPending stays open
+	// and the server sends the Winners on the same connection as soon as the
+	// lottery takes place, instead of the agency polling for them
+	CapabilityPush = "push"
+	// CapabilityPipelining The agency can send a request before the response
+	// to the previous one arrives
+	CapabilityPipelining = "pipelining"
+	// CapabilityLargeFrames Payloads larger than MaxPayloadSize can be sent
+	// as continuation frames
+	CapabilityLargeFrames = "large_frames"
+)
+
+// Capabilities Every capability known by this version of the protocol
+var Capabilities = []string{
+	CapabilityCompression,
+	CapabilityChecksums,
+	CapabilityPush,
+	CapabilityPipelining,
+	CapabilityLargeFrames,
+}
+
+// Negotiate Returns the capabilities of offered that are also in accepted,
+// in the order they were offered and without duplicates
+func Negotiate(offered []string, accepted []string) []string {
+	acceptedSet := make(map[string]bool, len(accepted))
+	for _, capability := range accepted {
+		acceptedSet[capability] = true
+	}
+	var enabled []string
+	for _, capability := range offered {
+		if acceptedSet[capability] {
+			enabled = append(enabled, capability)
+			delete(acceptedSet, capability)
+		}
+	}
+	return enabled
+}
+
+// Has Returns true if the capability is in the list
+func Has(capabilities []string, capability string) bool {
+	for _, c := range capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// VersionError The server speaks a different version of the protocol
+type VersionError struct {
+	Client uint16
+	Server uint16
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("protocol: version mismatch, agency speaks version %d and server speaks version %d", e.Client, e.Server)
+}
@@ -0,0 +1,480 @@
+package protocol
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/lottery"
+)
+
+// MessageType Identifies how the payload of a frame is serialized
+type MessageType byte
+
+// Message types of the protocol
+const (
+	// TypeBets Batch of bets sent by an agency
+	TypeBets MessageType = 1
+	// TypeAck Every bet of a batch, or the end of the delivery, was processed
+	TypeAck MessageType = 2
+	// TypeReject Some bets of a batch were rejected, none of them is stored
+	TypeReject MessageType = 3
+	// TypeDeliveryEnded The agency has no more bets to send
+	TypeDeliveryEnded MessageType = 4
+	// TypeWinnersQuery The agency asks for its winners
+	TypeWinnersQuery MessageType = 5
+	// TypeWinners Documents of the winners of the agency
+	TypeWinners MessageType = 6
+	// TypeWinnersPending The lottery has not taken place yet
+	TypeWinnersPending MessageType = 7
+	// TypeHello First message of a session, sent by the agency
+	TypeHello MessageType = 8
+	// TypeHelloReply Answer of the server to the hello of the agency
+	TypeHelloReply MessageType = 9
+)
+
+var typeNames = map[MessageType]string{
+	TypeBets:           "bets",
+	TypeAck:            "ack",
+	TypeReject:         "reject",
+	TypeDeliveryEnded:  "delivery_ended",
+	TypeWinnersQuery:   "winners_query",
+	TypeWinners:        "winners",
+	TypeWinnersPending: "winners_pending",
+	TypeHello:          "hello",
+	TypeHelloReply:     "hello_reply",
+}
+
+func (t MessageType) String() string {
+	if t&FlagCompressed != 0 {
+		return (t &^ FlagCompressed).String() + "(compressed)"
+	}
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", byte(t))
+}
+
+// Field separators of the payloads. Bets are sent one per line with their
+// fields separated by pipes
+const (
+	lineSeparator  = "\n"
+	fieldSeparator = "|"
+	listSeparator  = ","
+)
+
+// Message A message of the protocol
+type Message interface {
+	Type() MessageType
+}
+
+// Bets Batch of bets. Batches are numbered by the agency, so the server can
+// detect a batch that is sent again after a reconnection
+type Bets struct {
+	BatchID uint32
+	Bets    []lottery.Bet
+}
+
+// Ack Confirms that a request was processed. For batches it carries the
+// amount of bets stored; for the end of the delivery BatchID is zero
+type Ack struct {
+	BatchID uint32
+	Count   int
+}
+
+// Reject Rejects the bets at the given positions of a batch. A rejected
+// batch is not stored at all
+type Reject struct {
+	BatchID uint32
+	Indexes []int
+}
+
+// DeliveryEnded Notifies that the agency sent all its bets
+type DeliveryEnded struct {
+	Agency string
+}
+
+// WinnersQuery Asks for the winners of the agency
+type WinnersQuery struct {
+	Agency string
+}
+
+// Winners Documents of the winners of the agency that asked for them
+type Winners struct {
+	Documents []string
+}
+
+// WinnersPending The winners cannot be known until every agency finished
+type WinnersPending struct{}
+
+// Hello Opens a session: the protocol version of the agency, its number and
+// the capabilities it is able to use
+type Hello struct {
+	Version      uint16
+	Agency       string
+	Capabilities []string
+}
+
+// HelloReply The protocol version of the server and the capabilities it
+// enabled for the session, out of the ones offered in the hello
+type HelloReply struct {
+	Version      uint16
+	Capabilities []string
+}
+
+// Type Implements Message
+func (Bets) Type() MessageType { return TypeBets }
+
+// Type Implements Message
+func (Ack) Type() MessageType { return TypeAck }
+
+// Type Implements Message
+func (Reject) Type() MessageType { return TypeReject }
+
+// Type Implements Message
+func (DeliveryEnded) Type() MessageType { return TypeDeliveryEnded }
+
+// Type Implements Message
+func (WinnersQuery) Type() MessageType { return TypeWinnersQuery }
+
+// Type Implements Message
+func (Winners) Type() MessageType { return TypeWinners }
+
+// Type Implements Message
+func (WinnersPending) Type() MessageType { return TypeWinnersPending }
+
+// Type Implements Message
+func (Hello) Type() MessageType { return TypeHello }
+
+// Type Implements Message
+func (HelloReply) Type() MessageType { return TypeHelloReply }
+
+// DecodeError A frame whose payload does not match its message type
+type DecodeError struct {
+	Type   MessageType
+	Reason string
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("protocol: invalid %v payload: %s", e.Type, e.Reason)
+}
+
+// EncodeError A message that cannot be represented on the wire
+type EncodeError struct {
+	Type   MessageType
+	Reason string
+}
+
+func (e *EncodeError) Error() string {
+	return fmt.Sprintf("protocol: cannot encode %v: %s", e.Type, e.Reason)
+}
+
+// Encode Serializes a message into a frame. The size of the payload is
+// checked when the frame is written, against the framing of the connection
+func Encode(m Message) (Frame, error) {
+	var payload string
+	switch msg := m.(type) {
+	case Bets:
+		lines := make([]string, 0, len(msg.Bets)+1)
+		lines = append(lines, strconv.FormatUint(uint64(msg.BatchID), 10))
+		for _, bet := range msg.Bets {
+			line, err := EncodeBet(bet)
+			if err != nil {
+				return Frame{}, err
+			}
+			lines = append(lines, line)
+		}
+		payload = strings.Join(lines, lineSeparator)
+	case Ack:
+		if msg.Count < 0 {
+			return Frame{}, &EncodeError{Type: TypeAck, Reason: fmt.Sprintf("negative count %d", msg.Count)}
+		}
+		payload = strconv.FormatUint(uint64(msg.BatchID), 10) + fieldSeparator + strconv.Itoa(msg.Count)
+	case Reject:
+		indexes := make([]string, len(msg.Indexes))
+		for i, index := range msg.Indexes {
+			if index < 0 {
+				return Frame{}, &EncodeError{Type: TypeReject, Reason: fmt.Sprintf("negative index %d", index)}
+			}
+			indexes[i] = strconv.Itoa(index)
+		}
+		payload = strconv.FormatUint(uint64(msg.BatchID), 10) + fieldSeparator + strings.Join(indexes, listSeparator)
+	case DeliveryEnded:
+		if msg.Agency == "" {
+			return Frame{}, &EncodeError{Type: TypeDeliveryEnded, Reason: "empty agency"}
+		}
+		if err := checkField(TypeDeliveryEnded, "agency", msg.Agency); err != nil {
+			return Frame{}, err
+		}
+		payload = msg.Agency
+	case WinnersQuery:
+		if msg.Agency == "" {
+			return Frame{}, &EncodeError{Type: TypeWinnersQuery, Reason: "empty agency"}
+		}
+		if err := checkField(TypeWinnersQuery, "agency", msg.Agency); err != nil {
+			return Frame{}, err
+		}
+		payload = msg.Agency
+	case Winners:
+		for _, document := range msg.Documents {
+			if document == "" {
+				return Frame{}, &EncodeError{Type: TypeWinners, Reason: "empty document"}
+			}
+			if err := checkField(TypeWinners, "document", document); err != nil {
+				return Frame{}, err
+			}
+		}
+		payload = strings.Join(msg.Documents, fieldSeparator)
+	case WinnersPending:
+	case Hello:
+		if msg.Agency == "" {
+			return Frame{}, &EncodeError{Type: TypeHello, Reason: "empty agency"}
+		}
+		if err := checkField(TypeHello, "agency", msg.Agency); err != nil {
+			return Frame{}, err
+		}
+		capabilities, err := encodeCapabilities(TypeHello, msg.Capabilities)
+		if err != nil {
+			return Frame{}, err
+		}
+		payload = strings.Join([]string{strconv.Itoa(int(msg.Version)), msg.Agency, capabilities}, fieldSeparator)
+	case HelloReply:
+		capabilities, err := encodeCapabilities(TypeHelloReply, msg.Capabilities)
+		if err != nil {
+			return Frame{}, err
+		}
+		payload = strconv.Itoa(int(msg.Version)) + fieldSeparator + capabilities
+	default:
+		return Frame{}, fmt.Errorf("protocol: unknown message %T", m)
+	}
+
+	return Frame{Type: m.Type(), Payload: []byte(payload)}, nil
+}
+
+// Decode Parses the payload of a frame according to its message type.
+// Compressed payloads are decompressed first, up to DefaultMaxSize bytes
+func Decode(f Frame) (Message, error) {
+	f, err := Decompress(f, DefaultMaxSize)
+	if err != nil {
+		return nil, err
+	}
+	payload := string(f.Payload)
+	switch f.Type {
+	case TypeBets:
+		lines := strings.Split(payload, lineSeparator)
+		batchID, err := parseBatchID(f.Type, lines[0])
+		if err != nil {
+			return nil, err
+		}
+		bets := make([]lottery.Bet, 0, len(lines)-1)
+		for _, line := range lines[1:] {
+			bet, err := DecodeBet(line)
+			if err != nil {
+				return nil, err
+			}
+			bets = append(bets, bet)
+		}
+		return Bets{BatchID: batchID, Bets: bets}, nil
+	case TypeAck:
+		fields := strings.Split(payload, fieldSeparator)
+		if len(fields) != 2 {
+			return nil, &DecodeError{Type: f.Type, Reason: "expected batch_id|count"}
+		}
+		batchID, err := parseBatchID(f.Type, fields[0])
+		if err != nil {
+			return nil, err
+		}
+		count, err := strconv.Atoi(fields[1])
+		if err != nil || count < 0 {
+			return nil, &DecodeError{Type: f.Type, Reason: fmt.Sprintf("invalid count %q", fields[1])}
+		}
+		return Ack{BatchID: batchID, Count: count}, nil
+	case TypeReject:
+		fields := strings.Split(payload, fieldSeparator)
+		if len(fields) != 2 {
+			return nil, &DecodeError{Type: f.Type, Reason: "expected batch_id|indexes"}
+		}
+		batchID, err := parseBatchID(f.Type, fields[0])
+		if err != nil {
+			return nil, err
+		}
+		var indexes []int
+		if fields[1] != "" {
+			for _, field := range strings.Split(fields[1], listSeparator) {
+				index, err := strconv.Atoi(field)
+				if err != nil || index < 0 {
+					return nil, &DecodeError{Type: f.Type, Reason: fmt.Sprintf("invalid index %q", field)}
+				}
+				indexes = append(indexes, index)
+			}
+		}
+		return Reject{BatchID: batchID, Indexes: indexes}, nil
+	case TypeDeliveryEnded:
+		if payload == "" {
+			return nil, &DecodeError{Type: f.Type, Reason: "missing agency"}
+		}
+		if strings.ContainsAny(payload, lineSeparator+fieldSeparator) {
+			return nil, &DecodeError{Type: f.Type, Reason: fmt.Sprintf("agency %q contains a separator", payload)}
+		}
+		return DeliveryEnded{Agency: payload}, nil
+	case TypeWinnersQuery:
+		if payload == "" {
+			return nil, &DecodeError{Type: f.Type, Reason: "missing agency"}
+		}
+		if strings.ContainsAny(payload, lineSeparator+fieldSeparator) {
+			return nil, &DecodeError{Type: f.Type, Reason: fmt.Sprintf("agency %q contains a separator", payload)}
+		}
+		return WinnersQuery{Agency: payload}, nil
+	case TypeWinners:
+		var documents []string
+		if payload != "" {
+			documents = strings.Split(payload, fieldSeparator)
+		}
+		for _, document := range documents {
+			if document == "" || strings.Contains(document, lineSeparator) {
+				return nil, &DecodeError{Type: f.Type, Reason: fmt.Sprintf("invalid document %q", document)}
+			}
+		}
+		return Winners{Documents: documents}, nil
+	case TypeWinnersPending:
+		if payload != "" {
+			return nil, &DecodeError{Type: f.Type, Reason: "unexpected payload"}
+		}
+		return WinnersPending{}, nil
+	case TypeHello:
+		fields := strings.Split(payload, fieldSeparator)
+		if len(fields) != 3 {
+			return nil, &DecodeError{Type: f.Type, Reason: "expected version|agency|capabilities"}
+		}
+		version, err := parseVersion(f.Type, fields[0])
+		if err != nil {
+			return nil, err
+		}
+		if fields[1] == "" || strings.Contains(fields[1], lineSeparator) {
+			return nil, &DecodeError{Type: f.Type, Reason: fmt.Sprintf("invalid agency %q", fields[1])}
+		}
+		capabilities, err := decodeCapabilities(f.Type, fields[2])
+		if err != nil {
+			return nil, err
+		}
+		return Hello{Version: version, Agency: fields[1], Capabilities: capabilities}, nil
+	case TypeHelloReply:
+		fields := strings.Split(payload, fieldSeparator)
+		if len(fields) != 2 {
+			return nil, &DecodeError{Type: f.Type, Reason: "expected version|capabilities"}
+		}
+		version, err := parseVersion(f.Type, fields[0])
+		if err != nil {
+			return nil, err
+		}
+		capabilities, err := decodeCapabilities(f.Type, fields[1])
+		if err != nil {
+			return nil, err
+		}
+		return HelloReply{Version: version, Capabilities: capabilities}, nil
+	default:
+		return nil, &DecodeError{Type: f.Type, Reason: "unknown message type"}
+	}
+}
+
+// EncodeBet Serializes a single bet as a line of pipe separated fields:
+// agency|first_name|last_name|document|birthdate|number
+func EncodeBet(b lottery.Bet) (string, error) {
+	fields := []string{b.Agency, b.FirstName, b.LastName, b.Document, b.Birthdate, b.Number}
+	for i, field := range fields {
+		if err := checkField(TypeBets, betFieldNames[i], field); err != nil {
+			return "", err
+		}
+	}
+	return strings.Join(fields, fieldSeparator), nil
+}
+
+// DecodeBet Parses a line produced by EncodeBet
+func DecodeBet(line string) (lottery.Bet, error) {
+	fields := strings.Split(line, fieldSeparator)
+	if len(fields) != len(betFieldNames) {
+		return lottery.Bet{}, &DecodeError{
+			Type:   TypeBets,
+			Reason: fmt.Sprintf("bet with %d fields, expected %d", len(fields), len(betFieldNames)),
+		}
+	}
+	return lottery.Bet{
+		Agency:    fields[0],
+		FirstName: fields[1],
+		LastName:  fields[2],
+		Document:  fields[3],
+		Birthdate: fields[4],
+		Number:    fields[5],
+	}, nil
+}
+
+var betFieldNames = []string{"agency", "first_name", "last_name", "document", "birthdate", "number"}
+
+// WriteMessage Encodes a message and writes it as a single frame
+func WriteMessage(w io.Writer, m Message) error {
+	frame, err := Encode(m)
+	if err != nil {
+		return err
+	}
+	return WriteFrame(w, frame)
+}
+
+// ReadMessage Reads a single frame and decodes it
+func ReadMessage(r io.Reader) (Message, error) {
+	frame, err := ReadFrame(r)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(frame)
+}
+
+// checkField Separators cannot be escaped, so fields containing them would
+// change the structure of the payload
+func checkField(t MessageType, name string, value string) error {
+	if strings.ContainsAny(value, lineSeparator+fieldSeparator) {
+		return &EncodeError{Type: t, Reason: fmt.Sprintf("%s %q contains a separator", name, value)}
+	}
+	return nil
+}
+
+// encodeCapabilities Joins capability names with the list separator
+func encodeCapabilities(t MessageType, capabilities []string) (string, error) {
+	for _, capability := range capabilities {
+		if capability == "" || strings.ContainsAny(capability, lineSeparator+fieldSeparator+listSeparator) {
+			return "", &EncodeError{Type: t, Reason: fmt.Sprintf("invalid capability %q", capability)}
+		}
+	}
+	return strings.Join(capabilities, listSeparator), nil
+}
+
+// decodeCapabilities Splits a list of capability names. Names unknown to
+// this version are kept, it is up to the negotiation to ignore them
+func decodeCapabilities(t MessageType, field string) ([]string, error) {
+	if field == "" {
+		return nil, nil
+	}
+	capabilities := strings.Split(field, listSeparator)
+	for _, capability := range capabilities {
+		if capability == "" || strings.Contains(capability, lineSeparator) {
+			return nil, &DecodeError{Type: t, Reason: fmt.Sprintf("invalid capability %q", capability)}
+		}
+	}
+	return capabilities, nil
+}
+
+func parseVersion(t MessageType, field string) (uint16, error) {
+	version, err := strconv.ParseUint(field, 10, 16)
+	if err != nil {
+		return 0, &DecodeError{Type: t, Reason: fmt.Sprintf("invalid version %q", field)}
+	}
+	return uint16(version), nil
+}
+
+func parseBatchID(t MessageType, field string) (uint32, error) {
+	batchID, err := strconv.ParseUint(field, 10, 32)
+	if err != nil {
+		return 0, &DecodeError{Type: t, Reason: fmt.Sprintf("invalid batch id %q", field)}
+	}
+	return uint32(batchID), nil
+}
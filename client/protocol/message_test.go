@@ -0,0 +1,134 @@
+package protocol
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/lottery"
+)
+
+var testBet = lottery.Bet{
+	Agency:    "1",
+	FirstName: "Santiago Lionel",
+	LastName:  "Lorca",
+	Document:  "30904465",
+	Birthdate: "1999-03-17",
+	Number:    "7574",
+}
+
+func TestMessagesRoundTrip(t *testing.T) {
+	messages := []Message{
+		Bets{BatchID: 1, Bets: []lottery.Bet{testBet, testBet}},
+		Bets{BatchID: 2, Bets: []lottery.Bet{}},
+		Ack{BatchID: 3, Count: 2},
+		Ack{},
+		Reject{BatchID: 4, Indexes: []int{0, 3}},
+		DeliveryEnded{Agency: "1"},
+		WinnersQuery{Agency: "1"},
+		Winners{Documents: []string{"30904465", "29665629"}},
+		Winners{},
+		WinnersPending{},
+		Hello{Version: Version, Agency: "1", Capabilities: []string{CapabilityPush, "future"}},
+		Hello{Version: 2, Agency: "1"},
+		HelloReply{Version: Version, Capabilities: []string{CapabilityPush}},
+		HelloReply{},
+	}
+
+	for _, m := range messages {
+		var buf bytes.Buffer
+		if err := WriteMessage(&buf, m); err != nil {
+			t.Fatalf("%+v: %v", m, err)
+		}
+		decoded, err := ReadMessage(&buf)
+		if err != nil {
+			t.Fatalf("%+v: %v", m, err)
+		}
+		if !reflect.DeepEqual(decoded, m) {
+			t.Fatalf("expected %+v, got %+v", m, decoded)
+		}
+	}
+}
+
+func TestBetsPayloadIsOneBetPerLine(t *testing.T) {
+	frame, err := Encode(Bets{BatchID: 9, Bets: []lottery.Bet{testBet}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "9\n1|Santiago Lionel|Lorca|30904465|1999-03-17|7574"
+	if string(frame.Payload) != expected {
+		t.Fatalf("expected %q, got %q", expected, frame.Payload)
+	}
+}
+
+func TestEncodeRejectsFieldsWithSeparators(t *testing.T) {
+	withPipe := testBet
+	withPipe.LastName = "Lorca|Pineda"
+	withNewline := testBet
+	withNewline.FirstName = "Santiago\nLionel"
+
+	for _, m := range []Message{
+		Bets{BatchID: 1, Bets: []lottery.Bet{withPipe}},
+		Bets{BatchID: 1, Bets: []lottery.Bet{withNewline}},
+		WinnersQuery{Agency: "1|2"},
+		Winners{Documents: []string{""}},
+		DeliveryEnded{},
+		WinnersQuery{},
+		Ack{BatchID: 1, Count: -1},
+		Reject{BatchID: 1, Indexes: []int{-1}},
+		Hello{Version: Version},
+		Hello{Version: Version, Agency: "1", Capabilities: []string{"a,b"}},
+		HelloReply{Capabilities: []string{""}},
+	} {
+		if _, err := Encode(m); err == nil {
+			t.Fatalf("expected %+v to fail", m)
+		}
+	}
+}
+
+func TestDecodeRejectsMalformedPayloads(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame Frame
+	}{
+		{name: "unknown type", frame: Frame{Type: 99}},
+		{name: "bets without batch id", frame: Frame{Type: TypeBets, Payload: []byte("")}},
+		{name: "bets with missing fields", frame: Frame{Type: TypeBets, Payload: []byte("1\n1|a|b")}},
+		{name: "ack without count", frame: Frame{Type: TypeAck, Payload: []byte("1")}},
+		{name: "ack with negative count", frame: Frame{Type: TypeAck, Payload: []byte("1|-2")}},
+		{name: "reject with invalid index", frame: Frame{Type: TypeReject, Payload: []byte("1|a")}},
+		{name: "delivery ended without agency", frame: Frame{Type: TypeDeliveryEnded}},
+		{name: "agency with separator", frame: Frame{Type: TypeWinnersQuery, Payload: []byte("1|2")}},
+		{name: "winners with empty document", frame: Frame{Type: TypeWinners, Payload: []byte("123|")}},
+		{name: "winners with multiline document", frame: Frame{Type: TypeWinners, Payload: []byte("12\n3")}},
+		{name: "hello without capabilities", frame: Frame{Type: TypeHello, Payload: []byte("1|1")}},
+		{name: "hello with invalid version", frame: Frame{Type: TypeHello, Payload: []byte("65536|1|")}},
+		{name: "hello with empty capability", frame: Frame{Type: TypeHello, Payload: []byte("1|1|push,")}},
+		{name: "hello reply without version", frame: Frame{Type: TypeHelloReply, Payload: []byte("|push")}},
+		{name: "pending with payload", frame: Frame{Type: TypeWinnersPending, Payload: []byte("x")}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := Decode(test.frame); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestNegotiateKeepsOnlyMutualCapabilities(t *testing.T) {
+	offered := []string{CapabilityPush, CapabilityCompression, CapabilityPush, CapabilityChecksums}
+	accepted := []string{CapabilityChecksums, "future", CapabilityPush}
+
+	enabled := Negotiate(offered, accepted)
+	if !reflect.DeepEqual(enabled, []string{CapabilityPush, CapabilityChecksums}) {
+		t.Fatalf("unexpected capabilities %v", enabled)
+	}
+	if !Has(enabled, CapabilityPush) || Has(enabled, CapabilityCompression) {
+		t.Fatalf("unexpected lookups on %v", enabled)
+	}
+	if Negotiate(offered, nil) != nil || Negotiate(nil, accepted) != nil {
+		t.Fatal("expected nothing enabled when one end supports nothing")
+	}
+}
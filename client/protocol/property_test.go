@@ -0,0 +1,171 @@
+package protocol
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/lottery"
+)
+
+// propertyIterations Random messages checked by every property
+const propertyIterations = 2000
+
+// alphabet Runes random fields are made of: ASCII, accents, multibyte
+// characters and punctuation, but none of the separators
+var alphabet = []rune("abcxyzABCXYZ0189 -.,'áéíóúñÑüÁ漢字🎲\t")
+
+func randomString(r *rand.Rand, maxLength int) string {
+	var b strings.Builder
+	for i := r.Intn(maxLength + 1); i > 0; i-- {
+		b.WriteRune(alphabet[r.Intn(len(alphabet))])
+	}
+	return b.String()
+}
+
+// randomNonEmpty Random string with at least one rune
+func randomNonEmpty(r *rand.Rand, maxLength int) string {
+	return string(alphabet[r.Intn(len(alphabet))]) + randomString(r, maxLength-1)
+}
+
+func randomBet(r *rand.Rand) lottery.Bet {
+	return lottery.Bet{
+		Agency:    randomString(r, 3),
+		FirstName: randomString(r, 30),
+		LastName:  randomString(r, 30),
+		Document:  randomString(r, 10),
+		Birthdate: randomString(r, 10),
+		Number:    randomString(r, 5),
+	}
+}
+
+func randomBatchID(r *rand.Rand) uint32 {
+	if r.Intn(10) == 0 {
+		return math.MaxUint32
+	}
+	return r.Uint32()
+}
+
+// randomMessage Random message of any type that the codec can encode
+func randomMessage(r *rand.Rand) Message {
+	switch r.Intn(9) {
+	case 0:
+		bets := make([]lottery.Bet, r.Intn(20))
+		for i := range bets {
+			bets[i] = randomBet(r)
+		}
+		return Bets{BatchID: randomBatchID(r), Bets: bets}
+	case 1:
+		return Ack{BatchID: randomBatchID(r), Count: r.Intn(math.MaxInt32)}
+	case 2:
+		indexes := make([]int, r.Intn(5))
+		for i := range indexes {
+			indexes[i] = r.Intn(1000)
+		}
+		return Reject{BatchID: randomBatchID(r), Indexes: indexes}
+	case 3:
+		return DeliveryEnded{Agency: randomNonEmpty(r, 3)}
+	case 4:
+		return WinnersQuery{Agency: randomNonEmpty(r, 3)}
+	case 5:
+		documents := make([]string, r.Intn(10))
+		for i := range documents {
+			documents[i] = randomNonEmpty(r, 10)
+		}
+		return Winners{Documents: documents}
+	case 6:
+		return Hello{Version: uint16(r.Intn(1 << 16)), Agency: randomNonEmpty(r, 3), Capabilities: randomCapabilities(r)}
+	case 7:
+		return HelloReply{Version: uint16(r.Intn(1 << 16)), Capabilities: randomCapabilities(r)}
+	default:
+		return WinnersPending{}
+	}
+}
+
+func randomCapabilities(r *rand.Rand) []string {
+	capabilities := make([]string, r.Intn(len(Capabilities)+1))
+	for i := range capabilities {
+		capabilities[i] = Capabilities[r.Intn(len(Capabilities))]
+	}
+	return capabilities
+}
+
+// equalMessages Compares messages treating nil and empty lists alike, as
+// the wire format cannot tell them apart
+func equalMessages(a Message, b Message) bool {
+	return reflect.DeepEqual(normalize(a), normalize(b))
+}
+
+func normalize(m Message) Message {
+	switch msg := m.(type) {
+	case Bets:
+		if len(msg.Bets) == 0 {
+			msg.Bets = nil
+		}
+		return msg
+	case Reject:
+		if len(msg.Indexes) == 0 {
+			msg.Indexes = nil
+		}
+		return msg
+	case Winners:
+		if len(msg.Documents) == 0 {
+			msg.Documents = nil
+		}
+		return msg
+	case Hello:
+		if len(msg.Capabilities) == 0 {
+			msg.Capabilities = nil
+		}
+		return msg
+	case HelloReply:
+		if len(msg.Capabilities) == 0 {
+			msg.Capabilities = nil
+		}
+		return msg
+	}
+	return m
+}
+
+func TestDecodeOfEncodeIsIdentity(t *testing.T) {
+	r := rand.New(rand.NewSource(7574))
+	for i := 0; i < propertyIterations; i++ {
+		msg := randomMessage(r)
+		frame, err := Encode(msg)
+		if err != nil {
+			t.Fatalf("cannot encode %+v: %v", msg, err)
+		}
+		decoded, err := Decode(frame)
+		if err != nil {
+			t.Fatalf("cannot decode %+v encoded as %q: %v", msg, frame.Payload, err)
+		}
+		if !equalMessages(msg, decoded) {
+			t.Fatalf("expected %+v, got %+v", msg, decoded)
+		}
+	}
+}
+
+func TestBetsRoundTripThroughTheWire(t *testing.T) {
+	r := rand.New(rand.NewSource(30904465))
+	for i := 0; i < propertyIterations; i++ {
+		bets := make([]lottery.Bet, 1+r.Intn(50))
+		for i := range bets {
+			bets[i] = randomBet(r)
+		}
+		batch := Bets{BatchID: randomBatchID(r), Bets: bets}
+
+		var wire strings.Builder
+		if err := WriteMessage(&wire, batch); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := ReadMessage(strings.NewReader(wire.String()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded, batch) {
+			t.Fatalf("expected %+v, got %+v", batch, decoded)
+		}
+	}
+}
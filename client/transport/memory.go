@@ -0,0 +1,134 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrNoListener A memory address nobody listens on
+var ErrNoListener = errors.New("transport: no listener")
+
+// Memory Network of in-memory listeners shared by the whole process, used by
+// the mem:// scheme
+var Memory = NewMemoryNetwork()
+
+// MemoryNetwork Transport whose connections are synchronous in-memory pipes
+// between the dialer and a listener of the same network, addressed by name
+type MemoryNetwork struct {
+	mu        sync.Mutex
+	listeners map[string]*memoryListener
+}
+
+// NewMemoryNetwork Creates a network without listeners, isolated from
+// Memory
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{listeners: make(map[string]*memoryListener)}
+}
+
+// Dial Connects to the listener of the given name, waiting at most timeout
+// for it to accept the connection. Zero waits until it does
+func (m *MemoryNetwork) Dial(name string, timeout time.Duration) (net.Conn, error) {
+	m.mu.Lock()
+	listener := m.listeners[name]
+	m.mu.Unlock()
+	if listener == nil {
+		return nil, &net.OpError{Op: "dial", Net: SchemeMemory, Addr: memoryAddr(name), Err: ErrNoListener}
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	client, server := net.Pipe()
+	select {
+	case listener.conns <- server:
+		return &memoryConn{Conn: client, local: memoryAddr(""), remote: memoryAddr(name)}, nil
+	case <-listener.done:
+		return nil, &net.OpError{Op: "dial", Net: SchemeMemory, Addr: memoryAddr(name), Err: ErrNoListener}
+	case <-expired:
+		return nil, &net.OpError{Op: "dial", Net: SchemeMemory, Addr: memoryAddr(name), Err: fmt.Errorf("listener did not accept within %v", timeout)}
+	}
+}
+
+// Listen Accepts the connections dialed to the given name until the listener
+// is closed. A name can only have one listener at a time
+func (m *MemoryNetwork) Listen(name string) (net.Listener, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.listeners[name]; ok {
+		return nil, &net.OpError{Op: "listen", Net: SchemeMemory, Addr: memoryAddr(name), Err: errors.New("address already in use")}
+	}
+	listener := &memoryListener{
+		network: m,
+		name:    name,
+		conns:   make(chan net.Conn),
+		done:    make(chan struct{}),
+	}
+	m.listeners[name] = listener
+	return listener, nil
+}
+
+// memoryListener Listener end of the pipes dialed to a name
+type memoryListener struct {
+	network *MemoryNetwork
+	name    string
+	conns   chan net.Conn
+	done    chan struct{}
+	once    sync.Once
+}
+
+func (l *memoryListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return &memoryConn{Conn: conn, local: memoryAddr(l.name), remote: memoryAddr("")}, nil
+	case <-l.done:
+		return nil, &net.OpError{Op: "accept", Net: SchemeMemory, Addr: memoryAddr(l.name), Err: net.ErrClosed}
+	}
+}
+
+// Close Stops accepting connections and frees the name. Connections already
+// accepted stay open
+func (l *memoryListener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+		l.network.mu.Lock()
+		delete(l.network.listeners, l.name)
+		l.network.mu.Unlock()
+	})
+	return nil
+}
+
+func (l *memoryListener) Addr() net.Addr {
+	return memoryAddr(l.name)
+}
+
+// memoryConn End of a pipe that reports memory addresses
+type memoryConn struct {
+	net.Conn
+	local  net.Addr
+	remote net.Addr
+}
+
+func (c *memoryConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *memoryConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// memoryAddr Name of an in-memory listener
+type memoryAddr string
+
+func (a memoryAddr) Network() string {
+	return SchemeMemory
+}
+
+func (a memoryAddr) String() string {
+	return string(a)
+}
@@ -0,0 +1,145 @@
+// Package transport opens the connections between the agencies and the
+// server over the kind of socket chosen by the scheme of the address:
+//
+//	tcp://server:12345   TCP, also used for addresses without a scheme
+//	unix:///run/tp0.sock Unix domain socket, for co-located deployments
+//	mem://lottery        In-memory pipe within the process, for tests
+//
+// Every transport hands out plain net.Conn values, so the framing, retries
+// and metrics built on top of them behave the same on all of them.
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Schemes of the supported transports
+const (
+	SchemeTCP    = "tcp"
+	SchemeUnix   = "unix"
+	SchemeMemory = "mem"
+)
+
+// ErrUnknownScheme An address with a scheme no transport implements
+var ErrUnknownScheme = errors.New("transport: unknown scheme")
+
+// Transport Opens and accepts connections over a kind of socket. Addresses
+// are given without the scheme
+type Transport interface {
+	// Dial Connects to the address, failing after timeout. Zero waits as
+	// long as the operating system allows
+	Dial(address string, timeout time.Duration) (net.Conn, error)
+	// Listen Accepts connections on the address
+	Listen(address string) (net.Listener, error)
+}
+
+// Parse Returns the transport selected by the scheme of the address along
+// with the address without the scheme. Addresses without a scheme are TCP
+func Parse(address string) (Transport, string, error) {
+	scheme, rest := SchemeTCP, address
+	if i := strings.Index(address, "://"); i >= 0 {
+		scheme, rest = address[:i], address[i+len("://"):]
+	}
+	if rest == "" {
+		return nil, "", fmt.Errorf("transport: empty address in %q", address)
+	}
+	switch scheme {
+	case SchemeTCP:
+		return TCP{}, rest, nil
+	case SchemeUnix:
+		return Unix{}, rest, nil
+	case SchemeMemory:
+		return Memory, rest, nil
+	}
+	return nil, "", fmt.Errorf("%w %q in address %q, expected %v, %v or %v", ErrUnknownScheme, scheme, address, SchemeTCP, SchemeUnix, SchemeMemory)
+}
+
+// Network Returns the network of the address, as the net package names
+// it, along with the address without the scheme, for dialers that are not a
+// Transport like *net.Dialer. In-memory addresses are of the mem network
+func Network(address string) (string, string, error) {
+	transport, rest, err := Parse(address)
+	if err != nil {
+		return "", "", err
+	}
+	switch transport.(type) {
+	case TCP:
+		return SchemeTCP, rest, nil
+	case Unix:
+		return SchemeUnix, rest, nil
+	}
+	return SchemeMemory, rest, nil
+}
+
+// Dial Connects to the address with the transport of its scheme
+func Dial(address string, timeout time.Duration) (net.Conn, error) {
+	transport, rest, err := Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	return transport.Dial(rest, timeout)
+}
+
+// Listen Accepts connections on the address with the transport of its scheme
+func Listen(address string) (net.Listener, error) {
+	transport, rest, err := Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	return transport.Listen(rest)
+}
+
+// URL Formats the address of a listener so that Parse selects its transport
+// back. TCP addresses are returned without a scheme
+func URL(addr net.Addr) string {
+	switch addr.Network() {
+	case SchemeUnix, SchemeMemory:
+		return addr.Network() + "://" + addr.String()
+	}
+	return addr.String()
+}
+
+// Host Returns the host of a TCP address, which names the server its
+// certificate must be valid for. Other transports have no host
+func Host(address string) (string, error) {
+	transport, rest, err := Parse(address)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := transport.(TCP); !ok {
+		return "", fmt.Errorf("transport: address %q has no host", address)
+	}
+	host, _, err := net.SplitHostPort(rest)
+	return host, err
+}
+
+// TCP Transport over TCP sockets
+type TCP struct{}
+
+// Dial Connects to the host:port address
+func (TCP) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("tcp", address, timeout)
+}
+
+// Listen Accepts connections on the host:port address
+func (TCP) Listen(address string) (net.Listener, error) {
+	return net.Listen("tcp", address)
+}
+
+// Unix Transport over Unix domain sockets, addressed by their path
+type Unix struct{}
+
+// Dial Connects to the socket at the path
+func (Unix) Dial(path string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("unix", path, timeout)
+}
+
+// Listen Creates the socket at the path and accepts connections on it. The
+// socket file is removed once the listener is closed
+func (Unix) Listen(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,145 @@
+package transport
+
+import (
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		address   string
+		transport Transport
+		rest      string
+	}{
+		{address: "server:12345", transport: TCP{}, rest: "server:12345"},
+		{address: "tcp://server:12345", transport: TCP{}, rest: "server:12345"},
+		{address: "unix:///run/tp0.sock", transport: Unix{}, rest: "/run/tp0.sock"},
+		{address: "unix://tp0.sock", transport: Unix{}, rest: "tp0.sock"},
+		{address: "mem://lottery", transport: Memory, rest: "lottery"},
+	}
+	for _, test := range tests {
+		transport, rest, err := Parse(test.address)
+		if err != nil {
+			t.Fatalf("%v: %v", test.address, err)
+		}
+		if !reflect.DeepEqual(transport, test.transport) || rest != test.rest {
+			t.Fatalf("%v: expected %T %q, got %T %q", test.address, test.transport, test.rest, transport, rest)
+		}
+	}
+
+	if _, _, err := Parse("udp://server:12345"); !errors.Is(err, ErrUnknownScheme) {
+		t.Fatalf("expected an unknown scheme, got %v", err)
+	}
+	if _, _, err := Parse("unix://"); err == nil {
+		t.Fatal("expected an empty address to fail")
+	}
+}
+
+// echo Accepts a single connection and echoes it until the client closes it
+func echo(t *testing.T, listener net.Listener) {
+	t.Helper()
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+}
+
+func TestEveryTransportCarriesData(t *testing.T) {
+	addresses := []string{
+		"tcp://127.0.0.1:0",
+		"unix://" + filepath.Join(t.TempDir(), "tp0.sock"),
+		"mem://echo",
+	}
+	for _, address := range addresses {
+		t.Run(address, func(t *testing.T) {
+			listener, err := Listen(address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			echo(t, listener)
+
+			conn, err := Dial(URL(listener.Addr()), time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(time.Second))
+			if _, err := conn.Write([]byte("ping")); err != nil {
+				t.Fatal(err)
+			}
+			reply := make([]byte, 4)
+			if _, err := io.ReadFull(conn, reply); err != nil || string(reply) != "ping" {
+				t.Fatalf("expected the data back, got %q %v", reply, err)
+			}
+		})
+	}
+}
+
+func TestMemoryNetwork(t *testing.T) {
+	network := NewMemoryNetwork()
+	if _, err := network.Dial("lottery", time.Second); !errors.Is(err, ErrNoListener) {
+		t.Fatalf("expected no listener, got %v", err)
+	}
+
+	listener, err := network.Listen("lottery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := network.Listen("lottery"); err == nil {
+		t.Fatal("expected a name to have a single listener")
+	}
+	if _, err := Memory.Dial("lottery", time.Second); !errors.Is(err, ErrNoListener) {
+		t.Fatalf("expected networks to be isolated, got %v", err)
+	}
+	// Nobody accepts, so the dial gives up
+	if _, err := network.Dial("lottery", 10*time.Millisecond); err == nil {
+		t.Fatal("expected the dial to time out")
+	}
+
+	listener.Close()
+	if _, err := listener.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected a closed listener, got %v", err)
+	}
+	if _, err := network.Listen("lottery"); err != nil {
+		t.Fatalf("expected the name to be free once closed, got %v", err)
+	}
+}
+
+func TestHost(t *testing.T) {
+	if host, err := Host("tcp://server:12345"); err != nil || host != "server" {
+		t.Fatalf("expected server, got %q %v", host, err)
+	}
+	if host, err := Host("server:12345"); err != nil || host != "server" {
+		t.Fatalf("expected server, got %q %v", host, err)
+	}
+	if _, err := Host("unix:///run/tp0.sock"); err == nil {
+		t.Fatal("expected a unix address to have no host")
+	}
+}
+
+func TestNetwork(t *testing.T) {
+	for address, expected := range map[string][2]string{
+		"server:12345":         {"tcp", "server:12345"},
+		"tcp://server:12345":   {"tcp", "server:12345"},
+		"unix:///run/tp0.sock": {"unix", "/run/tp0.sock"},
+		"mem://lottery":        {"mem", "lottery"},
+	} {
+		network, rest, err := Network(address)
+		if err != nil || network != expected[0] || rest != expected[1] {
+			t.Fatalf("%v: expected %v, got %q %q %v", address, expected, network, rest, err)
+		}
+	}
+	if _, _, err := Network("udp://server:12345"); !errors.Is(err, ErrUnknownScheme) {
+		t.Fatalf("expected an unknown scheme, got %v", err)
+	}
+}
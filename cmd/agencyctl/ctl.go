@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/rpc/jsonrpc"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/lottery"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/transport"
+)
+
+const usage = "status | pause | resume | bet NOMBRE APELLIDO DOCUMENTO NACIMIENTO NUMERO | winners | loglevel LEVEL"
+
+// errUsage The command or its arguments are not valid
+var errUsage = errors.New("agencyctl: invalid command")
+
+// call Method of the control service a command maps to, with its arguments
+// and the value its reply is decoded into
+type call struct {
+	method string
+	args   interface{}
+	reply  interface{}
+}
+
+// parseCommand Maps the command line arguments to the call they stand for
+func parseCommand(args []string) (call, error) {
+	if len(args) == 0 {
+		return call{}, errUsage
+	}
+	name, params := args[0], args[1:]
+	switch {
+	case name == "status" && len(params) == 0:
+		return call{method: "Status", args: common.Empty{}, reply: &common.Status{}}, nil
+	case name == "pause" && len(params) == 0:
+		return call{method: "Pause", args: common.Empty{}, reply: &common.Status{}}, nil
+	case name == "resume" && len(params) == 0:
+		return call{method: "Resume", args: common.Empty{}, reply: &common.Status{}}, nil
+	case name == "bet" && len(params) == 5:
+		bet := lottery.JSONBetData{
+			FirstName:      params[0],
+			LastName:       params[1],
+			DocumentNumber: params[2],
+			BirthDate:      params[3],
+			Number:         params[4],
+		}
+		return call{method: "SubmitBet", args: bet, reply: &common.SubmitReply{}}, nil
+	case name == "winners" && len(params) == 0:
+		return call{method: "QueryWinners", args: common.Empty{}, reply: &common.WinnersReply{}}, nil
+	case name == "loglevel" && len(params) == 1:
+		return call{method: "SetLogLevel", args: common.LogLevelArgs{Level: params[0]}, reply: &common.Status{}}, nil
+	}
+	return call{}, errUsage
+}
+
+// run Sends the command to the control service at address and writes its
+// reply to out as indented JSON
+func run(out io.Writer, address string, timeout time.Duration, args []string) error {
+	c, err := parseCommand(args)
+	if err != nil {
+		return err
+	}
+	conn, err := transport.Dial(address, timeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	client := jsonrpc.NewClient(conn)
+	defer client.Close()
+
+	if err := client.Call(common.ControlServiceName+"."+c.method, c.args, c.reply); err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(c.reply)
+}
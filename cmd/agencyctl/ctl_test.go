@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/op/go-logging"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/fakeserver"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/metrics"
+)
+
+// startControl Starts a client of agency 3, with its control service on a
+// unix socket, in front of a fake lottery server
+func startControl(t *testing.T) (*fakeserver.Server, string) {
+	t.Helper()
+	logging.SetLevel(logging.ERROR, "log")
+	server, err := fakeserver.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	client := common.NewClient(common.ClientConfig{
+		ID:            "3",
+		ServerAddress: server.Addr(),
+		Mode:          common.ModeBets,
+		Timeout:       time.Second,
+		RetryAttempts: 1,
+	}, common.NewClientMetrics(metrics.NewRegistry()))
+	control, err := common.NewControlServer("unix://"+filepath.Join(t.TempDir(), "control.sock"), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := control.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { control.Close() })
+	return server, control.Addr()
+}
+
+func TestCommandsReachTheControlService(t *testing.T) {
+	server, address := startControl(t)
+	server.SetWinners("3", "30904465")
+
+	tests := []struct {
+		args     []string
+		expected map[string]interface{}
+	}{
+		{args: []string{"pause"}, expected: map[string]interface{}{"id": "3", "paused": true}},
+		{args: []string{"status"}, expected: map[string]interface{}{"mode": "bets", "paused": true}},
+		{args: []string{"bet", "Santiago Lionel", "Lorca", "30904465", "1999-03-17", "7574"}, expected: map[string]interface{}{"rejected": false}},
+		{args: []string{"winners"}, expected: map[string]interface{}{"pending": false, "winners": []interface{}{"30904465"}}},
+		{args: []string{"loglevel", "WARNING"}, expected: map[string]interface{}{"log_level": "WARNING"}},
+		{args: []string{"resume"}, expected: map[string]interface{}{"paused": false, "bets_sent": 1.0}},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		if err := run(&out, address, time.Second, test.args); err != nil {
+			t.Fatalf("%v: %v", test.args, err)
+		}
+		var reply map[string]interface{}
+		if err := json.Unmarshal(out.Bytes(), &reply); err != nil {
+			t.Fatalf("%v: expected a JSON reply, got %q", test.args, out.String())
+		}
+		for key, value := range test.expected {
+			if mustMarshal(reply[key]) != mustMarshal(value) {
+				t.Errorf("%v: expected %v to be %v, got %v", test.args, key, value, reply[key])
+			}
+		}
+	}
+	if bets := server.Bets("3"); len(bets) != 1 || bets[0].Document != "30904465" {
+		t.Fatalf("expected the bet to be stored, got %+v", bets)
+	}
+}
+
+func TestInvalidCommandsAreNotSent(t *testing.T) {
+	_, address := startControl(t)
+	for _, args := range [][]string{nil, {"draw"}, {"pause", "now"}, {"bet", "Santiago"}, {"loglevel"}} {
+		if err := run(&bytes.Buffer{}, address, time.Second, args); err != errUsage {
+			t.Errorf("%v: expected a usage error, got %v", args, err)
+		}
+	}
+	if err := run(&bytes.Buffer{}, address, time.Second, []string{"loglevel", "LOUD"}); err == nil {
+		t.Error("expected the service to refuse an unknown log level")
+	}
+	if err := run(&bytes.Buffer{}, address, time.Second, []string{"bet", "Santiago", "Lorca", "not-a-document", "1999-03-17", "7574"}); err == nil {
+		t.Error("expected the service to refuse an invalid bet")
+	}
+}
+
+// mustMarshal Encodes the value, so values decoded from JSON compare equal
+// to the expected ones
+func mustMarshal(value interface{}) string {
+	encoded, _ := json.Marshal(value)
+	return string(encoded)
+}
@@ -0,0 +1,35 @@
+// Command agencyctl calls the JSON-RPC control service of a running client,
+// enabled with CLI_CONTROL_ENABLED, and prints its reply as JSON:
+//
+//	agencyctl [-address 127.0.0.1:9101] status
+//	agencyctl pause | resume
+//	agencyctl bet NOMBRE APELLIDO DOCUMENTO NACIMIENTO NUMERO
+//	agencyctl winners
+//	agencyctl loglevel DEBUG
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+func main() {
+	address := flag.String("address", "127.0.0.1:9101", "address of the control service: host:port, unix:///path or tcp://host:port")
+	timeout := flag.Duration("timeout", 10*time.Second, "maximum time to wait for the reply")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: agencyctl [flags] %v\n", usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if err := run(os.Stdout, *address, *timeout, flag.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "action: agencyctl | result: fail | error: %v\n", err)
+		if err == errUsage {
+			flag.Usage()
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
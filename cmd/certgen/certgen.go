@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/pki"
+)
+
+// Files written to the output directory, along with agency-N.pem and
+// agency-N-key.pem for every agency
+const (
+	CAFile        = "ca.pem"
+	CAKeyFile     = "ca-key.pem"
+	ServerFile    = "server.pem"
+	ServerKeyFile = "server-key.pem"
+	OverridesFile = "tls-overrides.yaml"
+)
+
+// MountDirectory Directory the certificates are mounted in inside the
+// client containers
+const MountDirectory = "/certs"
+
+const authorityName = "Lotería Nacional local CA"
+
+// Options What Generate issues and where
+type Options struct {
+	Dir      string
+	Hosts    []string
+	Agencies int
+	// Validity Time the new certificates are valid for. Zero means
+	// pki.DefaultValidity
+	Validity time.Duration
+}
+
+// agencyFiles Certificate and key files of an agency
+func agencyFiles(agency int) (string, string) {
+	name := pki.AgencyName(strconv.Itoa(agency))
+	return name + ".pem", name + "-key.pem"
+}
+
+// Generate Writes the authority, unless the directory already has one, and
+// the certificates of the server and of every agency. Returns true if a new
+// authority was created
+func Generate(options Options) (bool, error) {
+	if err := os.MkdirAll(options.Dir, 0755); err != nil {
+		return false, err
+	}
+	authority, created, err := loadOrCreateAuthority(options.Dir)
+	if err != nil {
+		return false, err
+	}
+	authority.Validity = options.Validity
+
+	server, err := authority.IssueServer(options.Hosts...)
+	if err != nil {
+		return false, err
+	}
+	if err := server.WriteFiles(filepath.Join(options.Dir, ServerFile), filepath.Join(options.Dir, ServerKeyFile)); err != nil {
+		return false, err
+	}
+
+	for agency := 1; agency <= options.Agencies; agency++ {
+		issued, err := authority.IssueAgency(strconv.Itoa(agency))
+		if err != nil {
+			return false, err
+		}
+		certFile, keyFile := agencyFiles(agency)
+		if err := issued.WriteFiles(filepath.Join(options.Dir, certFile), filepath.Join(options.Dir, keyFile)); err != nil {
+			return false, err
+		}
+	}
+
+	data, err := yaml.Marshal(overrides(options))
+	if err != nil {
+		return false, err
+	}
+	return created, os.WriteFile(filepath.Join(options.Dir, OverridesFile), data, 0644)
+}
+
+func loadOrCreateAuthority(dir string) (*pki.Authority, bool, error) {
+	certFile, keyFile := filepath.Join(dir, CAFile), filepath.Join(dir, CAKeyFile)
+	if _, err := os.Stat(certFile); err == nil {
+		authority, err := pki.LoadAuthority(certFile, keyFile)
+		if err != nil {
+			return nil, false, fmt.Errorf("%v: %v", certFile, err)
+		}
+		return authority, false, nil
+	}
+	authority, err := pki.NewAuthority(authorityName)
+	if err != nil {
+		return nil, false, err
+	}
+	return authority, true, authority.WriteFiles(certFile, keyFile)
+}
+
+// clientOverride Subset of the client overrides understood by composegen
+type clientOverride struct {
+	Environment []string `yaml:"environment"`
+	Volumes     []string `yaml:"volumes"`
+}
+
+// overrides Builds the composegen overrides that make every client connect
+// with mutual TLS, mounting the authority and its own certificate and key
+func overrides(options Options) map[string]map[int]clientOverride {
+	clients := make(map[int]clientOverride, options.Agencies)
+	for agency := 1; agency <= options.Agencies; agency++ {
+		certFile, keyFile := agencyFiles(agency)
+		clients[agency] = clientOverride{
+			Environment: []string{
+				"CLI_TLS_ENABLED=true",
+				"CLI_TLS_CAFILE=" + MountDirectory + "/" + CAFile,
+				"CLI_TLS_CERTFILE=" + MountDirectory + "/agency.pem",
+				"CLI_TLS_KEYFILE=" + MountDirectory + "/agency-key.pem",
+			},
+			Volumes: []string{
+				mount(options.Dir, CAFile, CAFile),
+				mount(options.Dir, certFile, "agency.pem"),
+				mount(options.Dir, keyFile, "agency-key.pem"),
+			},
+		}
+	}
+	return map[string]map[int]clientOverride{"clients": clients}
+}
+
+// mount Read only volume of a file of the directory, relative to the
+// directory of the compose file
+func mount(dir string, file string, target string) string {
+	source := filepath.ToSlash(filepath.Join(dir, file))
+	if !filepath.IsAbs(dir) {
+		source = "./" + source
+	}
+	return fmt.Sprintf("%v:%v/%v:ro", source, MountDirectory, target)
+}
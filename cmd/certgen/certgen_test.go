@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/pki"
+)
+
+func TestGenerateIssuesEveryCertificate(t *testing.T) {
+	dir := t.TempDir()
+	created, err := Generate(Options{Dir: dir, Hosts: []string{"server", "127.0.0.1"}, Agencies: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created {
+		t.Fatal("expected a new authority")
+	}
+	authority, err := pki.LoadAuthority(filepath.Join(dir, CAFile), filepath.Join(dir, CAKeyFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := loadCertificate(t, filepath.Join(dir, ServerFile), filepath.Join(dir, ServerKeyFile))
+	if _, err := server.Verify(x509.VerifyOptions{DNSName: "server", Roots: authority.Pool()}); err != nil {
+		t.Fatal(err)
+	}
+	for _, agency := range []string{"1", "2", "3"} {
+		certificate := loadCertificate(t,
+			filepath.Join(dir, "agency-"+agency+".pem"),
+			filepath.Join(dir, "agency-"+agency+"-key.pem"),
+		)
+		if _, err := certificate.Verify(x509.VerifyOptions{
+			Roots:     authority.Pool(),
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := pki.AgencyOf(certificate); err != nil || got != agency {
+			t.Fatalf("expected agency %v, got %q %v", agency, got, err)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(dir, "agency-1-key.pem")); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("expected a private key readable only by its owner, got %v %v", info.Mode(), err)
+	}
+
+	// A second run keeps the authority the server already trusts
+	created, err = Generate(Options{Dir: dir, Hosts: []string{"server"}, Agencies: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created {
+		t.Fatal("expected the authority to be reused")
+	}
+	fourth := loadCertificate(t, filepath.Join(dir, "agency-4.pem"), filepath.Join(dir, "agency-4-key.pem"))
+	if _, err := fourth.Verify(x509.VerifyOptions{
+		Roots:     authority.Pool(),
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOverridesMountOnlyTheCertificateOfEachAgency(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := Generate(Options{Dir: dir, Hosts: []string{"server"}, Agencies: 2}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, OverridesFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed struct {
+		Clients map[int]clientOverride `yaml:"clients"`
+	}
+	if err := yaml.UnmarshalStrict(data, &parsed); err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.Clients) != 2 {
+		t.Fatalf("expected overrides for 2 clients, got %v", parsed.Clients)
+	}
+	expected := []string{
+		dir + "/ca.pem:/certs/ca.pem:ro",
+		dir + "/agency-2.pem:/certs/agency.pem:ro",
+		dir + "/agency-2-key.pem:/certs/agency-key.pem:ro",
+	}
+	if volumes := parsed.Clients[2].Volumes; !reflect.DeepEqual(volumes, expected) {
+		t.Fatalf("unexpected volumes %v", volumes)
+	}
+
+	if volume := mount(".certs", "ca.pem", "ca.pem"); volume != "./.certs/ca.pem:/certs/ca.pem:ro" {
+		t.Fatalf("expected relative directories to be relative to the compose file, got %v", volume)
+	}
+}
+
+func TestGenerateRejectsInvalidOptions(t *testing.T) {
+	if _, err := Generate(Options{Dir: t.TempDir(), Agencies: 1}); err == nil {
+		t.Fatal("expected a server certificate without hosts to fail")
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, CAFile), []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Generate(Options{Dir: dir, Hosts: []string{"server"}, Agencies: 1}); err == nil {
+		t.Fatal("expected an unreadable authority to fail instead of being replaced")
+	}
+}
+
+func loadCertificate(t *testing.T, certFile string, keyFile string) *x509.Certificate {
+	t.Helper()
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate
+}
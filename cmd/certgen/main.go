@@ -0,0 +1,50 @@
+// Command certgen issues the certificates of the compose environment: a local
+// certificate authority, a server certificate and a client certificate per
+// agency, named agency-N so the server can tell which agency connects. It
+// also writes an overrides file for composegen that enables mutual TLS in
+// every client and mounts only its own certificate and key.
+//
+// An authority already present in the directory is reused, so more agencies
+// can be added without issuing the certificates of the server again.
+//
+// Usage:
+//
+//	certgen [-dir .certs] [-hosts server,localhost,127.0.0.1] <agencies amount>
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	dir := flag.String("dir", ".certs", "directory the certificates are written to")
+	hosts := flag.String("hosts", "server,localhost,127.0.0.1", "comma separated names the server certificate is valid for")
+	validity := flag.Duration("validity", 0, "time the certificates are valid for, one year by default")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-dir dir] [-hosts hosts] [-validity duration] <agencies amount>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	agencies, err := strconv.Atoi(flag.Arg(0))
+	if err != nil || agencies < 0 {
+		fmt.Fprintf(os.Stderr, "action: generate_certs | result: fail | error: invalid amount of agencies %q\n", flag.Arg(0))
+		os.Exit(2)
+	}
+
+	options := Options{Dir: *dir, Hosts: strings.Split(*hosts, ","), Agencies: agencies, Validity: *validity}
+	created, err := Generate(options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "action: generate_certs | result: fail | error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("action: generate_certs | result: success | dir: %v | agencies: %v | new_authority: %v\n", *dir, agencies, created)
+}
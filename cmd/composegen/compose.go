@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Defaults of the generated definition. They match the hand written
+// docker-compose-dev.yaml
+const (
+	ProjectName   = "tp0"
+	NetworkName   = "testing_net"
+	NetworkSubnet = "172.25.125.0/24"
+	ServerAddress = "server:12345"
+)
+
+// DatasetPath Path where the dataset of every agency is mounted inside its
+// client container
+const DatasetPath = "/data/agency.csv"
+
+// Compose Subset of the Docker Compose file format used by the project
+type Compose struct {
+	Name     string             `yaml:"name"`
+	Services yaml.MapSlice      `yaml:"services"`
+	Networks map[string]Network `yaml:"networks"`
+}
+
+// Service Definition of a single container
+type Service struct {
+	ContainerName string   `yaml:"container_name"`
+	Image         string   `yaml:"image"`
+	Entrypoint    string   `yaml:"entrypoint"`
+	Environment   []string `yaml:"environment,omitempty"`
+	Volumes       []string `yaml:"volumes,omitempty"`
+	Networks      []string `yaml:"networks"`
+	DependsOn     []string `yaml:"depends_on,omitempty"`
+}
+
+// Network Network shared by every service
+type Network struct {
+	IPAM IPAM `yaml:"ipam"`
+}
+
+// IPAM IP address management of a network
+type IPAM struct {
+	Driver string         `yaml:"driver"`
+	Config []SubnetConfig `yaml:"config"`
+}
+
+// SubnetConfig Subnet assigned to a network
+type SubnetConfig struct {
+	Subnet string `yaml:"subnet"`
+}
+
+// Overrides Optional customizations applied on top of the generated
+// definition. Clients are keyed by agency number
+type Overrides struct {
+	Clients map[int]ServiceOverride `yaml:"clients"`
+}
+
+// ServiceOverride Fields of a service that can be customized. Environment
+// variables replace the generated ones with the same name and volumes are
+// appended to the generated ones. Empty fields keep the generated values
+type ServiceOverride struct {
+	Image       string   `yaml:"image"`
+	Entrypoint  string   `yaml:"entrypoint"`
+	Environment []string `yaml:"environment"`
+	Volumes     []string `yaml:"volumes"`
+}
+
+// ParseOverrides Parses an overrides file and checks that every client it
+// mentions is part of the generated definition
+func ParseOverrides(data []byte, clients int) (Overrides, error) {
+	var overrides Overrides
+	if err := yaml.UnmarshalStrict(data, &overrides); err != nil {
+		return Overrides{}, err
+	}
+
+	ids := make([]int, 0, len(overrides.Clients))
+	for id := range overrides.Clients {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		if id < 1 || id > clients {
+			return Overrides{}, fmt.Errorf("override for client %v but only %v clients are generated", id, clients)
+		}
+		for _, variable := range overrides.Clients[id].Environment {
+			if !strings.Contains(variable, "=") {
+				return Overrides{}, fmt.Errorf("invalid environment variable %q for client %v, expected NAME=value", variable, id)
+			}
+		}
+	}
+	return overrides, nil
+}
+
+// Generate Builds the compose definition of the server and the given amount
+// of clients
+func Generate(clients int, overrides Overrides) (Compose, error) {
+	if clients < 0 {
+		return Compose{}, fmt.Errorf("invalid amount of clients %v", clients)
+	}
+
+	services := yaml.MapSlice{{Key: "server", Value: serverService()}}
+	for id := 1; id <= clients; id++ {
+		service := clientService(id)
+		if override, ok := overrides.Clients[id]; ok {
+			service = override.apply(service)
+		}
+		services = append(services, yaml.MapItem{Key: fmt.Sprintf("client%v", id), Value: service})
+	}
+
+	return Compose{
+		Name:     ProjectName,
+		Services: services,
+		Networks: map[string]Network{
+			NetworkName: {
+				IPAM: IPAM{
+					Driver: "default",
+					Config: []SubnetConfig{{Subnet: NetworkSubnet}},
+				},
+			},
+		},
+	}, nil
+}
+
+// Render Serializes the compose definition as YAML
+func Render(compose Compose) ([]byte, error) {
+	return yaml.Marshal(compose)
+}
+
+func serverService() Service {
+	return Service{
+		ContainerName: "server",
+		Image:         "server:latest",
+		Entrypoint:    "python3 /main.py",
+		Environment: []string{
+			"PYTHONUNBUFFERED=1",
+			"LOGGING_LEVEL=DEBUG",
+		},
+		Volumes: []string{
+			"./server/config.ini:/config.ini",
+		},
+		Networks: []string{NetworkName},
+	}
+}
+
+func clientService(id int) Service {
+	return Service{
+		ContainerName: fmt.Sprintf("client%v", id),
+		Image:         "client:latest",
+		Entrypoint:    "/client",
+		Environment: []string{
+			fmt.Sprintf("CLI_ID=%v", id),
+			"CLI_LOG_LEVEL=DEBUG",
+		},
+		Volumes: []string{
+			"./client/config.yaml:/config.yaml",
+			fmt.Sprintf("./.data/agency-%v.csv:%v", id, DatasetPath),
+		},
+		Networks:  []string{NetworkName},
+		DependsOn: []string{"server"},
+	}
+}
+
+func (o ServiceOverride) apply(service Service) Service {
+	if o.Image != "" {
+		service.Image = o.Image
+	}
+	if o.Entrypoint != "" {
+		service.Entrypoint = o.Entrypoint
+	}
+
+	environment := append([]string(nil), service.Environment...)
+	for _, variable := range o.Environment {
+		name := variable[:strings.Index(variable, "=")+1]
+		replaced := false
+		for i, current := range environment {
+			if strings.HasPrefix(current, name) {
+				environment[i] = variable
+				replaced = true
+			}
+		}
+		if !replaced {
+			environment = append(environment, variable)
+		}
+	}
+	service.Environment = environment
+	service.Volumes = append(append([]string(nil), service.Volumes...), o.Volumes...)
+	return service
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files with the current output")
+
+func assertGolden(t *testing.T, name string, actual []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.WriteFile(path, actual, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(expected, actual) {
+		t.Fatalf("%s does not match the generated output:\n%s", path, actual)
+	}
+}
+
+func TestGenerateMatchesGoldenFiles(t *testing.T) {
+	overrides, err := os.ReadFile(filepath.Join("testdata", "overrides.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		clients   int
+		overrides []byte
+	}{
+		{name: "no_clients", clients: 0},
+		{name: "one_client", clients: 1},
+		{name: "five_clients", clients: 5},
+		{name: "three_clients_with_overrides", clients: 3, overrides: overrides},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parsed, err := ParseOverrides(test.overrides, test.clients)
+			if err != nil {
+				t.Fatal(err)
+			}
+			compose, err := Generate(test.clients, parsed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rendered, err := Render(compose)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertGolden(t, test.name, rendered)
+		})
+	}
+}
+
+func TestParseOverridesRejectsInvalidFiles(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "client out of range", data: "clients:\n  4:\n    image: client:debug\n"},
+		{name: "client zero", data: "clients:\n  0:\n    image: client:debug\n"},
+		{name: "unknown field", data: "clients:\n  1:\n    ports: [\"80:80\"]\n"},
+		{name: "environment without value", data: "clients:\n  1:\n    environment: [CLI_ID]\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := ParseOverrides([]byte(test.data), 3); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestGenerateRejectsNegativeAmount(t *testing.T) {
+	if _, err := Generate(-1, Overrides{}); err == nil {
+		t.Fatal("expected an error")
+	}
+}
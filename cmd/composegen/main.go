@@ -0,0 +1,63 @@
+// Command composegen renders a Docker Compose definition with the server and
+// a configurable amount of clients, named client1, client2, etc.
+//
+// Usage:
+//
+//	composegen [-overrides overrides.yaml] <output file> <clients amount>
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+func main() {
+	overridesPath := flag.String("overrides", "", "optional YAML file with per client overrides")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-overrides file] <output file> <clients amount>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	output := flag.Arg(0)
+	clients, err := strconv.Atoi(flag.Arg(1))
+	if err != nil || clients < 0 {
+		fmt.Fprintf(os.Stderr, "action: generate_compose | result: fail | error: invalid amount of clients %q\n", flag.Arg(1))
+		os.Exit(2)
+	}
+
+	if err := run(output, clients, *overridesPath); err != nil {
+		fmt.Fprintf(os.Stderr, "action: generate_compose | result: fail | error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("action: generate_compose | result: success | file: %v | clients: %v\n", output, clients)
+}
+
+func run(output string, clients int, overridesPath string) error {
+	var overrides Overrides
+	if overridesPath != "" {
+		data, err := os.ReadFile(overridesPath)
+		if err != nil {
+			return err
+		}
+		if overrides, err = ParseOverrides(data, clients); err != nil {
+			return fmt.Errorf("%v: %v", overridesPath, err)
+		}
+	}
+
+	compose, err := Generate(clients, overrides)
+	if err != nil {
+		return err
+	}
+	data, err := Render(compose)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(output, data, 0644)
+}
@@ -0,0 +1,93 @@
+// Command devrun runs the server and N clients as local child processes,
+// without Docker. Every child gets the environment the compose file would
+// give it, its output is prefixed with its name like docker compose logs and
+// SIGTERM/SIGINT are forwarded to all of them.
+//
+// Usage:
+//
+//	devrun [-clients 5] [-port 12345] [-server "python3 main.py"] [-client bin/client]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func main() {
+	clients := flag.Int("clients", 1, "amount of clients to run")
+	port := flag.Int("port", 12345, "port the server listens on")
+	serverCmd := flag.String("server", "python3 main.py", "command that starts the server")
+	serverDir := flag.String("server-dir", "server", "working directory of the server")
+	clientCmd := flag.String("client", "bin/client", "command that starts a client")
+	clientDir := flag.String("client-dir", "client", "working directory of the clients")
+	startupTimeout := flag.Duration("startup-timeout", 10*time.Second, "maximum time to wait for the server to accept connections")
+	stopTimeout := flag.Duration("stop-timeout", 10*time.Second, "time children have to exit after SIGTERM before being killed")
+	flag.Parse()
+
+	server, err := processSpec("server", *serverCmd, *serverDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "action: devrun | result: fail | error: %v\n", err)
+		os.Exit(2)
+	}
+	server.Env = []string{fmt.Sprintf("SERVER_PORT=%v", *port)}
+
+	serverAddress := fmt.Sprintf("127.0.0.1:%v", *port)
+	plan := Plan{Server: server, ServerAddress: serverAddress}
+	for id := 1; id <= *clients; id++ {
+		client, err := processSpec(fmt.Sprintf("client%v", id), *clientCmd, *clientDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "action: devrun | result: fail | error: %v\n", err)
+			os.Exit(2)
+		}
+		client.Env = []string{
+			fmt.Sprintf("CLI_ID=%v", id),
+			fmt.Sprintf("CLI_SERVER_ADDRESS=%v", serverAddress),
+		}
+		plan.Clients = append(plan.Clients, client)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	orchestrator := NewOrchestrator(os.Stdout, *startupTimeout, *stopTimeout)
+	statuses, err := orchestrator.Run(plan, signals)
+	if err != nil {
+		os.Exit(1)
+	}
+	os.Exit(exitCode(statuses))
+}
+
+// processSpec Builds the spec of a child from a command line. Paths are made
+// absolute because children run in their own working directory
+func processSpec(name string, command string, dir string) (ProcessSpec, error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return ProcessSpec{}, fmt.Errorf("empty command for %v", name)
+	}
+	path := fields[0]
+	if strings.ContainsRune(path, filepath.Separator) {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return ProcessSpec{}, err
+		}
+		path = abs
+	}
+	return ProcessSpec{Name: name, Path: path, Args: fields[1:], Dir: dir}, nil
+}
+
+// exitCode devrun succeeds only if every client exited with code 0. The
+// server is expected to be stopped by devrun, so its status is ignored
+func exitCode(statuses []ExitStatus) int {
+	for _, status := range statuses[1:] {
+		if status.Err != nil || status.Signal != "" || status.Code != 0 {
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// orchestratorName Prefix used for the lines written by devrun itself
+const orchestratorName = "devrun"
+
+// ProcessSpec Describes how to start a child process
+type ProcessSpec struct {
+	Name string
+	Path string
+	Args []string
+	Dir  string
+	// Env Variables added to the environment inherited from devrun
+	Env []string
+}
+
+// Plan Processes run by the orchestrator. The server is started first and
+// clients are only started once ServerAddress accepts connections
+type Plan struct {
+	Server        ProcessSpec
+	ServerAddress string
+	Clients       []ProcessSpec
+}
+
+// ExitStatus How a child process finished
+type ExitStatus struct {
+	Name string
+	// Code Exit code of the process, -1 if it was killed by a signal or
+	// could not be started
+	Code   int
+	Signal string
+	Err    error
+}
+
+// Orchestrator Runs a server and its clients as child processes, multiplexing
+// their output like docker compose logs does
+type Orchestrator struct {
+	out            io.Writer
+	mu             sync.Mutex
+	width          int
+	startupTimeout time.Duration
+	stopTimeout    time.Duration
+}
+
+// NewOrchestrator Initializes an orchestrator that writes every line to out.
+// startupTimeout bounds the wait for the server to accept connections and
+// stopTimeout the time children have to exit after SIGTERM before being killed
+func NewOrchestrator(out io.Writer, startupTimeout time.Duration, stopTimeout time.Duration) *Orchestrator {
+	return &Orchestrator{
+		out:            out,
+		startupTimeout: startupTimeout,
+		stopTimeout:    stopTimeout,
+	}
+}
+
+// child Running process and the state needed to wait for it
+type child struct {
+	spec   ProcessSpec
+	cmd    *exec.Cmd
+	done   chan struct{}
+	status ExitStatus
+}
+
+// Run Executes the plan until every client exits or a signal arrives. Signals
+// are forwarded to every child as SIGTERM. The server is stopped once all the
+// clients are done. Returns the exit status of every child, server first, and
+// an error if the server never accepted connections
+func (o *Orchestrator) Run(plan Plan, signals <-chan os.Signal) ([]ExitStatus, error) {
+	o.width = len(orchestratorName)
+	for _, spec := range append([]ProcessSpec{plan.Server}, plan.Clients...) {
+		if len(spec.Name) > o.width {
+			o.width = len(spec.Name)
+		}
+	}
+
+	server, err := o.start(plan.Server)
+	if err != nil {
+		return []ExitStatus{server.status}, err
+	}
+	children := []*child{server}
+
+	if err := o.waitServer(plan.ServerAddress, server, signals); err != nil {
+		o.logf("action: wait_server | result: fail | address: %v | error: %v", plan.ServerAddress, err)
+		o.stop(children)
+		return o.report(children), err
+	}
+	o.logf("action: wait_server | result: success | address: %v", plan.ServerAddress)
+
+	clients := make([]*child, 0, len(plan.Clients))
+	for _, spec := range plan.Clients {
+		c, _ := o.start(spec)
+		clients = append(clients, c)
+		children = append(children, c)
+	}
+
+	clientsDone := make(chan struct{})
+	go func() {
+		for _, c := range clients {
+			<-c.done
+		}
+		close(clientsDone)
+	}()
+
+	select {
+	case <-clientsDone:
+		o.logf("action: clients_finished | result: success")
+	case sig := <-signals:
+		o.logf("action: signal | result: success | signal: %v", sig)
+	}
+	o.stop(children)
+	return o.report(children), nil
+}
+
+// start Launches a child and streams its output. If the process cannot be
+// started the returned child is already done
+func (o *Orchestrator) start(spec ProcessSpec) (*child, error) {
+	c := &child{spec: spec, done: make(chan struct{})}
+
+	cmd := exec.Command(spec.Path, spec.Args...)
+	cmd.Dir = spec.Dir
+	cmd.Env = append(os.Environ(), spec.Env...)
+	stdout, err := cmd.StdoutPipe()
+	if err == nil {
+		cmd.Stderr = cmd.Stdout
+	}
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		o.logf("action: start | result: fail | name: %v | error: %v", spec.Name, err)
+		c.status = ExitStatus{Name: spec.Name, Code: -1, Err: err}
+		close(c.done)
+		return c, err
+	}
+	c.cmd = cmd
+	o.logf("action: start | result: success | name: %v | pid: %v", spec.Name, cmd.Process.Pid)
+
+	go func() {
+		// Output must be fully read before waiting for the process
+		o.stream(spec.Name, stdout)
+		c.status = exitStatus(spec.Name, cmd.Wait())
+		close(c.done)
+	}()
+	return c, nil
+}
+
+// waitServer Polls the server address until it accepts connections. Fails if
+// the server exits, a signal arrives or the startup timeout expires
+func (o *Orchestrator) waitServer(address string, server *child, signals <-chan os.Signal) error {
+	deadline := time.After(o.startupTimeout)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		conn, err := net.DialTimeout("tcp", address, 100*time.Millisecond)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+
+		select {
+		case <-server.done:
+			return fmt.Errorf("server exited before accepting connections")
+		case sig := <-signals:
+			return fmt.Errorf("interrupted by %v", sig)
+		case <-deadline:
+			return fmt.Errorf("server did not accept connections after %v", o.startupTimeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+// stop Sends SIGTERM to every running child and kills the ones that are
+// still running once the stop timeout expires
+func (o *Orchestrator) stop(children []*child) {
+	for _, c := range children {
+		select {
+		case <-c.done:
+			continue
+		default:
+		}
+		if err := c.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+			o.logf("action: stop | result: fail | name: %v | error: %v", c.spec.Name, err)
+		}
+	}
+
+	timeout := time.After(o.stopTimeout)
+	for _, c := range children {
+		select {
+		case <-c.done:
+		case <-timeout:
+			// Once the timeout expired every remaining child is killed
+			timeout = closedChannel()
+			o.logf("action: kill | result: in_progress | name: %v", c.spec.Name)
+			c.cmd.Process.Kill()
+			<-c.done
+		}
+	}
+}
+
+// report Logs how every child finished and returns the statuses
+func (o *Orchestrator) report(children []*child) []ExitStatus {
+	statuses := make([]ExitStatus, 0, len(children))
+	for _, c := range children {
+		<-c.done
+		status := c.status
+		result := "success"
+		if status.Err != nil || (status.Signal == "" && status.Code != 0) {
+			result = "fail"
+		}
+		switch {
+		case status.Signal != "":
+			o.logf("action: exit | result: %v | name: %v | exit_code: %v | signal: %v", result, status.Name, status.Code, status.Signal)
+		case status.Err != nil:
+			o.logf("action: exit | result: %v | name: %v | exit_code: %v | error: %v", result, status.Name, status.Code, status.Err)
+		default:
+			o.logf("action: exit | result: %v | name: %v | exit_code: %v", result, status.Name, status.Code)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// stream Copies every line read from r prefixed with the name of the child
+func (o *Orchestrator) stream(name string, r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		o.writeLine(name, scanner.Text())
+	}
+}
+
+func (o *Orchestrator) logf(format string, args ...interface{}) {
+	o.writeLine(orchestratorName, fmt.Sprintf(format, args...))
+}
+
+func (o *Orchestrator) writeLine(name string, line string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	fmt.Fprintf(o.out, "%s | %s\n", name+strings.Repeat(" ", o.width-len(name)), line)
+}
+
+func exitStatus(name string, err error) ExitStatus {
+	if err == nil {
+		return ExitStatus{Name: name}
+	}
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return ExitStatus{Name: name, Code: -1, Err: err}
+	}
+	if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return ExitStatus{Name: name, Code: -1, Signal: ws.Signal().String()}
+	}
+	return ExitStatus{Name: name, Code: exitErr.ExitCode()}
+}
+
+func closedChannel() <-chan time.Time {
+	c := make(chan time.Time)
+	close(c)
+	return c
+}
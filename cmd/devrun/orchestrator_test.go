@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestHelperProcess Is not a real test. It is the child process started by
+// the other tests, behaving as selected by DEVRUN_HELPER
+func TestHelperProcess(t *testing.T) {
+	role := os.Getenv("DEVRUN_HELPER")
+	if role == "" {
+		return
+	}
+	terminated := make(chan os.Signal, 1)
+	signal.Notify(terminated, syscall.SIGTERM)
+
+	switch role {
+	case "server":
+		listener, err := net.Listen("tcp", "127.0.0.1:"+os.Getenv("SERVER_PORT"))
+		if err != nil {
+			fmt.Println("listen failed:", err)
+			os.Exit(1)
+		}
+		fmt.Println("listening")
+		<-terminated
+		listener.Close()
+		fmt.Println("server terminated")
+		os.Exit(0)
+	case "client":
+		fmt.Printf("hello from %v to %v\n", os.Getenv("CLI_ID"), os.Getenv("CLI_SERVER_ADDRESS"))
+		code, _ := strconv.Atoi(os.Getenv("HELPER_EXIT_CODE_" + os.Getenv("CLI_ID")))
+		os.Exit(code)
+	case "sleeper":
+		fmt.Println("sleeping")
+		<-terminated
+		fmt.Println("client terminated")
+		os.Exit(3)
+	case "crash":
+		fmt.Fprintln(os.Stderr, "cannot start")
+		os.Exit(1)
+	}
+}
+
+// syncBuffer Buffer safe to inspect while the orchestrator writes to it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func freePort(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+}
+
+func helper(name string, role string, env ...string) ProcessSpec {
+	return ProcessSpec{
+		Name: name,
+		Path: os.Args[0],
+		Args: []string{"-test.run=TestHelperProcess"},
+		Env:  append([]string{"DEVRUN_HELPER=" + role}, env...),
+	}
+}
+
+func testPlan(t *testing.T, serverRole string, clients ...ProcessSpec) Plan {
+	port := freePort(t)
+	address := "127.0.0.1:" + port
+	for i := range clients {
+		clients[i].Env = append(clients[i].Env,
+			fmt.Sprintf("CLI_ID=%v", i+1),
+			"CLI_SERVER_ADDRESS="+address,
+		)
+	}
+	return Plan{
+		Server:        helper("server", serverRole, "SERVER_PORT="+port),
+		ServerAddress: address,
+		Clients:       clients,
+	}
+}
+
+func TestRunPrefixesOutputAndReportsExitCodes(t *testing.T) {
+	plan := testPlan(t, "server",
+		helper("client1", "client", "HELPER_EXIT_CODE_1=0"),
+		helper("client2", "client", "HELPER_EXIT_CODE_2=2"),
+	)
+	out := &syncBuffer{}
+
+	statuses, err := NewOrchestrator(out, 5*time.Second, 5*time.Second).Run(plan, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v\n%s", err, out)
+	}
+
+	for _, line := range []string{
+		"server  | listening\n",
+		"client1 | hello from 1 to " + plan.ServerAddress + "\n",
+		"client2 | hello from 2 to " + plan.ServerAddress + "\n",
+		"server  | server terminated\n",
+		"devrun  | action: exit | result: success | name: server | exit_code: 0\n",
+		"devrun  | action: exit | result: success | name: client1 | exit_code: 0\n",
+		"devrun  | action: exit | result: fail | name: client2 | exit_code: 2\n",
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("expected %q in output:\n%s", line, out)
+		}
+	}
+
+	if len(statuses) != 3 || statuses[1].Code != 0 || statuses[2].Code != 2 {
+		t.Fatalf("unexpected statuses %+v", statuses)
+	}
+	if exitCode(statuses) != 1 {
+		t.Fatal("a failed client must fail devrun")
+	}
+}
+
+func TestRunForwardsSignalsToEveryChild(t *testing.T) {
+	plan := testPlan(t, "server",
+		helper("client1", "sleeper"),
+		helper("client2", "sleeper"),
+	)
+	out := &syncBuffer{}
+	signals := make(chan os.Signal, 1)
+
+	go func() {
+		// Wait for both clients to be running before interrupting them
+		for strings.Count(out.String(), "| sleeping") < 2 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		signals <- syscall.SIGTERM
+	}()
+
+	statuses, err := NewOrchestrator(out, 5*time.Second, 5*time.Second).Run(plan, signals)
+	if err != nil {
+		t.Fatalf("unexpected error: %v\n%s", err, out)
+	}
+
+	if strings.Count(out.String(), "| client terminated") != 2 {
+		t.Fatalf("expected both clients to receive SIGTERM:\n%s", out)
+	}
+	if !strings.Contains(out.String(), "server  | server terminated") {
+		t.Fatalf("expected server to receive SIGTERM:\n%s", out)
+	}
+	for _, status := range statuses[1:] {
+		if status.Code != 3 {
+			t.Fatalf("unexpected statuses %+v", statuses)
+		}
+	}
+}
+
+func TestRunFailsWhenServerExitsBeforeListening(t *testing.T) {
+	plan := testPlan(t, "crash", helper("client1", "client"))
+	out := &syncBuffer{}
+
+	statuses, err := NewOrchestrator(out, 5*time.Second, time.Second).Run(plan, nil)
+	if err == nil {
+		t.Fatalf("expected an error:\n%s", out)
+	}
+	if len(statuses) != 1 || statuses[0].Code != 1 {
+		t.Fatalf("expected only the server status, got %+v", statuses)
+	}
+	if !strings.Contains(out.String(), "server  | cannot start") {
+		t.Fatalf("expected stderr to be multiplexed:\n%s", out)
+	}
+	if strings.Contains(out.String(), "client1") {
+		t.Fatalf("clients must not start without a server:\n%s", out)
+	}
+}
@@ -0,0 +1,130 @@
+// Command echocheck validates the echo server by sending a series of probes
+// and checking that every one of them comes back unaltered. It replaces the
+// netcat based validar-echo-server.sh, so it can run inside the compose
+// network without installing anything else in the containers.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// maxMessageSize The python server reads a single 1024 bytes chunk per
+// connection, so the longest line it can echo is 1023 bytes plus the newline
+const maxMessageSize = 1023
+
+// probe Message sent to the server split in one or more writes. The server
+// is expected to answer exactly the bytes in expected
+type probe struct {
+	name     string
+	writes   [][]byte
+	expected []byte
+}
+
+// probeResult Outcome of a single probe
+type probeResult struct {
+	probe    probe
+	received []byte
+	err      error
+}
+
+func (r probeResult) ok() bool {
+	return r.err == nil && bytes.Equal(r.received, r.probe.expected)
+}
+
+// defaultProbes Builds the probes run by the checker
+func defaultProbes(maxSize int) []probe {
+	line := func(name string, msg string) probe {
+		data := []byte(msg + "\n")
+		return probe{name: name, writes: [][]byte{data}, expected: data}
+	}
+
+	return []probe{
+		line("simple", "action: test_echo_server"),
+		line("empty", ""),
+		line("max_size", strings.Repeat("x", maxSize)),
+		line("embedded_newline", "first line\nsecond line"),
+		line("unicode", "Lotería Nacional: ñandú, acción, 漢字, 🎲"),
+		{
+			name:     "split_writes",
+			writes:   [][]byte{[]byte("split "), []byte("in three "), []byte("writes\n")},
+			expected: []byte("split in three writes\n"),
+		},
+	}
+}
+
+// runProbe Opens a new connection, sends every write of the probe waiting
+// delay between them and reads until the expected amount of bytes arrives,
+// the server closes the connection or the timeout expires
+func runProbe(address string, p probe, delay time.Duration, timeout time.Duration) probeResult {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return probeResult{probe: p, err: err}
+	}
+	defer conn.Close()
+
+	for i, data := range p.writes {
+		if i > 0 {
+			time.Sleep(delay)
+		}
+		conn.SetWriteDeadline(time.Now().Add(timeout))
+		if _, err := conn.Write(data); err != nil {
+			return probeResult{probe: p, err: err}
+		}
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	received := make([]byte, len(p.expected))
+	n, err := io.ReadFull(conn, received)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		// A short answer is a failed validation rather than a network error
+		err = nil
+	}
+	return probeResult{probe: p, received: received[:n], err: err}
+}
+
+// check Runs every probe and prints its details. Returns true if all of
+// them succeeded
+func check(out io.Writer, address string, probes []probe, delay time.Duration, timeout time.Duration) bool {
+	success := true
+	for _, p := range probes {
+		result := runProbe(address, p, delay, timeout)
+		switch {
+		case result.err != nil:
+			success = false
+			fmt.Fprintf(out, "action: echo_probe | result: fail | probe: %v | error: %v\n", p.name, result.err)
+		case !result.ok():
+			success = false
+			fmt.Fprintf(out, "action: echo_probe | result: fail | probe: %v | sent: %v bytes | received: %v bytes | expected: %q | got: %q\n",
+				p.name, len(p.expected), len(result.received), p.expected, result.received)
+		default:
+			fmt.Fprintf(out, "action: echo_probe | result: success | probe: %v | sent: %v bytes | received: %v bytes\n",
+				p.name, len(p.expected), len(result.received))
+		}
+	}
+
+	if success {
+		fmt.Fprintln(out, "action: test_echo_server | result: success")
+	} else {
+		fmt.Fprintln(out, "action: test_echo_server | result: fail")
+	}
+	return success
+}
+
+func main() {
+	address := flag.String("address", "server:12345", "address of the echo server")
+	timeout := flag.Duration("timeout", 5*time.Second, "maximum time to connect, send or receive a probe")
+	delay := flag.Duration("split-delay", 200*time.Millisecond, "time between the writes of a split probe")
+	maxSize := flag.Int("max-size", maxMessageSize, "size of the longest message the server must echo, without the newline")
+	flag.Parse()
+
+	if !check(os.Stdout, *address, defaultProbes(*maxSize), *delay, *timeout) {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startServer Serves every connection with handle on a loopback port
+func startServer(t *testing.T, handle func(conn net.Conn)) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// echoUntilPause Echoes everything received until the client stops writing
+// for a while, emulating a server that handles short reads correctly
+func echoUntilPause(conn net.Conn) {
+	var received bytes.Buffer
+	buf := make([]byte, 4096)
+	for {
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, err := conn.Read(buf)
+		received.Write(buf[:n])
+		if err != nil {
+			break
+		}
+	}
+	conn.Write(received.Bytes())
+}
+
+// echoFirstRead Answers only the first chunk read, like the python server
+func echoFirstRead(conn net.Conn) {
+	buf := make([]byte, 1024)
+	n, _ := conn.Read(buf)
+	conn.Write(buf[:n])
+}
+
+func TestCheckSucceedsAgainstCorrectServer(t *testing.T) {
+	address := startServer(t, echoUntilPause)
+
+	var out bytes.Buffer
+	if !check(&out, address, defaultProbes(maxMessageSize), 10*time.Millisecond, time.Second) {
+		t.Fatalf("expected success:\n%s", out.String())
+	}
+	if !strings.HasSuffix(out.String(), "action: test_echo_server | result: success\n") {
+		t.Fatalf("unexpected output:\n%s", out.String())
+	}
+	if strings.Count(out.String(), "action: echo_probe | result: success") != len(defaultProbes(maxMessageSize)) {
+		t.Fatalf("expected a line per probe:\n%s", out.String())
+	}
+}
+
+func TestCheckDetectsShortReads(t *testing.T) {
+	address := startServer(t, echoFirstRead)
+
+	var out bytes.Buffer
+	if check(&out, address, defaultProbes(maxMessageSize), 50*time.Millisecond, time.Second) {
+		t.Fatalf("expected failure:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "result: fail | probe: split_writes") {
+		t.Fatalf("expected split_writes to fail:\n%s", out.String())
+	}
+	if !strings.HasSuffix(out.String(), "action: test_echo_server | result: fail\n") {
+		t.Fatalf("unexpected output:\n%s", out.String())
+	}
+}
+
+func TestCheckDetectsAlteredReplies(t *testing.T) {
+	address := startServer(t, func(conn net.Conn) {
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err == nil {
+			conn.Write([]byte(strings.ToUpper(line)))
+		}
+	})
+
+	var out bytes.Buffer
+	probes := []probe{defaultProbes(maxMessageSize)[0]}
+	if check(&out, address, probes, 0, time.Second) {
+		t.Fatalf("expected failure:\n%s", out.String())
+	}
+}
+
+func TestCheckFailsWhenServerIsDown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	var out bytes.Buffer
+	if check(&out, address, defaultProbes(maxMessageSize), 0, time.Second) {
+		t.Fatalf("expected failure:\n%s", out.String())
+	}
+}
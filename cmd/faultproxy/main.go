@@ -0,0 +1,82 @@
+// Command faultproxy forwards TCP connections to a server injecting network
+// faults on them, to reproduce short reads, short writes and disconnections
+// deterministically. Every connection gets one of the given faults with the
+// given probability; the draw only depends on the seed and the connection
+// number, so reusing a seed reproduces a run.
+//
+// Usage:
+//
+//	faultproxy -target server:12345 [-listen :12346] [-seed 1] [-probability 1] -faults fragment,reset@64/down,latency=50ms
+//
+// Faults are written as kind[=value][@after][/direction], with kinds latency,
+// bandwidth, fragment, reset, halfclose, blackhole and corrupt and directions
+// up, down or both (the default). Reset, halfclose, blackhole and corrupt
+// trigger after a random amount of bytes between 0 and @after.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/faultproxy"
+)
+
+func main() {
+	listen := flag.String("listen", ":12346", "address the proxy listens on")
+	target := flag.String("target", "", "address of the server connections are forwarded to")
+	seed := flag.Int64("seed", 1, "seed of the fault schedule")
+	probability := flag.Float64("probability", 1, "probability of injecting a fault on each connection")
+	faults := flag.String("faults", "", "comma separated faults to choose from")
+	flag.Parse()
+
+	if *target == "" {
+		fmt.Fprintln(os.Stderr, "action: faultproxy | result: fail | error: -target is required")
+		os.Exit(2)
+	}
+	candidates, err := faultproxy.ParseFaults(*faults)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "action: faultproxy | result: fail | error: %v\n", err)
+		os.Exit(2)
+	}
+
+	proxy, err := faultproxy.Start(faultproxy.Config{
+		Listen: *listen,
+		Target: *target,
+		Schedule: faultproxy.RandomSchedule{
+			Seed:        *seed,
+			Probability: *probability,
+			Candidates:  candidates,
+		},
+		OnConnection: func(conn int, faults []faultproxy.Fault) {
+			fmt.Printf("action: inject_fault | result: success | conn: %v | faults: %v\n", conn, formatFaults(faults))
+		},
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "action: faultproxy | result: fail | error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("action: faultproxy | result: in_progress | listen: %v | target: %v | seed: %v | probability: %v | faults: %v\n",
+		proxy.Addr(), *target, *seed, *probability, formatFaults(candidates))
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	<-signals
+
+	proxy.Close()
+	fmt.Printf("action: faultproxy | result: success | connections: %v\n", proxy.Connections())
+}
+
+func formatFaults(faults []faultproxy.Fault) string {
+	if len(faults) == 0 {
+		return "none"
+	}
+	specs := make([]string, 0, len(faults))
+	for _, fault := range faults {
+		specs = append(specs, fault.String())
+	}
+	return strings.Join(specs, ",")
+}
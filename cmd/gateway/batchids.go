@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// batchIDs Ids the batches of every agency are numbered with. Ids are
+// reserved in a state file before the batches that use them are sent, so a
+// gateway that restarts never numbers a batch with an id it already sent,
+// which the server would acknowledge and discard as a duplicate. Without a
+// state file the ids are only kept in memory
+type batchIDs struct {
+	mu   sync.Mutex
+	path string
+	// first Id of the first batch of an agency without reserved ids
+	first uint32
+	// reserved Last id reserved for every agency. The ids used by an agency
+	// never go beyond it
+	reserved map[string]uint32
+}
+
+// loadBatchIDs Reads the ids reserved in the state file at path, if it
+// exists. Agencies without reserved ids start at first
+func loadBatchIDs(path string, first uint32) (*batchIDs, error) {
+	ids := &batchIDs{path: path, first: first, reserved: make(map[string]uint32)}
+	if path == "" {
+		return ids, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ids, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &ids.reserved); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// start Returns the id of the next batch of the agency, after every id
+// reserved for it before, and marks the ids up to it as reserved in memory
+func (b *batchIDs) start(agency string) uint32 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	next := b.first
+	if reserved, ok := b.reserved[agency]; ok && reserved >= next {
+		next = reserved + 1
+	}
+	b.reserved[agency] = next - 1
+	return next
+}
+
+// reserve Reserves count more ids for the agency, enough for a request of
+// count bets, and persists them before they can be used
+func (b *batchIDs) reserve(agency string, count int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.reserved[agency] += uint32(count)
+	return b.save()
+}
+
+// save Replaces the state file with the reserved ids, through a temporary
+// file so a crash never leaves it half written
+func (b *batchIDs) save() error {
+	if b.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(b.reserved)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(b.path), filepath.Base(b.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), b.path)
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/lottery"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/metrics"
+)
+
+// DefaultMaxBodySize Largest request body accepted by default
+const DefaultMaxBodySize = 1 << 20
+
+// Options Configuration of the gateway
+type Options struct {
+	// ServerAddress Address of the lottery server, with the scheme of its
+	// transport
+	ServerAddress string
+	// Timeout Maximum time to wait for the server on every request
+	Timeout time.Duration
+	// RetryAttempts Times a request is sent to the server before giving up
+	RetryAttempts int
+	RetryBackoff  time.Duration
+	// BatchMaxAmount Maximum amount of bets per batch
+	BatchMaxAmount int
+	// Capabilities Optional protocol features offered in the handshake
+	Capabilities []string
+	// MaxBodySize Largest request body accepted. Zero means
+	// DefaultMaxBodySize
+	MaxBodySize int64
+	// StatePath File the batch ids are reserved in, so they are not reused
+	// after a restart. Empty keeps them in memory, numbered from the launch
+	// time
+	StatePath string
+}
+
+// Gateway HTTP handler that turns JSON requests into messages of the lottery
+// protocol, sent on behalf of the agency of the path:
+//
+//	POST /agencies/{id}/bets     JSON array of bets
+//	POST /agencies/{id}/finish   notifies the end of the delivery
+//	GET  /agencies/{id}/winners  winners of the agency
+type Gateway struct {
+	options  Options
+	metrics  *common.ClientMetrics
+	batchIDs *batchIDs
+
+	mu       sync.Mutex
+	agencies map[string]*agency
+}
+
+// agency Client of an agency. Requests of the same agency are sent one at a
+// time, so its batch ids keep growing
+type agency struct {
+	mu     sync.Mutex
+	client *common.Client
+}
+
+// betsResponse Body of a successful POST /agencies/{id}/bets. Rejected
+// holds the documents of the bets the server did not store
+type betsResponse struct {
+	Agency   string   `json:"agency"`
+	Received int      `json:"received"`
+	Rejected []string `json:"rejected"`
+}
+
+// finishResponse Body of a successful POST /agencies/{id}/finish
+type finishResponse struct {
+	Agency   string `json:"agency"`
+	Finished bool   `json:"finished"`
+}
+
+// winnersResponse Body of a successful GET /agencies/{id}/winners
+type winnersResponse struct {
+	Agency  string   `json:"agency"`
+	Winners []string `json:"winners"`
+}
+
+// errorResponse Body of every failed response
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// NewGateway Creates a gateway that reaches the server with the options.
+// Fails if the state file cannot be read
+func NewGateway(options Options) (*Gateway, error) {
+	if options.MaxBodySize <= 0 {
+		options.MaxBodySize = DefaultMaxBodySize
+	}
+	// Agencies never seen start at the launch time, above the ids of a
+	// gateway that ran without the state file
+	batchIDs, err := loadBatchIDs(options.StatePath, uint32(time.Now().Unix()))
+	if err != nil {
+		return nil, err
+	}
+	return &Gateway{
+		options:  options,
+		metrics:  common.NewClientMetrics(metrics.NewRegistry()),
+		batchIDs: batchIDs,
+		agencies: make(map[string]*agency),
+	}, nil
+}
+
+// ServeHTTP Routes the request to the handler of its path
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 3 || parts[0] != "agencies" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no route for %v", r.URL.Path))
+		return
+	}
+	id := parts[1]
+	if _, err := strconv.ParseUint(id, 10, 32); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid agency %q: must be a non negative integer", id))
+		return
+	}
+
+	var method string
+	var handle func(w http.ResponseWriter, r *http.Request, client *common.Client, id string)
+	switch parts[2] {
+	case "bets":
+		method, handle = http.MethodPost, g.bets
+	case "finish":
+		method, handle = http.MethodPost, g.finish
+	case "winners":
+		method, handle = http.MethodGet, g.winners
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("no route for %v", r.URL.Path))
+		return
+	}
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%v only accepts %v", r.URL.Path, method))
+		return
+	}
+
+	agency := g.agency(id)
+	agency.mu.Lock()
+	defer agency.mu.Unlock()
+	defer agency.client.Close()
+	handle(w, r, agency.client, id)
+}
+
+// agency Returns the client of the agency, creating it on its first request
+func (g *Gateway) agency(id string) *agency {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if a, ok := g.agencies[id]; ok {
+		return a
+	}
+	config := common.ClientConfig{
+		ID:            id,
+		ServerAddress: g.options.ServerAddress,
+		Mode:          common.ModeBets,
+		Bets:          common.BetsConfig{BatchMaxAmount: g.options.BatchMaxAmount},
+		Timeout:       g.options.Timeout,
+		RetryAttempts: g.options.RetryAttempts,
+		RetryBackoff:  g.options.RetryBackoff,
+		Capabilities:  g.options.Capabilities,
+	}
+	a := &agency{client: common.NewClient(config, g.metrics, common.WithFirstBatchID(g.batchIDs.start(id)))}
+	g.agencies[id] = a
+	return a
+}
+
+// bets Sends the bets of the body. Every bet must be valid and belong to the
+// agency of the path, otherwise none is sent
+func (g *Gateway) bets(w http.ResponseWriter, r *http.Request, client *common.Client, id string) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, g.options.MaxBodySize+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if int64(len(body)) > g.options.MaxBodySize {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("body larger than %v bytes", g.options.MaxBodySize))
+		return
+	}
+	var envelopes []lottery.JSONBet
+	if err := json.Unmarshal(body, &envelopes); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("expected a JSON array of bets: %v", err))
+		return
+	}
+	if len(envelopes) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("expected at least one bet"))
+		return
+	}
+
+	bets := make([]lottery.Bet, 0, len(envelopes))
+	for i, envelope := range envelopes {
+		if envelope.Data.ClientID == "" {
+			envelope.Data.ClientID = id
+		}
+		bet, err := envelope.Bet()
+		if err == nil && bet.Agency != id {
+			err = fmt.Errorf("client_id %q does not match agency %q", bet.Agency, id)
+		}
+		if err == nil {
+			err = bet.Validate()
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("bet %d: %v", i, err))
+			return
+		}
+		bets = append(bets, bet)
+	}
+
+	// Each batch holds at least a bet, so the bets are enough ids
+	if err := g.batchIDs.reserve(id, len(bets)); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("could not reserve the batch ids: %v", err))
+		return
+	}
+	rejected, err := client.SendBets(bets)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	status := http.StatusOK
+	if len(rejected) > 0 {
+		status = http.StatusUnprocessableEntity
+	} else {
+		rejected = []string{}
+	}
+	log.Infof("action: gateway_bets | result: success | agency: %v | cantidad: %v | rechazadas: %v", id, len(bets), len(rejected))
+	writeJSON(w, status, betsResponse{Agency: id, Received: len(bets), Rejected: rejected})
+}
+
+// finish Notifies the server that the agency has no more bets
+func (g *Gateway) finish(w http.ResponseWriter, r *http.Request, client *common.Client, id string) {
+	if err := client.EndDelivery(); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	log.Infof("action: gateway_finish | result: success | agency: %v", id)
+	writeJSON(w, http.StatusOK, finishResponse{Agency: id, Finished: true})
+}
+
+// winners Asks once for the winners of the agency
+func (g *Gateway) winners(w http.ResponseWriter, r *http.Request, client *common.Client, id string) {
+	winners, err := client.Winners()
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	if winners == nil {
+		winners = []string{}
+	}
+	log.Infof("action: gateway_winners | result: success | agency: %v | cant_ganadores: %v", id, len(winners))
+	writeJSON(w, http.StatusOK, winnersResponse{Agency: id, Winners: winners})
+}
+
+// statusOf Maps the error of a request to the server to an HTTP status:
+//
+//	409 Conflict         the lottery has not taken place yet
+//	504 Gateway Timeout  the server did not answer in time
+//	502 Bad Gateway      the server is unreachable, speaks another protocol
+//	                     version or answered something unexpected or corrupt
+func statusOf(err error) int {
+	if errors.Is(err, common.ErrWinnersPending) {
+		return http.StatusConflict
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	log.Warningf("action: gateway_request | result: fail | status: %v | error: %v", status, err)
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
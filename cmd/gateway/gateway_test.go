@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/op/go-logging"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/fakeserver"
+)
+
+const twoBets = `[
+	{"type": "bet", "data": {"client_id": "3", "first_name": "Santiago Lionel", "last_name": "Lorca", "document_number": "30904465", "birth_date": "1999-03-17", "number": "7574"}},
+	{"type": "bet", "data": {"first_name": "Valentina", "last_name": "Vera", "document_number": "30170921", "birth_date": "1982-05-22", "number": "6053"}}
+]`
+
+// startGateway Starts a fake lottery server and a gateway in front of it
+func startGateway(t *testing.T) (*fakeserver.Server, *httptest.Server) {
+	t.Helper()
+	logging.SetLevel(logging.ERROR, "log")
+	server, err := fakeserver.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server, newGateway(t, Options{
+		ServerAddress:  server.Addr(),
+		Timeout:        time.Second,
+		RetryAttempts:  1,
+		BatchMaxAmount: 10,
+		MaxBodySize:    4096,
+	})
+}
+
+// newGateway Starts a gateway with the options
+func newGateway(t *testing.T, options Options) *httptest.Server {
+	t.Helper()
+	handler, err := NewGateway(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gateway := httptest.NewServer(handler)
+	t.Cleanup(gateway.Close)
+	return gateway
+}
+
+// call Sends a request to the gateway and decodes its JSON body
+func call(t *testing.T, gateway *httptest.Server, method string, path string, body string) (int, map[string]interface{}, http.Header) {
+	t.Helper()
+	request, err := http.NewRequest(method, gateway.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := gateway.Client().Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	raw, _ := io.ReadAll(response.Body)
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		t.Fatalf("expected a JSON body, got %q: %v", raw, err)
+	}
+	return response.StatusCode, decoded, response.Header
+}
+
+func TestBetsAreForwardedToTheServer(t *testing.T) {
+	server, gateway := startGateway(t)
+
+	for request := 1; request <= 2; request++ {
+		status, body, _ := call(t, gateway, http.MethodPost, "/agencies/3/bets", twoBets)
+		if status != http.StatusOK || body["received"] != 2.0 || !reflect.DeepEqual(body["rejected"], []interface{}{}) {
+			t.Fatalf("unexpected response %v %v", status, body)
+		}
+		// Every request is a new batch, even for the same bets
+		if stored := len(server.Bets("3")); stored != 2*request {
+			t.Fatalf("expected %v bets stored, got %v", 2*request, stored)
+		}
+	}
+
+	server.RejectDocuments("30170921")
+	status, body, _ := call(t, gateway, http.MethodPost, "/agencies/3/bets", twoBets)
+	if status != http.StatusUnprocessableEntity || !reflect.DeepEqual(body["rejected"], []interface{}{"30170921"}) {
+		t.Fatalf("expected the rejected document, got %v %v", status, body)
+	}
+
+	status, body, _ = call(t, gateway, http.MethodPost, "/agencies/3/finish", "")
+	if status != http.StatusOK || body["finished"] != true || !server.Finished("3") {
+		t.Fatalf("expected the delivery to be finished, got %v %v", status, body)
+	}
+}
+
+// TestBatchIDsAreNotReusedAfterARestart Restarts the gateway, within the
+// same second, against the same server, which discards batches whose ids it
+// already stored
+func TestBatchIDsAreNotReusedAfterARestart(t *testing.T) {
+	server, _ := startGateway(t)
+	options := Options{
+		ServerAddress:  server.Addr(),
+		Timeout:        time.Second,
+		RetryAttempts:  1,
+		BatchMaxAmount: 1,
+		StatePath:      filepath.Join(t.TempDir(), "gateway-state.json"),
+	}
+
+	for restart := 1; restart <= 2; restart++ {
+		gateway := newGateway(t, options)
+		for request := 0; request < 3; request++ {
+			if status, body, _ := call(t, gateway, http.MethodPost, "/agencies/3/bets", twoBets); status != http.StatusOK {
+				t.Fatalf("unexpected response %v %v", status, body)
+			}
+		}
+		gateway.Close()
+		if stored := len(server.Bets("3")); stored != 6*restart {
+			t.Fatalf("expected %v bets stored, got %v", 6*restart, stored)
+		}
+	}
+
+	if err := os.WriteFile(options.StatePath, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewGateway(options); err == nil {
+		t.Fatal("expected a corrupt state file to fail")
+	}
+}
+
+func TestWinners(t *testing.T) {
+	server, gateway := startGateway(t)
+	server.SetPendingQueries(1)
+	server.SetWinners("3", "30904465", "30170921")
+
+	status, body, _ := call(t, gateway, http.MethodGet, "/agencies/3/winners", "")
+	if status != http.StatusConflict || body["error"] == nil {
+		t.Fatalf("expected the lottery to be pending, got %v %v", status, body)
+	}
+	status, body, _ = call(t, gateway, http.MethodGet, "/agencies/3/winners", "")
+	if status != http.StatusOK || !reflect.DeepEqual(body["winners"], []interface{}{"30904465", "30170921"}) {
+		t.Fatalf("expected the winners, got %v %v", status, body)
+	}
+	status, body, _ = call(t, gateway, http.MethodGet, "/agencies/4/winners", "")
+	if status != http.StatusOK || !reflect.DeepEqual(body["winners"], []interface{}{}) {
+		t.Fatalf("expected no winners, got %v %v", status, body)
+	}
+}
+
+func TestInvalidRequestsAreNotForwarded(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{name: "unknown route", method: http.MethodGet, path: "/bets", status: http.StatusNotFound},
+		{name: "unknown action", method: http.MethodGet, path: "/agencies/3/draw", status: http.StatusNotFound},
+		{name: "invalid agency", method: http.MethodGet, path: "/agencies/three/winners", status: http.StatusBadRequest},
+		{name: "wrong method", method: http.MethodGet, path: "/agencies/3/bets", status: http.StatusMethodNotAllowed},
+		{name: "not json", method: http.MethodPost, path: "/agencies/3/bets", body: "Santiago,Lorca", status: http.StatusBadRequest},
+		{name: "not an array", method: http.MethodPost, path: "/agencies/3/bets", body: `{"type": "bet"}`, status: http.StatusBadRequest},
+		{name: "no bets", method: http.MethodPost, path: "/agencies/3/bets", body: "[]", status: http.StatusBadRequest},
+		{name: "another type", method: http.MethodPost, path: "/agencies/3/bets", body: `[{"type": "winner", "data": {}}]`, status: http.StatusBadRequest},
+		{name: "another agency", method: http.MethodPost, path: "/agencies/4/bets", body: twoBets, status: http.StatusBadRequest},
+		{name: "invalid bet", method: http.MethodPost, path: "/agencies/3/bets", body: strings.Replace(twoBets, "1999-03-17", "1999-13-17", 1), status: http.StatusBadRequest},
+		{name: "body too large", method: http.MethodPost, path: "/agencies/3/bets", body: "[" + strings.Repeat(" ", 4096) + "]", status: http.StatusRequestEntityTooLarge},
+	}
+	server, gateway := startGateway(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status, body, header := call(t, gateway, test.method, test.path, test.body)
+			if status != test.status || body["error"] == nil {
+				t.Fatalf("expected %v and an error, got %v %v", test.status, status, body)
+			}
+			if status == http.StatusMethodNotAllowed && header.Get("Allow") != http.MethodPost {
+				t.Fatalf("expected the allowed method, got %q", header.Get("Allow"))
+			}
+		})
+	}
+	if connections := server.Connections(); connections != 0 {
+		t.Fatalf("expected no request to reach the server, got %v connections", connections)
+	}
+}
+
+func TestProtocolErrorsMapToGatewayStatuses(t *testing.T) {
+	t.Run("version mismatch", func(t *testing.T) {
+		server, gateway := startGateway(t)
+		server.SetVersion(2)
+		if status, body, _ := call(t, gateway, http.MethodPost, "/agencies/3/finish", ""); status != http.StatusBadGateway {
+			t.Fatalf("expected a bad gateway, got %v %v", status, body)
+		}
+	})
+	t.Run("connection closed", func(t *testing.T) {
+		server, gateway := startGateway(t)
+		server.Script(fakeserver.Action{Kind: fakeserver.Hangup})
+		if status, body, _ := call(t, gateway, http.MethodPost, "/agencies/3/bets", twoBets); status != http.StatusBadGateway {
+			t.Fatalf("expected a bad gateway, got %v %v", status, body)
+		}
+	})
+	t.Run("server unreachable", func(t *testing.T) {
+		server, gateway := startGateway(t)
+		server.Close()
+		if status, body, _ := call(t, gateway, http.MethodGet, "/agencies/3/winners", ""); status != http.StatusBadGateway {
+			t.Fatalf("expected a bad gateway, got %v %v", status, body)
+		}
+	})
+	t.Run("timeout", func(t *testing.T) {
+		server, err := fakeserver.Start()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer server.Close()
+		server.Script(fakeserver.Action{Kind: fakeserver.Reply}.After(200 * time.Millisecond))
+		gateway := newGateway(t, Options{ServerAddress: server.Addr(), Timeout: 50 * time.Millisecond, RetryAttempts: 1})
+		if status, body, _ := call(t, gateway, http.MethodGet, "/agencies/3/winners", ""); status != http.StatusGatewayTimeout {
+			t.Fatalf("expected a gateway timeout, got %v %v", status, body)
+		}
+	})
+}
@@ -0,0 +1,74 @@
+// Command gateway exposes the lottery protocol over HTTP and JSON, for tools
+// that cannot speak the framed protocol. Every request is sent to the lottery
+// server on behalf of the agency of its path with the client package:
+//
+//	POST /agencies/{id}/bets     JSON array of {"type": "bet", "data": {...}}
+//	POST /agencies/{id}/finish   notifies the end of the delivery
+//	GET  /agencies/{id}/winners  winners of the agency
+//
+// Usage:
+//
+//	gateway -server server:12345 [-listen :8080] [-timeout 10s] [-retries 3] [-state gateway-state.json]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/op/go-logging"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/transport"
+)
+
+var log = logging.MustGetLogger("log")
+
+func main() {
+	listen := flag.String("listen", ":8080", "address the HTTP server listens on")
+	server := flag.String("server", "", "address of the lottery server: host:port, unix:///path or tcp://host:port")
+	timeout := flag.Duration("timeout", 10*time.Second, "maximum time to wait for the lottery server on every request")
+	retries := flag.Int("retries", 3, "times a request is sent to the lottery server before giving up")
+	backoff := flag.Duration("backoff", time.Second, "time to wait between retries")
+	batchMaxAmount := flag.Int("batch-max-amount", 100, "maximum amount of bets per batch")
+	capabilities := flag.String("capabilities", strings.Join(common.SupportedCapabilities, ","), "comma separated protocol capabilities offered in the handshake")
+	maxBodySize := flag.Int64("max-body", DefaultMaxBodySize, "largest request body accepted, in bytes")
+	statePath := flag.String("state", "gateway-state.json", "file the batch ids are reserved in, so a restart does not reuse them")
+	flag.Parse()
+
+	logging.SetBackend(logging.NewBackendFormatter(
+		logging.NewLogBackend(os.Stdout, "", 0),
+		logging.MustStringFormatter(`%{time:2006-01-02 15:04:05} %{level:.5s}     %{message}`),
+	))
+	if *server == "" {
+		fmt.Fprintln(os.Stderr, "action: gateway | result: fail | error: -server is required")
+		os.Exit(2)
+	}
+	if _, _, err := transport.Parse(*server); err != nil {
+		fmt.Fprintf(os.Stderr, "action: gateway | result: fail | error: %v\n", err)
+		os.Exit(2)
+	}
+
+	gateway, err := NewGateway(Options{
+		ServerAddress:  *server,
+		Timeout:        *timeout,
+		RetryAttempts:  *retries,
+		RetryBackoff:   *backoff,
+		BatchMaxAmount: *batchMaxAmount,
+		Capabilities:   strings.Split(*capabilities, ","),
+		MaxBodySize:    *maxBodySize,
+		StatePath:      *statePath,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "action: gateway | result: fail | error: %v\n", err)
+		os.Exit(2)
+	}
+	log.Infof("action: gateway | result: in_progress | listen: %v | server: %v", *listen, *server)
+	if err := http.ListenAndServe(*listen, gateway); err != nil {
+		log.Criticalf("action: gateway | result: fail | error: %v", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/capture"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+)
+
+// Origin Where a frame comes from. Fields left empty are not printed
+type Origin struct {
+	Time      time.Time
+	Conn      int
+	Direction capture.Direction
+}
+
+func (o Origin) String() string {
+	var parts []string
+	if !o.Time.IsZero() {
+		parts = append(parts, o.Time.Format("15:04:05.000000"))
+	}
+	if o.Conn > 0 {
+		parts = append(parts, fmt.Sprintf("conn %v", o.Conn))
+	}
+	switch o.Direction {
+	case capture.Sent:
+		parts = append(parts, "client -> server")
+	case capture.Received:
+		parts = append(parts, "server -> client")
+	}
+	return strings.Join(parts, " ")
+}
+
+// Dumper Prints decoded frames. It is safe for concurrent use, every frame
+// is printed as a single block
+type Dumper struct {
+	mu         sync.Mutex
+	w          io.Writer
+	frames     int
+	violations int
+}
+
+// NewDumper Prints to w
+func NewDumper(w io.Writer) *Dumper {
+	return &Dumper{w: w}
+}
+
+// Frames Amount of frames printed so far
+func (d *Dumper) Frames() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.frames
+}
+
+// Violations Amount of framing or encoding violations found so far
+func (d *Dumper) Violations() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.violations
+}
+
+// DumpStream Decodes the frames of a raw byte stream, like the one carried
+// by a connection, until it ends. A stream cut in the middle of a frame is
+// reported as a violation. The frames after the handshake are read with the
+// framing it negotiated. The streams of both directions of a connection must
+// share its handshake, while a nil one follows a stream on its own
+func (d *Dumper) DumpStream(r io.Reader, origin Origin, now func() time.Time, handshake *Handshake) error {
+	alone := handshake == nil
+	if alone {
+		handshake = NewHandshake()
+	}
+	defer handshake.End(origin.Direction)
+	framing := protocol.StandardFraming
+	for {
+		frame, err := framing.ReadFrame(r)
+		if now != nil {
+			origin.Time = now()
+		}
+		switch {
+		case err == io.EOF:
+			return nil
+		case errors.Is(err, protocol.ErrEmptyFrame):
+			// The header was consumed, the stream can still be followed
+			d.violation(origin, "frame with length 0 has no message type")
+		case errors.Is(err, protocol.ErrInvalidContinuation):
+			// The offending frame was consumed, the stream can still be followed
+			d.violation(origin, err.Error())
+		case errors.Is(err, protocol.ErrCorruptFrame):
+			// The whole frame was consumed, the stream can still be followed
+			d.violation(origin, err.Error())
+		case errors.Is(err, protocol.ErrFrameTooLarge):
+			d.violation(origin, err.Error())
+			return nil
+		case err == io.ErrUnexpectedEOF:
+			d.violation(origin, "stream ended in the middle of a frame")
+			return nil
+		case err != nil:
+			return err
+		default:
+			d.Frame(origin, frame)
+			msg, err := protocol.Decode(frame)
+			if err != nil {
+				continue
+			}
+			switch m := msg.(type) {
+			case protocol.Hello:
+				handshake.Hello(m)
+			case protocol.HelloReply:
+				handshake.Reply(m)
+			default:
+				continue
+			}
+			// The stream moved past its handshake message, like an echoed
+			// hello, so only the other direction can still be waited for
+			handshake.End(origin.Direction)
+			if alone {
+				handshake.End(capture.Sent)
+				handshake.End(capture.Received)
+			}
+			framing = handshake.Framing()
+		}
+	}
+}
+
+// DumpCapture Decodes every record of a capture file
+func (d *Dumper) DumpCapture(r io.Reader) error {
+	reader := capture.NewReader(r)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		origin := Origin{Time: record.Time, Conn: record.Conn, Direction: record.Direction}
+		frame, err := record.Decode()
+		if err != nil {
+			d.violation(origin, fmt.Sprintf("invalid frame % x: %v", record.Frame, err))
+			continue
+		}
+		d.Frame(origin, frame)
+	}
+}
+
+// Frame Prints a frame with its decoded fields, flagging payloads that the
+// client codec cannot decode or would encode differently. Compressed payloads
+// are decompressed before decoding them
+func (d *Dumper) Frame(origin Origin, frame protocol.Frame) {
+	var b strings.Builder
+	msgType := frame.Type &^ protocol.FlagCompressed
+	fmt.Fprintf(&b, "%v (%d) | length: %v", msgType, byte(msgType), 1+len(frame.Payload))
+	if frames := (frame.Size() - len(frame.Payload)) / (protocol.HeaderSize + 1); frames > 1 {
+		fmt.Fprintf(&b, " | frames: %v", frames)
+	}
+	plain, err := protocol.Decompress(frame, 0)
+	if frame.Compressed() {
+		b.WriteString(" | compressed")
+		if err == nil {
+			fmt.Fprintf(&b, " from length: %v", 1+len(plain.Payload))
+		}
+	}
+	if s := origin.String(); s != "" {
+		b.WriteString(" | " + s)
+	}
+	b.WriteString("\n")
+
+	violation := ""
+	var msg protocol.Message
+	if err == nil {
+		msg, err = protocol.Decode(plain)
+	}
+	if err != nil {
+		violation = err.Error()
+		fmt.Fprintf(&b, "  payload: %q\n", frame.Payload)
+	} else {
+		writeFields(&b, msg)
+		if encoded, err := protocol.Encode(msg); err != nil || !bytes.Equal(encoded.Payload, plain.Payload) {
+			violation = fmt.Sprintf("payload %q is not how the client encodes it", plain.Payload)
+		}
+	}
+	if violation != "" {
+		fmt.Fprintf(&b, "  !! violation: %v\n", violation)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.frames++
+	if violation != "" {
+		d.violations++
+	}
+	io.WriteString(d.w, b.String())
+}
+
+func (d *Dumper) violation(origin Origin, reason string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.violations++
+	line := "!! violation: " + reason
+	if s := origin.String(); s != "" {
+		line += " | " + s
+	}
+	fmt.Fprintln(d.w, line)
+}
+
+// writeFields Prints the fields of a decoded message, one per line
+func writeFields(b *strings.Builder, msg protocol.Message) {
+	switch m := msg.(type) {
+	case protocol.Bets:
+		fmt.Fprintf(b, "  batch_id: %v | bets: %v\n", m.BatchID, len(m.Bets))
+		for i, bet := range m.Bets {
+			fmt.Fprintf(b, "  %4d | agency: %v | first_name: %v | last_name: %v | document: %v | birthdate: %v | number: %v\n",
+				i, bet.Agency, bet.FirstName, bet.LastName, bet.Document, bet.Birthdate, bet.Number)
+		}
+	case protocol.Ack:
+		fmt.Fprintf(b, "  batch_id: %v | count: %v\n", m.BatchID, m.Count)
+	case protocol.Reject:
+		fmt.Fprintf(b, "  batch_id: %v | indexes: %v\n", m.BatchID, m.Indexes)
+	case protocol.DeliveryEnded:
+		fmt.Fprintf(b, "  agency: %v\n", m.Agency)
+	case protocol.WinnersQuery:
+		fmt.Fprintf(b, "  agency: %v\n", m.Agency)
+	case protocol.Winners:
+		fmt.Fprintf(b, "  winners: %v\n", len(m.Documents))
+		for _, document := range m.Documents {
+			fmt.Fprintf(b, "    %v\n", document)
+		}
+	case protocol.WinnersPending:
+	case protocol.Hello:
+		fmt.Fprintf(b, "  version: %v | agency: %v | capabilities: %v\n", m.Version, m.Agency, capabilityList(m.Capabilities))
+	case protocol.HelloReply:
+		fmt.Fprintf(b, "  version: %v | capabilities: %v\n", m.Version, capabilityList(m.Capabilities))
+	default:
+		fmt.Fprintf(b, "  %+v\n", m)
+	}
+}
+
+// capabilityList Capabilities separated by commas, or none
+func capabilityList(capabilities []string) string {
+	if len(capabilities) == 0 {
+		return "none"
+	}
+	return strings.Join(capabilities, ",")
+}
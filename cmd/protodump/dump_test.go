@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/capture"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/fakeserver"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/metrics"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+)
+
+// betsConfig Configures a client that sends a small dataset in bets mode to
+// address
+func betsConfig(t *testing.T, address string) common.ClientConfig {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "agency-1.csv")
+	dataset := "Santiago Lionel,Lorca,30904465,1999-03-17,7574\nCamila,Pineda,29665629,2000-01-06,9999\n"
+	if err := os.WriteFile(path, []byte(dataset), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return common.ClientConfig{
+		ID:            "1",
+		ServerAddress: address,
+		Mode:          common.ModeBets,
+		Bets:          common.BetsConfig{DatasetPath: path, BatchMaxAmount: 10, WinnersPeriod: time.Millisecond},
+		Timeout:       time.Second,
+		RetryAttempts: 1,
+	}
+}
+
+// runClient Sends a small dataset in bets mode to address
+func runClient(t *testing.T, config common.ClientConfig, options ...common.Option) {
+	t.Helper()
+	if err := common.NewClient(config, common.NewClientMetrics(metrics.NewRegistry()), options...).StartClientLoop(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func startServer(t *testing.T) *fakeserver.Server {
+	t.Helper()
+	server, err := fakeserver.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	server.SetWinners("1", "30904465")
+	return server
+}
+
+func assertContains(t *testing.T, output string, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if !strings.Contains(output, line) {
+			t.Errorf("expected %q in the dump:\n%s", line, output)
+		}
+	}
+}
+
+func TestDumpCapture(t *testing.T) {
+	var recorded bytes.Buffer
+	runClient(t, betsConfig(t, startServer(t).Addr()), common.WithRecorder(capture.NewWriter(&recorded)))
+
+	var out bytes.Buffer
+	dumper := NewDumper(&out)
+	if err := dumper.DumpCapture(&recorded); err != nil {
+		t.Fatal(err)
+	}
+	if dumper.Frames() != 10 || dumper.Violations() != 0 {
+		t.Fatalf("expected 10 frames and no violations, got %v and %v:\n%s", dumper.Frames(), dumper.Violations(), out.String())
+	}
+	assertContains(t, out.String(),
+		"hello (8) | length: 5 | ",
+		"  version: 1 | agency: 1 | capabilities: none\n",
+		"bets (1) | length: 92 | ",
+		" conn 1 client -> server\n",
+		"  batch_id: 1 | bets: 2\n",
+		"     0 | agency: 1 | first_name: Santiago Lionel | last_name: Lorca | document: 30904465 | birthdate: 1999-03-17 | number: 7574\n",
+		"ack (2) | length: 4 | ",
+		"  batch_id: 1 | count: 2\n",
+		"delivery_ended (4) | length: 2 | ",
+		"winners (6) | length: 9 | ",
+		" conn 2 server -> client\n",
+		"  winners: 1\n    30904465\n",
+	)
+}
+
+func TestDumpStreamFlagsViolations(t *testing.T) {
+	var stream bytes.Buffer
+	protocol.WriteFrame(&stream, protocol.Frame{Type: protocol.TypeWinnersQuery, Payload: []byte("3")})
+	stream.Write([]byte{0, 0})
+	protocol.WriteFrame(&stream, protocol.Frame{Type: 42, Payload: []byte("?")})
+	protocol.WriteFrame(&stream, protocol.Frame{Type: protocol.TypeAck, Payload: []byte("01|2")})
+	protocol.WriteFrame(&stream, protocol.Frame{Type: protocol.TypeAck, Payload: []byte("x")})
+	protocol.WriteFrame(&stream, protocol.Frame{Type: protocol.TypeWinnersPending})
+	stream.Write([]byte{0, 9, byte(protocol.TypeBets), '1'})
+
+	var out bytes.Buffer
+	dumper := NewDumper(&out)
+	if err := dumper.DumpStream(&stream, Origin{}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if dumper.Frames() != 5 || dumper.Violations() != 5 {
+		t.Fatalf("expected 5 frames and 5 violations, got %v and %v:\n%s", dumper.Frames(), dumper.Violations(), out.String())
+	}
+	assertContains(t, out.String(),
+		"winners_query (5) | length: 2\n  agency: 3\n",
+		"!! violation: frame with length 0 has no message type\n",
+		"unknown(42) (42) | length: 2\n  payload: \"?\"\n  !! violation: ",
+		"ack (2) | length: 5\n  batch_id: 1 | count: 2\n  !! violation: payload \"01|2\" is not how the client encodes it\n",
+		"winners_pending (7) | length: 1\n",
+		"!! violation: stream ended in the middle of a frame\n",
+	)
+}
+
+func TestDumpStreamJoinsContinuationFrames(t *testing.T) {
+	documents := strings.TrimSuffix(strings.Repeat("30904465|", 8000), "|")
+	var stream bytes.Buffer
+	large := protocol.Framing{Continuation: true}
+	large.WriteFrame(&stream, protocol.Frame{Type: protocol.TypeWinners, Payload: []byte(documents)})
+	stream.Write([]byte{0, 2, byte(protocol.TypeWinners) | protocol.FlagContinued, '1', 0, 2, byte(protocol.TypeAck), '2'})
+	protocol.WriteFrame(&stream, protocol.Frame{Type: protocol.TypeWinnersPending})
+
+	var out bytes.Buffer
+	dumper := NewDumper(&out)
+	if err := dumper.DumpStream(&stream, Origin{}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if dumper.Frames() != 2 || dumper.Violations() != 1 {
+		t.Fatalf("expected 2 frames and 1 violation, got %v and %v:\n%s", dumper.Frames(), dumper.Violations(), out.String())
+	}
+	assertContains(t, out.String(),
+		"winners (6) | length: 72000 | frames: 2\n  winners: 8000\n",
+		"!! violation: protocol: invalid continuation frame: winners frame continued by a ack frame\n",
+		"winners_pending (7) | length: 1\n",
+	)
+}
+
+func TestDumpStreamDecompressesFrames(t *testing.T) {
+	documents := strings.TrimSuffix(strings.Repeat("30904465|", 100), "|")
+	winners := protocol.Frame{Type: protocol.TypeWinners, Payload: []byte(documents)}
+	var stream bytes.Buffer
+	protocol.WriteFrame(&stream, protocol.Compress(winners, 0))
+	protocol.WriteFrame(&stream, protocol.Frame{Type: protocol.TypeAck | protocol.FlagCompressed, Payload: []byte("1|2")})
+
+	var out bytes.Buffer
+	dumper := NewDumper(&out)
+	if err := dumper.DumpStream(&stream, Origin{}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if dumper.Frames() != 2 || dumper.Violations() != 1 {
+		t.Fatalf("expected 2 frames and 1 violation, got %v and %v:\n%s", dumper.Frames(), dumper.Violations(), out.String())
+	}
+	assertContains(t, out.String(),
+		" | compressed from length: 900\n  winners: 100\n    30904465\n",
+		"ack (2) | length: 4 | compressed\n  payload: \"1|2\"\n  !! violation: protocol: invalid compressed payload",
+	)
+}
+
+func TestRelayDumpsBothDirections(t *testing.T) {
+	server := startServer(t)
+	var out bytes.Buffer
+	dumper := NewDumper(&out)
+	relay, err := StartRelay("127.0.0.1:0", server.Addr(), dumper)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runClient(t, betsConfig(t, relay.Addr()))
+	relay.Close()
+
+	if stored := len(server.Bets("1")); stored != 2 {
+		t.Fatalf("expected the relay to be transparent, the server stored %v bets", stored)
+	}
+	if dumper.Frames() != 10 || dumper.Violations() != 0 {
+		t.Fatalf("expected 10 frames and no violations, got %v and %v:\n%s", dumper.Frames(), dumper.Violations(), out.String())
+	}
+	assertContains(t, out.String(),
+		" conn 1 client -> server\n  batch_id: 1 | bets: 2\n",
+		" conn 1 server -> client\n  batch_id: 1 | count: 2\n",
+		" conn 2 server -> client\n  winners: 1\n",
+	)
+}
+
+// TestRelayFollowsTheNegotiatedFraming Relays a session with checksums, whose
+// frames after the handshake carry their CRC32C trailer
+func TestRelayFollowsTheNegotiatedFraming(t *testing.T) {
+	server := startServer(t)
+	var out bytes.Buffer
+	dumper := NewDumper(&out)
+	relay, err := StartRelay("127.0.0.1:0", server.Addr(), dumper)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := betsConfig(t, relay.Addr())
+	config.Capabilities = common.SupportedCapabilities
+	runClient(t, config)
+	relay.Close()
+
+	if dumper.Frames() != 10 || dumper.Violations() != 0 {
+		t.Fatalf("expected 10 frames and no violations, got %v and %v:\n%s", dumper.Frames(), dumper.Violations(), out.String())
+	}
+	assertContains(t, out.String(),
+		"  version: 1 | capabilities: push,large_frames,compression,checksums\n",
+		" conn 1 client -> server\n  batch_id: 1 | bets: 2\n",
+		" conn 1 server -> client\n  batch_id: 1 | count: 2\n",
+		" conn 2 server -> client\n  winners: 1\n",
+	)
+}
+
+// startTCPServer Serves every connection with handle until the test ends
+func startTCPServer(t *testing.T, handle func(net.Conn)) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// queries A hello followed by winners queries
+func queries() []protocol.Message {
+	messages := []protocol.Message{protocol.Hello{Version: protocol.Version, Agency: "1"}}
+	for i := 0; i < 100; i++ {
+		messages = append(messages, protocol.WinnersQuery{Agency: "1"})
+	}
+	return messages
+}
+
+// TestRelayDoesNotWaitForTheHandshake Relays a client to an echo server,
+// which sends the hello back instead of a reply
+func TestRelayDoesNotWaitForTheHandshake(t *testing.T) {
+	address := startTCPServer(t, func(conn net.Conn) { io.Copy(conn, conn) })
+	var out bytes.Buffer
+	dumper := NewDumper(&out)
+	relay, err := StartRelay("127.0.0.1:0", address, dumper)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", relay.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	messages := queries()
+	for i, msg := range messages {
+		if err := protocol.WriteMessage(conn, msg); err != nil {
+			t.Fatal(err)
+		}
+		echoed, err := protocol.ReadMessage(conn)
+		if err != nil {
+			t.Fatalf("message %v: %v", i, err)
+		}
+		if !reflect.DeepEqual(echoed, msg) {
+			t.Fatalf("message %v: expected %+v echoed, got %+v", i, msg, echoed)
+		}
+	}
+	conn.Close()
+	relay.Close()
+
+	if dumper.Frames() != 2*len(messages) || dumper.Violations() != 0 {
+		t.Fatalf("expected %v frames and no violations, got %v and %v:\n%s", 2*len(messages), dumper.Frames(), dumper.Violations(), out.String())
+	}
+}
+
+// TestRelayDoesNotWaitForAReplyToTheHello Relays a client to a server that
+// never replies. The dumper of the client waits for the reply until the
+// connection ends, while its frames keep reaching the server
+func TestRelayDoesNotWaitForAReplyToTheHello(t *testing.T) {
+	messages := queries()
+	received := make(chan int, 1)
+	address := startTCPServer(t, func(conn net.Conn) {
+		count := 0
+		for ; count < len(messages); count++ {
+			if _, err := protocol.ReadMessage(conn); err != nil {
+				break
+			}
+		}
+		received <- count
+	})
+	var out bytes.Buffer
+	dumper := NewDumper(&out)
+	relay, err := StartRelay("127.0.0.1:0", address, dumper)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", relay.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for _, msg := range messages {
+		if err := protocol.WriteMessage(conn, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case count := <-received:
+		if count != len(messages) {
+			t.Fatalf("expected %v frames relayed, got %v", len(messages), count)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the frames to be relayed without a reply to the hello")
+	}
+	conn.Close()
+	relay.Close()
+
+	if dumper.Frames() != len(messages) || dumper.Violations() != 0 {
+		t.Fatalf("expected %v frames and no violations, got %v and %v:\n%s", len(messages), dumper.Frames(), dumper.Violations(), out.String())
+	}
+}
+
+func TestDumpStreamFollowsTheHandshakeOfASingleDirection(t *testing.T) {
+	checksums := protocol.Framing{Checksums: true}
+	var client bytes.Buffer
+	protocol.WriteMessage(&client, protocol.Hello{Version: protocol.Version, Agency: "1", Capabilities: []string{protocol.CapabilityChecksums}})
+	encoded, _ := protocol.Encode(protocol.WinnersQuery{Agency: "1"})
+	checksums.WriteFrame(&client, encoded)
+	var server bytes.Buffer
+	protocol.WriteMessage(&server, protocol.HelloReply{Version: protocol.Version, Capabilities: []string{protocol.CapabilityChecksums}})
+	encoded, _ = protocol.Encode(protocol.Ack{BatchID: 1, Count: 2})
+	checksums.WriteFrame(&server, encoded)
+	server.Write([]byte{0, 6, byte(protocol.TypeWinnersPending), 0, 0, 0, 0, 0})
+
+	var out bytes.Buffer
+	dumper := NewDumper(&out)
+	for _, stream := range []*bytes.Buffer{&client, &server} {
+		if err := dumper.DumpStream(stream, Origin{}, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if dumper.Frames() != 4 || dumper.Violations() != 1 {
+		t.Fatalf("expected 4 frames and 1 violation, got %v and %v:\n%s", dumper.Frames(), dumper.Violations(), out.String())
+	}
+	assertContains(t, out.String(),
+		"winners_query (5) | length: 2\n  agency: 1\n",
+		"ack (2) | length: 4\n  batch_id: 1 | count: 2\n",
+		"!! violation: protocol: corrupt frame: ",
+	)
+}
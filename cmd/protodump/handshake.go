@@ -0,0 +1,85 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/capture"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+)
+
+// Handshake Follows the handshake of a connection, so the frames after it
+// are read with the framing both ends switch to, the way the client does.
+// The hello and its reply travel in different directions, so the streams of
+// both directions of a connection share it
+type Handshake struct {
+	mu    sync.Mutex
+	hello *protocol.Hello
+	reply *protocol.HelloReply
+
+	helloDone chan struct{}
+	replyDone chan struct{}
+	helloOnce sync.Once
+	replyOnce sync.Once
+}
+
+// NewHandshake Initializes the handshake of a connection
+func NewHandshake() *Handshake {
+	return &Handshake{helloDone: make(chan struct{}), replyDone: make(chan struct{})}
+}
+
+// Hello Records the hello sent by the client
+func (h *Handshake) Hello(hello protocol.Hello) {
+	h.mu.Lock()
+	if h.hello == nil {
+		h.hello = &hello
+	}
+	h.mu.Unlock()
+	h.End(capture.Sent)
+}
+
+// Reply Records the reply of the server to the hello
+func (h *Handshake) Reply(reply protocol.HelloReply) {
+	h.mu.Lock()
+	if h.reply == nil {
+		h.reply = &reply
+	}
+	h.mu.Unlock()
+	h.End(capture.Received)
+}
+
+// End Stops waiting for the handshake message of the given direction, which
+// will not arrive once its stream ended or moved past it
+func (h *Handshake) End(direction capture.Direction) {
+	switch direction {
+	case capture.Sent:
+		h.helloOnce.Do(func() { close(h.helloDone) })
+	case capture.Received:
+		h.replyOnce.Do(func() { close(h.replyDone) })
+	}
+}
+
+// Framing Waits for both directions to reach the end of the handshake and
+// returns the framing of the session. Without a reply every capability of
+// the hello is assumed to be enabled, and without a hello the reply holds the
+// enabled capabilities. A server of another version enables nothing
+func (h *Handshake) Framing() protocol.Framing {
+	<-h.helloDone
+	<-h.replyDone
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var session []string
+	switch {
+	case h.reply == nil && h.hello != nil:
+		session = h.hello.Capabilities
+	case h.reply == nil || h.reply.Version != protocol.Version:
+	case h.hello == nil:
+		session = h.reply.Capabilities
+	default:
+		session = protocol.Negotiate(h.hello.Capabilities, h.reply.Capabilities)
+	}
+	return protocol.Framing{
+		Continuation: protocol.Has(session, protocol.CapabilityLargeFrames),
+		Checksums:    protocol.Has(session, protocol.CapabilityChecksums),
+	}
+}
@@ -0,0 +1,84 @@
+// Command protodump pretty-prints the frames of the lottery protocol: the
+// type, length and decoded fields of every message, flagging the frames that
+// break the protocol. Frames are decoded with the client codec, so the dump
+// never drifts from what the client sends.
+//
+// Usage:
+//
+//	protodump -capture session.jsonl            decodes a capture recorded by the client
+//	protodump [-raw frames.bin] < frames.bin    decodes a raw byte stream, stdin by default
+//	protodump -listen :12346 -target server:12345
+//	                                            relays connections to the server dumping them
+//
+// The exit code is 1 if any violation was found.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	capturePath := flag.String("capture", "", "capture file recorded by the client")
+	rawPath := flag.String("raw", "-", "file with a raw stream of frames, - for stdin")
+	listen := flag.String("listen", "", "address to listen on as a relay")
+	target := flag.String("target", "", "server address the relay forwards to")
+	flag.Parse()
+
+	dumper := NewDumper(os.Stdout)
+	var err error
+	switch {
+	case *listen != "" || *target != "":
+		if *listen == "" || *target == "" {
+			fmt.Fprintln(os.Stderr, "action: protodump | result: fail | error: -listen and -target go together")
+			os.Exit(2)
+		}
+		err = relay(dumper, *listen, *target)
+	case *capturePath != "":
+		err = dumpFile(*capturePath, dumper.DumpCapture)
+	default:
+		err = dumpFile(*rawPath, func(r io.Reader) error {
+			return dumper.DumpStream(r, Origin{}, nil, nil)
+		})
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "action: protodump | result: fail | error: %v\n", err)
+		os.Exit(2)
+	}
+	fmt.Printf("frames: %v | violations: %v\n", dumper.Frames(), dumper.Violations())
+	if dumper.Violations() > 0 {
+		os.Exit(1)
+	}
+}
+
+// dumpFile Dumps the file at path, or stdin if path is -
+func dumpFile(path string, dump func(io.Reader) error) error {
+	if path == "-" {
+		return dump(os.Stdin)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return dump(file)
+}
+
+// relay Relays connections until SIGINT or SIGTERM
+func relay(dumper *Dumper, listen string, target string) error {
+	r, err := StartRelay(listen, target, dumper)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("relaying %v -> %v\n", r.Addr(), target)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	<-signals
+	return r.Close()
+}
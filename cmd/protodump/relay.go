@@ -0,0 +1,203 @@
+package main
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/capture"
+)
+
+// Relay Transparent TCP relay that forwards connections to a target
+// untouched while dumping the frames going both ways
+type Relay struct {
+	target   string
+	dumper   *Dumper
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu        sync.Mutex
+	closed    bool
+	conns     map[net.Conn]bool
+	connCount int
+}
+
+// StartRelay Listens on address and relays connections to target in
+// background
+func StartRelay(address string, target string, dumper *Dumper) (*Relay, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	r := &Relay{
+		target:   target,
+		dumper:   dumper,
+		listener: listener,
+		conns:    make(map[net.Conn]bool),
+	}
+	r.wg.Add(1)
+	go r.accept()
+	return r, nil
+}
+
+// Addr Address clients must connect to
+func (r *Relay) Addr() string {
+	return r.listener.Addr().String()
+}
+
+// Close Stops accepting connections, closes the relayed ones and waits for
+// every frame to be dumped
+func (r *Relay) Close() error {
+	err := r.listener.Close()
+	r.mu.Lock()
+	r.closed = true
+	for conn := range r.conns {
+		conn.Close()
+	}
+	r.mu.Unlock()
+	r.wg.Wait()
+	return err
+}
+
+func (r *Relay) accept() {
+	defer r.wg.Done()
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			return
+		}
+		r.mu.Lock()
+		r.connCount++
+		id := r.connCount
+		r.mu.Unlock()
+
+		r.wg.Add(1)
+		go r.relay(conn, id)
+	}
+}
+
+// track Registers the connection so Close can interrupt it. Returns false if
+// the relay is already closed
+func (r *Relay) track(conn net.Conn) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return false
+	}
+	r.conns[conn] = true
+	return true
+}
+
+func (r *Relay) untrack(conn net.Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.conns, conn)
+}
+
+func (r *Relay) relay(client net.Conn, id int) {
+	defer r.wg.Done()
+	defer client.Close()
+	if !r.track(client) {
+		return
+	}
+	defer r.untrack(client)
+
+	server, err := net.Dial("tcp", r.target)
+	if err != nil {
+		r.dumper.violation(Origin{Time: time.Now(), Conn: id}, "could not connect to "+r.target+": "+err.Error())
+		return
+	}
+	defer server.Close()
+	if !r.track(server) {
+		return
+	}
+	defer r.untrack(server)
+
+	handshake := NewHandshake()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		r.forward(client, server, Origin{Conn: id, Direction: capture.Sent}, handshake)
+	}()
+	go func() {
+		defer wg.Done()
+		r.forward(server, client, Origin{Conn: id, Direction: capture.Received}, handshake)
+	}()
+	wg.Wait()
+}
+
+// forward Copies src into dst while dumping the copied bytes. A close of src
+// is forwarded as a half-close of dst. Both directions of a connection share
+// its handshake, so the dumper of one direction may wait for the other one:
+// the copied bytes are buffered for it and the copy never waits for the dump
+func (r *Relay) forward(src net.Conn, dst net.Conn, origin Origin, handshake *Handshake) {
+	buffer := newStreamBuffer()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.dumper.DumpStream(buffer, origin, time.Now, handshake)
+		// Keep consuming after a violation so the buffer does not grow
+		io.Copy(io.Discard, buffer)
+	}()
+
+	io.Copy(io.MultiWriter(dst, buffer), src)
+	buffer.Close()
+	if c, ok := dst.(interface{ CloseWrite() error }); ok {
+		c.CloseWrite()
+	} else {
+		dst.Close()
+	}
+	<-done
+}
+
+// streamBuffer Unbounded pipe from the relayed stream to its dumper. Writes
+// never block, reads wait for bytes until the buffer is closed
+type streamBuffer struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	data   []byte
+	closed bool
+}
+
+func newStreamBuffer() *streamBuffer {
+	b := &streamBuffer{}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+func (b *streamBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.data = append(b.data, p...)
+	b.cond.Signal()
+	return len(p), nil
+}
+
+func (b *streamBuffer) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for len(b.data) == 0 && !b.closed {
+		b.cond.Wait()
+	}
+	if len(b.data) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, b.data)
+	b.data = b.data[n:]
+	if len(b.data) == 0 {
+		// Release the bytes already dumped
+		b.data = nil
+	}
+	return n, nil
+}
+
+// Close Ends the stream once the buffered bytes are read
+func (b *streamBuffer) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	b.cond.Broadcast()
+	return nil
+}
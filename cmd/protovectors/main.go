@@ -0,0 +1,29 @@
+// Command protovectors writes the protocol conformance vectors: one JSON
+// file per case of the conformance package, encoded with the client codec.
+// Files of cases that no longer exist are removed, so the directory always
+// matches the cases.
+//
+// Usage:
+//
+//	protovectors [-dir protocol/vectors]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol/conformance"
+)
+
+func main() {
+	dir := flag.String("dir", "protocol/vectors", "directory the vectors are written to")
+	flag.Parse()
+
+	written, removed, err := conformance.WriteVectors(*dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "action: generate_vectors | result: fail | error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("action: generate_vectors | result: success | dir: %v | vectors: %v | removed: %v\n", *dir, written, removed)
+}
@@ -0,0 +1,87 @@
+// Command replay sends the frames of a capture recorded by the client (see
+// the record.path setting) to a server and diffs its responses against the
+// recorded ones, so a misbehaving session can be reproduced and turned into
+// a regression test.
+//
+// Usage:
+//
+//	replay [-speed 1] [-timeout 10s] [-secret-file secret] <capture> <server address>
+//
+// With -speed 1 frames are sent with the recorded timing, higher values
+// compress it and 0 sends every frame as soon as the previous response
+// arrives. Servers that authenticate the frames need -secret-file, the
+// secret of the agency of the capture, since captures keep the frames
+// without their trailer. The exit code is 0 if every response matches, 1
+// otherwise.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/auth"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/capture"
+)
+
+func main() {
+	speed := flag.Float64("speed", 1, "timing factor: 1 keeps the recorded timing, 0 does not wait")
+	timeout := flag.Duration("timeout", 10*time.Second, "maximum time to wait for each response")
+	secretFile := flag.String("secret-file", "", "secret of the agency, to authenticate the frames sent")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: replay [-speed 1] [-timeout 10s] [-secret-file secret] <capture> <server address>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 || *speed < 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var secret []byte
+	if *secretFile != "" {
+		var err error
+		if secret, err = auth.LoadSecret(*secretFile); err != nil {
+			fmt.Fprintf(os.Stderr, "action: replay | result: fail | error: %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	file, err := os.Open(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "action: replay | result: fail | error: %v\n", err)
+		os.Exit(2)
+	}
+	records, err := capture.ReadAll(file)
+	file.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "action: replay | result: fail | error: %v\n", err)
+		os.Exit(2)
+	}
+
+	result, err := Replay(records, flag.Arg(1), Options{Speed: *speed, Timeout: *timeout, Secret: secret})
+	for _, diff := range result.Diffs {
+		got := describe(diff.Got)
+		if diff.Err != nil {
+			got = diff.Err.Error()
+		}
+		fmt.Printf("action: replay_diff | result: fail | conn: %v | record: %v | expected: %v | got: %v\n",
+			diff.Conn, diff.Record, describe(diff.Expected), got)
+	}
+	if err != nil {
+		fmt.Printf("action: replay | result: fail | sent: %v | received: %v | diffs: %v | error: %v\n",
+			result.Sent, result.Received, len(result.Diffs), err)
+		os.Exit(1)
+	}
+
+	status := "success"
+	if len(result.Diffs) > 0 {
+		status = "fail"
+	}
+	fmt.Printf("action: replay | result: %v | sent: %v | received: %v | diffs: %v\n",
+		status, result.Sent, result.Received, len(result.Diffs))
+	if len(result.Diffs) > 0 {
+		os.Exit(1)
+	}
+}
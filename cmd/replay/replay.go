@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/auth"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/capture"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+)
+
+// Options How a capture is replayed
+type Options struct {
+	// Speed Factor applied to the recorded timing: 1 keeps it, 10 replays
+	// ten times faster and 0 sends every frame without waiting
+	Speed float64
+	// Timeout Maximum time to wait for each response
+	Timeout time.Duration
+	// Secret Secret of the agency, for servers that authenticate the frames.
+	// Captures keep the frames without their trailer, so every frame sent is
+	// sealed again as the agency of the first hello of the capture
+	Secret []byte
+}
+
+// ErrUnknownAgency The capture sends frames to authenticate before any
+// hello, so the agency they must be sealed as is unknown
+var ErrUnknownAgency = errors.New("replay: no hello before the first frame to authenticate")
+
+// Diff A response of the server that differs from the recorded one
+type Diff struct {
+	Conn int
+	// Record Position of the response in the capture, starting at 1
+	Record   int
+	Expected []byte
+	// Got Bytes of the frame received instead, if any
+	Got []byte
+	// Err Why no response could be read
+	Err error
+}
+
+// Result Outcome of a replay
+type Result struct {
+	Sent     int
+	Received int
+	Diffs    []Diff
+}
+
+// Replay Sends the frames the client sent in the capture to the server at
+// address, over one connection per recorded connection, and compares every
+// response with the one recorded. Captures keep the frames without their
+// checksum, so each connection follows its handshake and switches to the
+// framing the server negotiates, like the client does. An error is returned
+// if the replay could not go on, like when the server is not reachable
+func Replay(records []capture.Record, address string, options Options) (Result, error) {
+	var result Result
+	var conn net.Conn
+	current := 0
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+
+	// hello Hello sent on the current connection, waiting for its reply
+	var hello *protocol.Hello
+	var signer *auth.Signer
+	var framing protocol.Framing
+	var previous time.Time
+	for i, record := range records {
+		if record.Direction == capture.Sent && options.Speed > 0 && !previous.IsZero() {
+			if wait := record.Time.Sub(previous); wait > 0 {
+				time.Sleep(time.Duration(float64(wait) / options.Speed))
+			}
+		}
+		previous = record.Time
+
+		// The client uses a single connection at a time, so a new
+		// connection number means the previous one was closed
+		if conn == nil || record.Conn != current {
+			if conn != nil {
+				conn.Close()
+			}
+			var err error
+			if conn, err = net.DialTimeout("tcp", address, options.Timeout); err != nil {
+				return result, err
+			}
+			current = record.Conn
+			hello, framing = nil, initialFraming
+		}
+		if options.Timeout > 0 {
+			conn.SetDeadline(time.Now().Add(options.Timeout))
+		}
+
+		switch record.Direction {
+		case capture.Sent:
+			if sent := helloOf(record); sent != nil {
+				hello = sent
+				if signer == nil && len(options.Secret) > 0 {
+					signer = auth.NewSigner(sent.Agency, options.Secret, time.Now)
+				}
+			}
+			if signer == nil && len(options.Secret) > 0 {
+				return result, fmt.Errorf("record %v: %w", i+1, ErrUnknownAgency)
+			}
+			if err := send(conn, framing, signer, record); err != nil {
+				return result, fmt.Errorf("record %v: %w", i+1, err)
+			}
+			result.Sent++
+		case capture.Received:
+			result.Received++
+			frame, err := framing.ReadFrame(conn)
+			if err != nil {
+				// The connection is unusable after a failed read, the
+				// next record opens a new one
+				result.Diffs = append(result.Diffs, Diff{Conn: record.Conn, Record: i + 1, Expected: record.Frame, Err: err})
+				conn.Close()
+				conn = nil
+				continue
+			}
+			if hello != nil {
+				framing = negotiate(*hello, frame)
+				hello = nil
+			}
+			got, err := capture.NewRecord(record.Time, record.Conn, record.Direction, frame)
+			if err != nil || !bytes.Equal(got.Frame, record.Frame) {
+				result.Diffs = append(result.Diffs, Diff{Conn: record.Conn, Record: i + 1, Expected: record.Frame, Got: got.Frame})
+			}
+		}
+	}
+	return result, nil
+}
+
+// initialFraming Framing of a connection until its handshake is over.
+// Frames larger than a frame, of captures without handshake, are still sent
+// as continuation frames
+var initialFraming = protocol.Framing{Continuation: true}
+
+// send Writes the frame of the record with the framing of the connection,
+// sealed by the signer if there is one. Records that cannot be decoded are
+// written as they are
+func send(conn net.Conn, framing protocol.Framing, signer *auth.Signer, record capture.Record) error {
+	frame, err := record.Decode()
+	if err != nil {
+		_, err = conn.Write(record.Frame)
+		return err
+	}
+	if signer != nil {
+		frame = signer.Seal(frame)
+	}
+	return framing.WriteFrame(conn, frame)
+}
+
+// helloOf Returns the hello of the record, or nil if it holds another frame
+func helloOf(record capture.Record) *protocol.Hello {
+	frame, err := record.Decode()
+	if err != nil || frame.Type != protocol.TypeHello {
+		return nil
+	}
+	msg, err := protocol.Decode(frame)
+	if err != nil {
+		return nil
+	}
+	hello := msg.(protocol.Hello)
+	return &hello
+}
+
+// negotiate Returns the framing of the session opened by the reply to the
+// hello. Nothing is enabled if the reply is not a HelloReply of the version
+// of the hello
+func negotiate(hello protocol.Hello, response protocol.Frame) protocol.Framing {
+	msg, err := protocol.Decode(response)
+	if err != nil {
+		return initialFraming
+	}
+	reply, ok := msg.(protocol.HelloReply)
+	if !ok || reply.Version != hello.Version {
+		return initialFraming
+	}
+	session := protocol.Negotiate(hello.Capabilities, reply.Capabilities)
+	return protocol.Framing{
+		Continuation: protocol.Has(session, protocol.CapabilityLargeFrames),
+		Checksums:    protocol.Has(session, protocol.CapabilityChecksums),
+	}
+}
+
+// describe Formats the bytes of a frame as its decoded message, falling back
+// to hexadecimal when they cannot be decoded
+func describe(data []byte) string {
+	if data == nil {
+		return "nothing"
+	}
+	frame, err := (capture.Record{Frame: data}).Decode()
+	if err == nil {
+		if msg, err := protocol.Decode(frame); err == nil {
+			return fmt.Sprintf("%v%+v", msg.Type(), msg)
+		}
+	}
+	return fmt.Sprintf("% x", data)
+}
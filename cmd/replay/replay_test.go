@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/auth"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/capture"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/fakeserver"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/metrics"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+)
+
+var update = flag.Bool("update", false, "record the captures in testdata again")
+
+const dataset = `Valentina,Vera,30170921,1982-05-22,6053
+Santiago,Álvarez,33936970,1986-04-25,7068
+Martina,Borges,21073376,1994-09-01,6293
+Lionel,Lorca,30904465,1999-03-17,7574
+`
+
+// record Runs a client in bets mode, offering the capabilities, against the
+// server and returns the capture of its session
+func record(t *testing.T, server *fakeserver.Server, capabilities ...string) []byte {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "agency-1.csv")
+	if err := os.WriteFile(path, []byte(dataset), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	config := common.ClientConfig{
+		ID:            "1",
+		ServerAddress: server.Addr(),
+		Mode:          common.ModeBets,
+		Bets:          common.BetsConfig{DatasetPath: path, BatchMaxAmount: 2, WinnersPeriod: time.Millisecond},
+		Timeout:       time.Second,
+		RetryAttempts: 1,
+		Capabilities:  capabilities,
+	}
+	client := common.NewClient(config, common.NewClientMetrics(metrics.NewRegistry()), common.WithRecorder(capture.NewWriter(&buf)))
+	if err := client.StartClientLoop(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func startServer(t *testing.T) *fakeserver.Server {
+	t.Helper()
+	server, err := fakeserver.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+func TestReplayOfARecordedSessionMatches(t *testing.T) {
+	recorded := startServer(t)
+	recorded.SetPendingQueries(1)
+	recorded.SetWinners("1", "30904465")
+	records, err := capture.ReadAll(bytes.NewReader(record(t, recorded)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The handshake, two batches and the end of delivery on the first
+	// connection, then two winners queries on connections of their own,
+	// each opened by a handshake
+	if len(records) != 16 || records[len(records)-1].Conn != 3 {
+		t.Fatalf("unexpected capture %+v", records)
+	}
+
+	server := startServer(t)
+	server.SetPendingQueries(1)
+	server.SetWinners("1", "30904465")
+	result, err := Replay(records, server.Addr(), Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Diffs) != 0 || result.Sent != 8 || result.Received != 8 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if stored := len(server.Bets("1")); stored != 4 {
+		t.Fatalf("expected the replay to store 4 bets, got %v", stored)
+	}
+}
+
+// TestReplayFollowsTheNegotiatedFraming Replays a session with checksums,
+// whose frames travel with a trailer the capture does not keep
+func TestReplayFollowsTheNegotiatedFraming(t *testing.T) {
+	recorded := startServer(t)
+	recorded.SetWinners("1", "30904465")
+	records, err := capture.ReadAll(bytes.NewReader(record(t, recorded, common.SupportedCapabilities...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := startServer(t)
+	server.SetWinners("1", "30904465")
+	result, err := Replay(records, server.Addr(), Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Diffs) != 0 || result.Sent != 6 || result.Received != 6 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if stored := len(server.Bets("1")); stored != 4 || server.CorruptFrames() != 0 {
+		t.Fatalf("expected the replay to store 4 bets without corrupt frames, got %v and %v", stored, server.CorruptFrames())
+	}
+
+	// A server that does not enable checksums is answered without them
+	server = startServer(t)
+	server.SetCapabilities()
+	server.SetWinners("1", "30904465")
+	result, err = Replay(records, server.Addr(), Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Diffs) != 2 || result.Diffs[0].Record != 2 || len(server.Bets("1")) != 4 {
+		t.Fatalf("expected only the hello replies to differ, got %+v", result.Diffs)
+	}
+}
+
+// TestReplaySealsTheFramesForServersThatAuthenticate Replays a capture,
+// which keeps the frames without their trailer, against a server that
+// authenticates every frame
+func TestReplaySealsTheFramesForServersThatAuthenticate(t *testing.T) {
+	records, err := capture.ReadAll(bytes.NewReader(record(t, startServer(t))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets := auth.Secrets{"1": []byte("secret-1")}
+
+	server := startServer(t)
+	server.RequireAuth(auth.NewVerifier(secrets, 0, time.Now))
+	result, err := Replay(records, server.Addr(), Options{Timeout: time.Second, Secret: secrets["1"]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Diffs) != 0 || len(server.Bets("1")) != 4 || len(server.AuthErrors()) != 0 {
+		t.Fatalf("expected every frame to be accepted, got %+v and %v", result.Diffs, server.AuthErrors())
+	}
+
+	// Without the secret the server drops the first connection at its hello
+	server = startServer(t)
+	server.RequireAuth(auth.NewVerifier(secrets, 0, time.Now))
+	result, err = Replay(records, server.Addr(), Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Diffs) == 0 || result.Diffs[0].Record != 2 || result.Diffs[0].Err == nil {
+		t.Fatalf("expected the hello reply to be missing, got %+v", result.Diffs)
+	}
+	if errs := server.AuthErrors(); len(errs) == 0 || !errors.Is(errs[0], auth.ErrTruncated) {
+		t.Fatalf("expected the unsealed hello to be rejected, got %v", errs)
+	}
+
+	// The agency of the frames is only known from the hello
+	if _, err := Replay(records[2:], server.Addr(), Options{Timeout: time.Second, Secret: secrets["1"]}); !errors.Is(err, ErrUnknownAgency) {
+		t.Fatalf("expected a capture without hello to fail, got %v", err)
+	}
+}
+
+func TestReplayReportsDifferentResponses(t *testing.T) {
+	records, err := capture.ReadAll(bytes.NewReader(record(t, startServer(t))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := startServer(t)
+	server.RejectDocuments("21073376")
+	server.SetWinners("1", "30904465")
+	result, err := Replay(records, server.Addr(), Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Diffs) != 2 {
+		t.Fatalf("expected the second ack and the winners to differ, got %+v", result.Diffs)
+	}
+	if diff := result.Diffs[0]; diff.Conn != 1 || diff.Record != 6 {
+		t.Fatalf("unexpected diff %+v", diff)
+	}
+	if expected, got := describe(result.Diffs[0].Expected), describe(result.Diffs[0].Got); expected != "ack{BatchID:2 Count:2}" || got != "reject{BatchID:2 Indexes:[0]}" {
+		t.Fatalf("unexpected description of the diff: expected %v, got %v", expected, got)
+	}
+}
+
+func TestReplayReportsMissingResponses(t *testing.T) {
+	records, err := capture.ReadAll(bytes.NewReader(record(t, startServer(t))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := startServer(t)
+	server.Script(fakeserver.Action{Kind: fakeserver.Hangup})
+	result, err := Replay(records, server.Addr(), Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first batch is never answered and the second one is sent again
+	// over a new connection
+	if len(result.Diffs) != 1 || result.Diffs[0].Err == nil || result.Diffs[0].Record != 4 {
+		t.Fatalf("unexpected diffs %+v", result.Diffs)
+	}
+}
+
+func TestReplayRespectsOrCompressesTheTiming(t *testing.T) {
+	query, _ := protocol.Encode(protocol.WinnersQuery{Agency: "1"})
+	winners, _ := protocol.Encode(protocol.Winners{})
+	at := time.Now()
+	var records []capture.Record
+	for i := 0; i < 2; i++ {
+		sent, _ := capture.NewRecord(at.Add(time.Duration(i)*200*time.Millisecond), i+1, capture.Sent, query)
+		received, _ := capture.NewRecord(at.Add(time.Duration(i)*200*time.Millisecond+time.Millisecond), i+1, capture.Received, winners)
+		records = append(records, sent, received)
+	}
+	server := startServer(t)
+
+	for _, test := range []struct {
+		speed    float64
+		min, max time.Duration
+	}{
+		{speed: 1, min: 190 * time.Millisecond, max: time.Second},
+		{speed: 10, min: 19 * time.Millisecond, max: 150 * time.Millisecond},
+		{speed: 0, min: 0, max: 150 * time.Millisecond},
+	} {
+		start := time.Now()
+		result, err := Replay(records, server.Addr(), Options{Speed: test.speed, Timeout: time.Second})
+		elapsed := time.Since(start)
+		if err != nil || len(result.Diffs) != 0 {
+			t.Fatalf("speed %v: %+v, %v", test.speed, result, err)
+		}
+		if elapsed < test.min || elapsed > test.max {
+			t.Fatalf("speed %v: expected between %v and %v, took %v", test.speed, test.min, test.max, elapsed)
+		}
+	}
+}
+
+// TestRecordedCapturesStillMatch Replays every capture in testdata against
+// a fresh fake server. Captures of sessions that exposed a bug can be
+// dropped there to keep it from coming back
+func TestRecordedCapturesStillMatch(t *testing.T) {
+	if *update {
+		capture := record(t, startServer(t))
+		if err := os.WriteFile(filepath.Join("testdata", "bets.jsonl"), capture, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	paths, err := filepath.Glob(filepath.Join("testdata", "*.jsonl"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no captures found in testdata: %v", err)
+	}
+	for _, path := range paths {
+		t.Run(strings.TrimSuffix(filepath.Base(path), ".jsonl"), func(t *testing.T) {
+			file, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			records, err := capture.ReadAll(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := Replay(records, startServer(t).Addr(), Options{Timeout: time.Second})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, diff := range result.Diffs {
+				t.Errorf("record %v: expected %v, got %v %v", diff.Record, describe(diff.Expected), describe(diff.Got), diff.Err)
+			}
+		})
+	}
+}
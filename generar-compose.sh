@@ -0,0 +1,4 @@
+#!/bin/bash
+echo "Nombre del archivo de salida: $1"
+echo "Cantidad de clientes: $2"
+go run -mod vendor ./cmd/composegen "$1" "$2"
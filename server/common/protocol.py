@@ -0,0 +1,61 @@
+"""
+Decoding of the messages of the lottery protocol spoken by the Go client. The
+frames and payloads are described in the README and pinned by the vectors in
+protocol/vectors, which tests/test_protocol_vectors.py decodes with it.
+"""
+
+
+""" Names of the message types, by the byte that identifies them. """
+MESSAGE_TYPES = {
+    1: 'bets',
+    2: 'ack',
+    3: 'reject',
+    4: 'delivery_ended',
+    5: 'winners_query',
+    6: 'winners',
+    7: 'winners_pending',
+    8: 'hello',
+    9: 'hello_reply',
+}
+
+""" Fields of a bet, in the order they are written in a batch. """
+BET_FIELDS = ['agency', 'first_name', 'last_name', 'document', 'birthdate', 'number']
+
+
+def decode_frame(data: bytes) -> tuple[str, dict]:
+    """
+    Decodes a single frame: 2 bytes big endian length of the body, 1 byte
+    message type and the UTF-8 payload. Returns the name of the message type
+    and the fields of the message, named as in the conformance vectors
+    """
+    length = int.from_bytes(data[:2], byteorder='big')
+    if length == 0 or length != len(data) - 2:
+        raise ValueError(f'invalid frame length {length} for {len(data)} bytes')
+    msg_type = MESSAGE_TYPES[data[2]]
+    payload = data[3:].decode('utf-8')
+
+    if msg_type == 'bets':
+        lines = payload.split('\n')
+        bets = [dict(zip(BET_FIELDS, line.split('|'))) for line in lines[1:]]
+        return msg_type, {'batch_id': int(lines[0]), 'bets': bets}
+    if msg_type == 'ack':
+        batch_id, count = payload.split('|')
+        return msg_type, {'batch_id': int(batch_id), 'count': int(count)}
+    if msg_type == 'reject':
+        batch_id, indexes = payload.split('|')
+        return msg_type, {'batch_id': int(batch_id), 'indexes': [int(i) for i in indexes.split(',') if i]}
+    if msg_type in ('delivery_ended', 'winners_query'):
+        return msg_type, {'agency': payload}
+    if msg_type == 'winners':
+        return msg_type, {'documents': payload.split('|') if payload else []}
+    if msg_type == 'hello':
+        version, agency, capabilities = payload.split('|')
+        return msg_type, {
+            'version': int(version),
+            'agency': agency,
+            'capabilities': capabilities.split(',') if capabilities else [],
+        }
+    if msg_type == 'hello_reply':
+        version, capabilities = payload.split('|')
+        return msg_type, {'version': int(version), 'capabilities': capabilities.split(',') if capabilities else []}
+    return msg_type, {}
@@ -0,0 +1,30 @@
+from common.protocol import decode_frame
+import json
+import os
+import unittest
+
+# Conformance vectors shared with the Go client, generated with
+# `go run ./cmd/protovectors` from the root of the repository
+VECTORS_DIR = os.path.join(os.path.dirname(__file__), '..', '..', 'protocol', 'vectors')
+
+
+class TestProtocolVectors(unittest.TestCase):
+
+    def vectors(self):
+        if not os.path.isdir(VECTORS_DIR):
+            self.skipTest(f'{VECTORS_DIR} not found')
+        for name in sorted(os.listdir(VECTORS_DIR)):
+            if name.endswith('.json'):
+                with open(os.path.join(VECTORS_DIR, name), encoding='utf-8') as f:
+                    yield json.load(f)
+
+    def test_every_vector_decodes_to_its_message(self):
+        for vector in self.vectors():
+            with self.subTest(vector=vector['name']):
+                msg_type, fields = decode_frame(bytes.fromhex(vector['encoded']))
+                self.assertEqual(vector['type'], msg_type)
+                self.assertEqual(vector['message'], fields)
+
+
+if __name__ == '__main__':
+    unittest.main()
@@ -0,0 +1,7 @@
+#!/bin/bash
+# Validates the echo server from inside the compose network. The checker is
+# shipped in the client image, so neither netcat nor exposed ports are needed
+NETWORK=${NETWORK:-tp0_testing_net}
+SERVER_ADDRESS=${SERVER_ADDRESS:-server:12345}
+
+docker run --rm --network "$NETWORK" --entrypoint /echocheck client:latest -address "$SERVER_ADDRESS" "$@"